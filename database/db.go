@@ -36,11 +36,34 @@ func Init() {
 
 	log.Println("Database connection established.")
 
-	err = DB.AutoMigrate(&models.WatchedUrl{}, &models.ChangeEvent{}, &models.URLGroup{})
-
+	err = Migrate(DB)
 	if err != nil {
 		log.Fatalf("Failed to auto migrate database: %v", err)
 	}
 
 	log.Println("Database migrations completed.")
 }
+
+// Migrate creates or updates the tables of every model.
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&models.WatchedUrl{}, &models.ChangeEvent{}, &models.URLGroup{}, &models.Snapshot{}, &models.IgnoreRule{}, &models.RequestConfig{}, &models.APIToken{}, &models.Feed{}, &models.User{}, &models.ChangeRead{}, &models.RecoveryCode{}, &models.LoginAttempt{}, &models.AuditEvent{}, &models.InventoryChange{}, &models.SourceFile{}, &models.SourceFileChange{}, &models.Endpoint{})
+}
+
+// OpenInMemory opens a new, migrated in-memory database, for tests.
+func OpenInMemory() (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// Every connection to :memory: gets its own database, so keep just one.
+	sqlDB.SetMaxOpenConns(1)
+	if err := Migrate(db); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,955 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	apiTokenContextKey = "api_token"
+
+	defaultPerPage = 50
+	maxPerPage     = 200
+)
+
+// apiError writes the JSON error body used by every /api/v1 endpoint:
+// {"error": {"status": 404, "code": "not_found", "message": "..."}}.
+func apiError(c echo.Context, status int, message string) error {
+	code := strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	if code == "" {
+		code = "error"
+	}
+	return c.JSON(status, echo.Map{
+		"error": echo.Map{
+			"status":  status,
+			"code":    code,
+			"message": message,
+		},
+	})
+}
+
+// APIErrorHandler wraps Echo's error handler so errors raised for /api/ paths
+// (unknown routes, wrong methods, panics) use the same JSON error body as the handlers.
+func APIErrorHandler(next echo.HTTPErrorHandler) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if c.Response().Committed || !strings.HasPrefix(c.Request().URL.Path, "/api/") {
+			next(err, c)
+			return
+		}
+		status := http.StatusInternalServerError
+		message := http.StatusText(status)
+		if he, ok := err.(*echo.HTTPError); ok {
+			status = he.Code
+			message = fmt.Sprint(he.Message)
+		}
+		apiError(c, status, message)
+	}
+}
+
+// APIAuthMiddleware authenticates API requests with an "Authorization: Bearer <token>" header.
+// Requests act as the user who created the token, with that user's role.
+func APIAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+		if !ok || strings.TrimSpace(token) == "" {
+			return apiError(c, http.StatusUnauthorized, "Missing API token. Send it as \"Authorization: Bearer <token>\".")
+		}
+
+		var apiToken models.APIToken
+		result := database.DB.Where("token_hash = ? AND revoked_at IS NULL", services.HashAPIToken(strings.TrimSpace(token))).First(&apiToken)
+		if result.Error == gorm.ErrRecordNotFound {
+			return apiError(c, http.StatusUnauthorized, "Invalid or revoked API token.")
+		} else if result.Error != nil {
+			return apiError(c, http.StatusInternalServerError, "Database error checking API token: "+result.Error.Error())
+		}
+
+		var user models.User
+		if result := database.DB.First(&user, apiToken.UserID); result.Error != nil {
+			return apiError(c, http.StatusUnauthorized, "The owner of this API token no longer exists.")
+		}
+
+		// Record usage at most once a minute to avoid a write on every request.
+		now := time.Now().UTC()
+		if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) > time.Minute {
+			database.DB.Model(&apiToken).UpdateColumn("last_used_at", now)
+		}
+
+		c.Set(apiTokenContextKey, &apiToken)
+		c.Set(userContextKey, &user)
+		return next(c)
+	}
+}
+
+// --- Response types ---
+
+type apiURL struct {
+	ID                  uint       `json:"id"`
+	URL                 string     `json:"url"`
+	IntervalSeconds     int        `json:"interval_seconds"`
+	IsActive            bool       `json:"is_active"`
+	Beautify            bool       `json:"beautify"`
+	AllowPrivateTarget  bool       `json:"allow_private_target"`
+	GroupID             *uint      `json:"group_id"`
+	Status              string     `json:"status"`
+	LastChecked         *time.Time `json:"last_checked"`
+	NextCheckAt         *time.Time `json:"next_check_at"`
+	IsDown              bool       `json:"is_down"`
+	DownSince           *time.Time `json:"down_since"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Chunks              []string   `json:"chunks"`
+	SourceMapURL        string     `json:"source_map_url"`
+	SourceMapStatus     string     `json:"source_map_status"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+func newAPIURL(u models.WatchedUrl) apiURL {
+	return apiURL{
+		ID:                  u.ID,
+		URL:                 u.URL,
+		IntervalSeconds:     u.IntervalSeconds,
+		IsActive:            u.IsActive,
+		Beautify:            u.Beautify,
+		AllowPrivateTarget:  u.AllowPrivateTarget,
+		GroupID:             u.GroupID,
+		Status:              strings.TrimSpace(u.Status),
+		LastChecked:         u.LastChecked,
+		NextCheckAt:         u.NextCheckAt,
+		IsDown:              u.IsDown,
+		DownSince:           u.DownSince,
+		ConsecutiveFailures: u.ConsecutiveFailures,
+		Chunks:              u.ChunkURLs(),
+		SourceMapURL:        u.SourceMapURL,
+		SourceMapStatus:     u.SourceMapStatus,
+		CreatedAt:           u.CreatedAt,
+		UpdatedAt:           u.UpdatedAt,
+	}
+}
+
+type apiGroup struct {
+	ID                  uint       `json:"id"`
+	Name                string     `json:"name"`
+	SourceURL           string     `json:"source_url"`
+	URLCount            int64      `json:"url_count"`
+	ReextractHours      int        `json:"reextract_hours"`
+	AutoWatchNewScripts bool       `json:"auto_watch_new_scripts"`
+	AutoWatchChunks     bool       `json:"auto_watch_chunks"`
+	LastExtractedAt     *time.Time `json:"last_extracted_at"`
+	ExtractStatus       string     `json:"extract_status"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+func newAPIGroup(g models.URLGroup) (apiGroup, error) {
+	group := apiGroup{
+		ID:                  g.ID,
+		Name:                g.Name,
+		SourceURL:           g.SourceURL,
+		ReextractHours:      g.ReextractHours,
+		AutoWatchNewScripts: g.AutoWatchNewScripts,
+		AutoWatchChunks:     g.AutoWatchChunks,
+		LastExtractedAt:     g.LastExtractedAt,
+		ExtractStatus:       g.ExtractStatus,
+		CreatedAt:           g.CreatedAt,
+		UpdatedAt:           g.UpdatedAt,
+	}
+	result := database.DB.Model(&models.WatchedUrl{}).Where("group_id = ?", g.ID).Count(&group.URLCount)
+	return group, result.Error
+}
+
+type apiChange struct {
+	ID                 uint            `json:"id"`
+	URLID              uint            `json:"url_id"`
+	DetectedAt         time.Time       `json:"detected_at"`
+	IsRead             bool            `json:"is_read"`
+	SnapshotID         *uint           `json:"snapshot_id"`
+	PreviousSnapshotID *uint           `json:"previous_snapshot_id"`
+	DiffHTML           string          `json:"diff_html,omitempty"` // Only included when fetching a single change
+	SourceFiles        []apiSourceFile `json:"source_files"`
+	AddedEndpoints     []string        `json:"added_endpoints"`
+	RemovedEndpoints   []string        `json:"removed_endpoints"`
+}
+
+type apiSourceFile struct {
+	Path     string `json:"path"`
+	Status   string `json:"status"`
+	DiffHTML string `json:"diff_html,omitempty"` // Only included when fetching a single change
+}
+
+func newAPIChange(ch models.ChangeEvent, withDiff bool) apiChange {
+	change := apiChange{
+		ID:                 ch.ID,
+		URLID:              ch.URLID,
+		DetectedAt:         ch.DetectedAt,
+		IsRead:             ch.IsRead,
+		SnapshotID:         ch.SnapshotID,
+		PreviousSnapshotID: ch.PreviousSnapshotID,
+		SourceFiles:        make([]apiSourceFile, 0, len(ch.SourceFiles)),
+		AddedEndpoints:     append([]string{}, ch.AddedEndpoints()...),
+		RemovedEndpoints:   append([]string{}, ch.RemovedEndpoints()...),
+	}
+	if withDiff {
+		change.DiffHTML = ch.DiffText
+	}
+	for _, file := range ch.SourceFiles {
+		sourceFile := apiSourceFile{Path: file.Path, Status: file.Status}
+		if withDiff {
+			sourceFile.DiffHTML = file.DiffText
+		}
+		change.SourceFiles = append(change.SourceFiles, sourceFile)
+	}
+	return change
+}
+
+type apiEndpoint struct {
+	Kind        string    `json:"kind"`
+	Value       string    `json:"value"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	URLID       uint      `json:"url_id"`
+	URL         string    `json:"url"`
+	GroupID     *uint     `json:"group_id"`
+	GroupName   string    `json:"group_name,omitempty"`
+}
+
+// --- Helpers ---
+
+type apiPagination struct {
+	Page       int   `json:"page"`
+	PerPage    int   `json:"per_page"`
+	Total      int64 `json:"total"`
+	TotalPages int   `json:"total_pages"`
+}
+
+// paginate counts the rows matched by query and returns the query limited to
+// the page requested with ?page= and ?per_page=.
+func paginate(c echo.Context, query *gorm.DB) (*gorm.DB, apiPagination, error) {
+	p := apiPagination{Page: 1, PerPage: defaultPerPage}
+	if value := c.QueryParam("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return nil, p, fmt.Errorf("page must be a positive number")
+		}
+		p.Page = page
+	}
+	if value := c.QueryParam("per_page"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > maxPerPage {
+			return nil, p, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+		p.PerPage = perPage
+	}
+
+	if result := query.Count(&p.Total); result.Error != nil {
+		return nil, p, result.Error
+	}
+	p.TotalPages = int(math.Ceil(float64(p.Total) / float64(p.PerPage)))
+	return query.Offset((p.Page - 1) * p.PerPage).Limit(p.PerPage), p, nil
+}
+
+// bindJSON decodes the request body into v, rejecting unknown fields so typos
+// don't silently do nothing.
+func bindJSON(c echo.Context, v interface{}) error {
+	decoder := json.NewDecoder(c.Request().Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+func parseIDParam(c echo.Context, name string) (uint, error) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return uint(id), nil
+}
+
+// parseBoolQuery reads an optional boolean query parameter.
+func parseBoolQuery(c echo.Context, name string) (*bool, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", name)
+	}
+	return &parsed, nil
+}
+
+// validateWatchURL checks a URL against the fetch target policy.
+// allowPrivate is the admin override of the blocked networks.
+func validateWatchURL(rawURL string, allowPrivate bool) error {
+	if err := services.CheckTargetURL(rawURL, allowPrivate); err != nil {
+		return fmt.Errorf("url is not allowed: %v", err)
+	}
+	return nil
+}
+
+// allowPrivateTarget applies a request's allow_private_target field, which
+// only admins may set, to the current value. The error is the message for a
+// 403 response.
+func allowPrivateTarget(c echo.Context, requested *bool, current bool) (bool, error) {
+	if requested == nil || *requested == current {
+		return current, nil
+	}
+	if !CurrentUser(c).IsAdmin() {
+		return current, errors.New("Only admins can change allow_private_target.")
+	}
+	return *requested, nil
+}
+
+// loadURLForAPI loads the WatchedUrl named by the :id path parameter, writing
+// the JSON error response itself when it can't.
+func loadURLForAPI(c echo.Context) (*models.WatchedUrl, error) {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return nil, apiError(c, http.StatusBadRequest, err.Error())
+	}
+	var urlEntry models.WatchedUrl
+	if result := database.DB.First(&urlEntry, id); result.Error == gorm.ErrRecordNotFound {
+		return nil, apiError(c, http.StatusNotFound, "URL not found.")
+	} else if result.Error != nil {
+		return nil, apiError(c, http.StatusInternalServerError, "Database error finding URL: "+result.Error.Error())
+	}
+	return &urlEntry, nil
+}
+
+func loadGroupForAPI(c echo.Context) (*models.URLGroup, error) {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return nil, apiError(c, http.StatusBadRequest, err.Error())
+	}
+	var group models.URLGroup
+	if result := database.DB.First(&group, id); result.Error == gorm.ErrRecordNotFound {
+		return nil, apiError(c, http.StatusNotFound, "Group not found.")
+	} else if result.Error != nil {
+		return nil, apiError(c, http.StatusInternalServerError, "Database error finding group: "+result.Error.Error())
+	}
+	return &group, nil
+}
+
+func loadChangeForAPI(c echo.Context) (*models.ChangeEvent, error) {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return nil, apiError(c, http.StatusBadRequest, err.Error())
+	}
+	var change models.ChangeEvent
+	if result := database.DB.Preload("SourceFiles", func(db *gorm.DB) *gorm.DB {
+		return db.Order("path")
+	}).First(&change, id); result.Error == gorm.ErrRecordNotFound {
+		return nil, apiError(c, http.StatusNotFound, "Change not found.")
+	} else if result.Error != nil {
+		return nil, apiError(c, http.StatusInternalServerError, "Database error finding change: "+result.Error.Error())
+	}
+	if err := services.FillReadState(database.DB, CurrentUser(c).ID, []*models.ChangeEvent{&change}); err != nil {
+		return nil, apiError(c, http.StatusInternalServerError, "Database error loading read state: "+err.Error())
+	}
+	return &change, nil
+}
+
+// groupExists checks an optional group_id from a request body.
+func groupExists(groupID *uint) (bool, error) {
+	if groupID == nil {
+		return true, nil
+	}
+	var count int64
+	result := database.DB.Model(&models.URLGroup{}).Where("id = ?", *groupID).Count(&count)
+	return count > 0, result.Error
+}
+
+// --- URLs ---
+
+func APIListURLs(c echo.Context) error {
+	query := database.DB.Model(&models.WatchedUrl{})
+	if groupID := c.QueryParam("group_id"); groupID == "none" {
+		query = query.Where("group_id IS NULL")
+	} else if groupID != "" {
+		id, err := strconv.ParseUint(groupID, 10, 32)
+		if err != nil {
+			return apiError(c, http.StatusBadRequest, "group_id must be a number or \"none\"")
+		}
+		query = query.Where("group_id = ?", id)
+	}
+	for param, column := range map[string]string{"is_active": "is_active", "is_down": "is_down"} {
+		value, err := parseBoolQuery(c, param)
+		if err != nil {
+			return apiError(c, http.StatusBadRequest, err.Error())
+		}
+		if value != nil {
+			query = query.Where(column+" = ?", *value)
+		}
+	}
+
+	query, pagination, err := paginate(c, query)
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	var urls []models.WatchedUrl
+	if result := query.Order("id ASC").Find(&urls); result.Error != nil {
+		return apiError(c, http.StatusInternalServerError, "Database error listing URLs: "+result.Error.Error())
+	}
+
+	data := make([]apiURL, 0, len(urls))
+	for _, u := range urls {
+		data = append(data, newAPIURL(u))
+	}
+	return c.JSON(http.StatusOK, echo.Map{"data": data, "pagination": pagination})
+}
+
+type apiURLRequest struct {
+	URL             *string `json:"url"`
+	IntervalSeconds *int    `json:"interval_seconds"`
+	IsActive        *bool   `json:"is_active"`
+	Beautify        *bool   `json:"beautify"`
+	GroupID         *uint   `json:"group_id"`
+	// AllowPrivateTarget lets the URL reach blocked networks; only admins may set it.
+	AllowPrivateTarget *bool `json:"allow_private_target"`
+}
+
+func APICreateURL(c echo.Context) error {
+	var req apiURLRequest
+	if err := bindJSON(c, &req); err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	if req.URL == nil || *req.URL == "" {
+		return apiError(c, http.StatusUnprocessableEntity, "url is required.")
+	}
+	allowPrivate, err := allowPrivateTarget(c, req.AllowPrivateTarget, false)
+	if err != nil {
+		return apiError(c, http.StatusForbidden, err.Error())
+	}
+	if err := validateWatchURL(*req.URL, allowPrivate); err != nil {
+		return apiError(c, http.StatusUnprocessableEntity, err.Error())
+	}
+	interval := 300
+	if req.IntervalSeconds != nil {
+		if *req.IntervalSeconds <= 0 {
+			return apiError(c, http.StatusUnprocessableEntity, "interval_seconds must be a positive number.")
+		}
+		interval = *req.IntervalSeconds
+	}
+	if ok, err := groupExists(req.GroupID); err != nil {
+		return apiError(c, http.StatusInternalServerError, "Database error finding group: "+err.Error())
+	} else if !ok {
+		return apiError(c, http.StatusUnprocessableEntity, "group_id does not exist.")
+	}
+
+	var count int64
+	if result := database.DB.Unscoped().Model(&models.WatchedUrl{}).Where("url = ?", *req.URL).Count(&count); result.Error != nil {
+		return apiError(c, http.StatusInternalServerError, "Database error checking existing URL: "+result.Error.Error())
+	} else if count > 0 {
+		return apiError(c, http.StatusConflict, "This URL is already being watched or archived.")
+	}
+
+	newURL := models.WatchedUrl{
+		URL:                *req.URL,
+		IntervalSeconds:    interval,
+		Status:             "Scheduled for first check",
+		GroupID:            req.GroupID,
+		AllowPrivateTarget: allowPrivate,
+	}
+	if req.Beautify != nil {
+		newURL.Beautify = *req.Beautify
+	}
+	if result := database.DB.Create(&newURL); result.Error != nil {
+		return apiError(c, http.StatusInternalServerError, "Failed to add URL: "+result.Error.Error())
+	}
+	// IsActive has a database default of true, so an explicit false has to be written separately.
+	if req.IsActive != nil && !*req.IsActive {
+		newURL.IsActive = false
+		database.DB.Model(&newURL).Update("is_active", false)
+	}
+
+	auditURL(c, services.AuditURLAdd, newURL, fmt.Sprintf("interval %ds", newURL.IntervalSeconds))
+	if newURL.IsActive {
+		services.TriggerCheck(newURL.ID)
+	}
+	return c.JSON(http.StatusCreated, echo.Map{"data": newAPIURL(newURL)})
+}
+
+func APIGetURL(c echo.Context) error {
+	urlEntry, err := loadURLForAPI(c)
+	if urlEntry == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"data": newAPIURL(*urlEntry)})
+}
+
+func APIUpdateURL(c echo.Context) error {
+	urlEntry, err := loadURLForAPI(c)
+	if urlEntry == nil {
+		return err
+	}
+	var req apiURLRequest
+	if err := bindJSON(c, &req); err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	before := *urlEntry
+	// Only the columns in the request are written, so a check running at the
+	// same time keeps its result and doesn't overwrite the edit.
+	updates := map[string]interface{}{}
+
+	allowPrivate, err := allowPrivateTarget(c, req.AllowPrivateTarget, urlEntry.AllowPrivateTarget)
+	if err != nil {
+		return apiError(c, http.StatusForbidden, err.Error())
+	}
+	if req.AllowPrivateTarget != nil {
+		urlEntry.AllowPrivateTarget = allowPrivate
+		updates["allow_private_target"] = allowPrivate
+	}
+
+	// Sending the override checks the address again with it, so clearing it
+	// can't leave a URL watched that the policy blocks.
+	if req.AllowPrivateTarget != nil && (req.URL == nil || *req.URL == urlEntry.URL) {
+		if err := validateWatchURL(urlEntry.URL, allowPrivate); err != nil {
+			return apiError(c, http.StatusUnprocessableEntity, err.Error())
+		}
+	}
+	if req.URL != nil && *req.URL != urlEntry.URL {
+		if err := validateWatchURL(*req.URL, allowPrivate); err != nil {
+			return apiError(c, http.StatusUnprocessableEntity, err.Error())
+		}
+		var count int64
+		if result := database.DB.Unscoped().Model(&models.WatchedUrl{}).Where("url = ? AND id != ?", *req.URL, urlEntry.ID).Count(&count); result.Error != nil {
+			return apiError(c, http.StatusInternalServerError, "Database error checking existing URL: "+result.Error.Error())
+		} else if count > 0 {
+			return apiError(c, http.StatusConflict, "This URL is already being watched or archived.")
+		}
+		// A new address should be checked right away, without the old address's validators.
+		urlEntry.URL = *req.URL
+		urlEntry.NextCheckAt = nil
+		urlEntry.ETag = ""
+		urlEntry.LastModified = ""
+		updates["url"] = urlEntry.URL
+		updates["next_check_at"] = nil
+		updates["e_tag"] = ""
+		updates["last_modified"] = ""
+	}
+	if req.IntervalSeconds != nil && *req.IntervalSeconds != urlEntry.IntervalSeconds {
+		if *req.IntervalSeconds <= 0 {
+			return apiError(c, http.StatusUnprocessableEntity, "interval_seconds must be a positive number.")
+		}
+		urlEntry.IntervalSeconds = *req.IntervalSeconds
+		updates["interval_seconds"] = urlEntry.IntervalSeconds
+		// A moved URL stays due now; otherwise the new interval counts from the last check.
+		if _, moved := updates["url"]; !moved && urlEntry.LastChecked != nil {
+			nextCheck := urlEntry.LastChecked.Add(time.Duration(urlEntry.IntervalSeconds) * time.Second)
+			urlEntry.NextCheckAt = &nextCheck
+			updates["next_check_at"] = nextCheck
+		}
+	}
+	if req.Beautify != nil {
+		urlEntry.Beautify = *req.Beautify
+		updates["beautify"] = urlEntry.Beautify
+	}
+	if req.GroupID != nil && *req.GroupID == 0 {
+		// group_id 0 moves the URL out of its group.
+		urlEntry.GroupID = nil
+		updates["group_id"] = nil
+	} else if req.GroupID != nil {
+		if ok, err := groupExists(req.GroupID); err != nil {
+			return apiError(c, http.StatusInternalServerError, "Database error finding group: "+err.Error())
+		} else if !ok {
+			return apiError(c, http.StatusUnprocessableEntity, "group_id does not exist.")
+		}
+		urlEntry.GroupID = req.GroupID
+		updates["group_id"] = *req.GroupID
+	}
+	reactivated := false
+	if req.IsActive != nil {
+		reactivated = *req.IsActive && !urlEntry.IsActive
+		urlEntry.IsActive = *req.IsActive
+		updates["is_active"] = urlEntry.IsActive
+	}
+
+	if len(updates) > 0 {
+		if result := database.DB.Model(&models.WatchedUrl{}).Where("id = ?", urlEntry.ID).Updates(updates); result.Error != nil {
+			return apiError(c, http.StatusInternalServerError, "Failed to update URL: "+result.Error.Error())
+		}
+	}
+	auditURL(c, services.AuditURLEdit, *urlEntry, describeURLChanges(before, *urlEntry))
+	if reactivated {
+		services.TriggerCheck(urlEntry.ID)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"data": newAPIURL(*urlEntry)})
+}
+
+// APIDeleteURL archives a URL, like the dashboard does.
+func APIDeleteURL(c echo.Context) error {
+	urlEntry, err := loadURLForAPI(c)
+	if urlEntry == nil {
+		return err
+	}
+	if err := services.ArchiveURL(database.DB, urlEntry); err != nil {
+		return apiError(c, http.StatusInternalServerError, "Failed to archive URL: "+err.Error())
+	}
+	auditURL(c, services.AuditURLArchive, *urlEntry, "")
+	return c.NoContent(http.StatusNoContent)
+}
+
+// APICheckURL queues an immediate check. The check runs in the background;
+// poll the URL or its changes for the result.
+func APICheckURL(c echo.Context) error {
+	urlEntry, err := loadURLForAPI(c)
+	if urlEntry == nil {
+		return err
+	}
+	services.TriggerCheck(urlEntry.ID)
+	auditURL(c, services.AuditURLCheck, *urlEntry, "")
+	return c.JSON(http.StatusAccepted, echo.Map{"data": newAPIURL(*urlEntry)})
+}
+
+// --- Groups ---
+
+func APIListGroups(c echo.Context) error {
+	query, pagination, err := paginate(c, database.DB.Model(&models.URLGroup{}))
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	var groups []models.URLGroup
+	if result := query.Order("id ASC").Find(&groups); result.Error != nil {
+		return apiError(c, http.StatusInternalServerError, "Database error listing groups: "+result.Error.Error())
+	}
+
+	data := make([]apiGroup, 0, len(groups))
+	for _, g := range groups {
+		group, err := newAPIGroup(g)
+		if err != nil {
+			return apiError(c, http.StatusInternalServerError, "Database error counting group URLs: "+err.Error())
+		}
+		data = append(data, group)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"data": data, "pagination": pagination})
+}
+
+type apiGroupRequest struct {
+	Name                *string `json:"name"`
+	SourceURL           *string `json:"source_url"`
+	ReextractHours      *int    `json:"reextract_hours"`
+	AutoWatchNewScripts *bool   `json:"auto_watch_new_scripts"`
+	AutoWatchChunks     *bool   `json:"auto_watch_chunks"`
+}
+
+// applyExtractionSettings copies the re-extraction settings of a request to a
+// group. The error is the message for a 422 response.
+func applyExtractionSettings(req apiGroupRequest, group *models.URLGroup) error {
+	if req.ReextractHours != nil {
+		if *req.ReextractHours < 0 || *req.ReextractHours > services.MaxReextractHours {
+			return fmt.Errorf("reextract_hours must be between 0 and %d.", services.MaxReextractHours)
+		}
+		if *req.ReextractHours != group.ReextractHours {
+			group.ReextractHours = *req.ReextractHours
+			group.NextExtractAt = nil
+			if group.ReextractHours > 0 && group.LastExtractedAt != nil {
+				next := group.LastExtractedAt.Add(time.Duration(group.ReextractHours) * time.Hour)
+				group.NextExtractAt = &next
+			}
+		}
+	}
+	if req.AutoWatchNewScripts != nil {
+		group.AutoWatchNewScripts = *req.AutoWatchNewScripts
+	}
+	if req.AutoWatchChunks != nil {
+		group.AutoWatchChunks = *req.AutoWatchChunks
+	}
+	return nil
+}
+
+func APICreateGroup(c echo.Context) error {
+	var req apiGroupRequest
+	if err := bindJSON(c, &req); err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	if req.Name == nil || strings.TrimSpace(*req.Name) == "" {
+		return apiError(c, http.StatusUnprocessableEntity, "name is required.")
+	}
+	if req.SourceURL == nil || *req.SourceURL == "" {
+		return apiError(c, http.StatusUnprocessableEntity, "source_url is required.")
+	}
+	if err := validateWatchURL(*req.SourceURL, false); err != nil {
+		return apiError(c, http.StatusUnprocessableEntity, "source_"+err.Error())
+	}
+
+	var count int64
+	if result := database.DB.Unscoped().Model(&models.URLGroup{}).Where("source_url = ?", *req.SourceURL).Count(&count); result.Error != nil {
+		return apiError(c, http.StatusInternalServerError, "Database error checking existing group: "+result.Error.Error())
+	} else if count > 0 {
+		return apiError(c, http.StatusConflict, "A group for this source URL already exists or is archived.")
+	}
+
+	group := models.URLGroup{Name: strings.TrimSpace(*req.Name), SourceURL: *req.SourceURL}
+	if err := applyExtractionSettings(req, &group); err != nil {
+		return apiError(c, http.StatusUnprocessableEntity, err.Error())
+	}
+	if result := database.DB.Create(&group); result.Error != nil {
+		return apiError(c, http.StatusInternalServerError, "Failed to create group: "+result.Error.Error())
+	}
+	auditGroup(c, services.AuditGroupAdd, group, "source "+group.SourceURL)
+	data, _ := newAPIGroup(group)
+	return c.JSON(http.StatusCreated, echo.Map{"data": data})
+}
+
+func APIGetGroup(c echo.Context) error {
+	group, err := loadGroupForAPI(c)
+	if group == nil {
+		return err
+	}
+	data, err := newAPIGroup(*group)
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, "Database error counting group URLs: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, echo.Map{"data": data})
+}
+
+func APIUpdateGroup(c echo.Context) error {
+	group, err := loadGroupForAPI(c)
+	if group == nil {
+		return err
+	}
+	var req apiGroupRequest
+	if err := bindJSON(c, &req); err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	before := *group
+	if req.Name != nil {
+		if strings.TrimSpace(*req.Name) == "" {
+			return apiError(c, http.StatusUnprocessableEntity, "name cannot be empty.")
+		}
+		group.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.SourceURL != nil && *req.SourceURL != group.SourceURL {
+		if err := validateWatchURL(*req.SourceURL, false); err != nil {
+			return apiError(c, http.StatusUnprocessableEntity, "source_"+err.Error())
+		}
+		var count int64
+		if result := database.DB.Unscoped().Model(&models.URLGroup{}).Where("source_url = ? AND id != ?", *req.SourceURL, group.ID).Count(&count); result.Error != nil {
+			return apiError(c, http.StatusInternalServerError, "Database error checking existing group: "+result.Error.Error())
+		} else if count > 0 {
+			return apiError(c, http.StatusConflict, "A group for this source URL already exists or is archived.")
+		}
+		group.SourceURL = *req.SourceURL
+		// A different page starts a new inventory.
+		group.Inventory = ""
+		group.LastExtractedAt = nil
+		group.NextExtractAt = nil
+	}
+	if err := applyExtractionSettings(req, group); err != nil {
+		return apiError(c, http.StatusUnprocessableEntity, err.Error())
+	}
+
+	if result := database.DB.Save(group); result.Error != nil {
+		return apiError(c, http.StatusInternalServerError, "Failed to update group: "+result.Error.Error())
+	}
+	auditGroup(c, services.AuditGroupEdit, *group, describeGroupChanges(before, *group))
+	data, err := newAPIGroup(*group)
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, "Database error counting group URLs: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, echo.Map{"data": data})
+}
+
+// APIDeleteGroup archives a group together with all of its URLs, like the dashboard does.
+func APIDeleteGroup(c echo.Context) error {
+	group, err := loadGroupForAPI(c)
+	if group == nil {
+		return err
+	}
+	if err := services.ArchiveGroup(database.DB, group); err != nil {
+		return apiError(c, http.StatusInternalServerError, "Failed to archive group and its URLs: "+err.Error())
+	}
+	auditGroup(c, services.AuditGroupArchive, *group, "")
+	return c.NoContent(http.StatusNoContent)
+}
+
+// --- Changes ---
+
+func APIListChanges(c echo.Context) error {
+	query := database.DB.Model(&models.ChangeEvent{}).Scopes(services.OfLiveURLs(database.DB))
+	if urlID := c.QueryParam("url_id"); urlID != "" {
+		id, err := strconv.ParseUint(urlID, 10, 32)
+		if err != nil {
+			return apiError(c, http.StatusBadRequest, "url_id must be a number")
+		}
+		query = query.Where("url_id = ?", id)
+	}
+	if groupID := c.QueryParam("group_id"); groupID != "" {
+		id, err := strconv.ParseUint(groupID, 10, 32)
+		if err != nil {
+			return apiError(c, http.StatusBadRequest, "group_id must be a number")
+		}
+		query = query.Where("url_id IN (?)", database.DB.Model(&models.WatchedUrl{}).Select("id").Where("group_id = ?", id))
+	}
+	isRead, err := parseBoolQuery(c, "is_read")
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	user := CurrentUser(c)
+	if isRead != nil {
+		if *isRead {
+			query = query.Scopes(services.ReadBy(user.ID))
+		} else {
+			query = query.Scopes(services.UnreadBy(user.ID))
+		}
+	}
+	if since := c.QueryParam("since"); since != "" {
+		sinceTime, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return apiError(c, http.StatusBadRequest, "since must be an RFC 3339 timestamp")
+		}
+		query = query.Where("detected_at >= ?", sinceTime.UTC())
+	}
+
+	query, pagination, err := paginate(c, query)
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	var changes []models.ChangeEvent
+	if result := query.Omit("diff_text").Preload("SourceFiles", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "change_event_id", "path", "status").Order("path")
+	}).Order("detected_at DESC, id DESC").Find(&changes); result.Error != nil {
+		return apiError(c, http.StatusInternalServerError, "Database error listing changes: "+result.Error.Error())
+	}
+	changePtrs := make([]*models.ChangeEvent, len(changes))
+	for i := range changes {
+		changePtrs[i] = &changes[i]
+	}
+	if err := services.FillReadState(database.DB, user.ID, changePtrs); err != nil {
+		return apiError(c, http.StatusInternalServerError, "Database error loading read state: "+err.Error())
+	}
+
+	data := make([]apiChange, 0, len(changes))
+	for _, ch := range changes {
+		data = append(data, newAPIChange(ch, false))
+	}
+	return c.JSON(http.StatusOK, echo.Map{"data": data, "pagination": pagination})
+}
+
+func APIGetChange(c echo.Context) error {
+	change, err := loadChangeForAPI(c)
+	if change == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"data": newAPIChange(*change, true)})
+}
+
+type apiChangeRequest struct {
+	IsRead *bool `json:"is_read"`
+}
+
+func APIUpdateChange(c echo.Context) error {
+	change, err := loadChangeForAPI(c)
+	if change == nil {
+		return err
+	}
+	var req apiChangeRequest
+	if err := bindJSON(c, &req); err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	if req.IsRead != nil {
+		if _, err := setReadState(CurrentUser(c).ID, database.DB.Model(&models.ChangeEvent{}).Where("id = ?", change.ID), *req.IsRead); err != nil {
+			return apiError(c, http.StatusInternalServerError, "Failed to update change: "+err.Error())
+		}
+		change.IsRead = *req.IsRead
+	}
+	return c.JSON(http.StatusOK, echo.Map{"data": newAPIChange(*change, false)})
+}
+
+// APIDeleteChange deletes a change for good. Like purging, it is for admins only.
+func APIDeleteChange(c echo.Context) error {
+	change, err := loadChangeForAPI(c)
+	if change == nil {
+		return err
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.ChangeRead{}, &models.SourceFileChange{}} {
+			if result := tx.Where("change_event_id = ?", change.ID).Delete(model); result.Error != nil {
+				return result.Error
+			}
+		}
+		return tx.Unscoped().Delete(change).Error
+	})
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, "Failed to remove change: "+err.Error())
+	}
+	recordAudit(c, models.AuditEvent{Action: services.AuditChangeDelete, TargetType: "change", TargetID: change.ID, Details: fmt.Sprintf("change of URL %d detected %s", change.URLID, change.DetectedAt.Format(time.RFC3339))})
+	return c.NoContent(http.StatusNoContent)
+}
+
+func APIListEndpoints(c echo.Context) error {
+	filter, err := endpointFilter(c)
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	query, pagination, err := paginate(c, services.EndpointInventory(database.DB, filter))
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	var rows []services.EndpointRow
+	if result := query.Select(services.EndpointRowColumns).Order("endpoints.value, watched_urls.url").Scan(&rows); result.Error != nil {
+		return apiError(c, http.StatusInternalServerError, "Database error listing endpoints: "+result.Error.Error())
+	}
+
+	data := make([]apiEndpoint, 0, len(rows))
+	for _, row := range rows {
+		data = append(data, apiEndpoint(row))
+	}
+	return c.JSON(http.StatusOK, echo.Map{"data": data, "pagination": pagination})
+}
+
+type apiMarkReadRequest struct {
+	URLID   *uint `json:"url_id"`
+	GroupID *uint `json:"group_id"`
+	IsRead  *bool `json:"is_read"`
+}
+
+// APIMarkChangesRead sets the token owner's read state of every change,
+// optionally limited to one URL or group. The body may be empty to mark
+// everything as read.
+func APIMarkChangesRead(c echo.Context) error {
+	req := apiMarkReadRequest{}
+	if c.Request().ContentLength != 0 {
+		if err := bindJSON(c, &req); err != nil {
+			return apiError(c, http.StatusBadRequest, err.Error())
+		}
+	}
+	isRead := true
+	if req.IsRead != nil {
+		isRead = *req.IsRead
+	}
+
+	query := database.DB.Model(&models.ChangeEvent{})
+	if req.URLID != nil {
+		query = query.Where("url_id = ?", *req.URLID)
+	}
+	if req.GroupID != nil {
+		query = query.Where("url_id IN (?)", database.DB.Model(&models.WatchedUrl{}).Select("id").Where("group_id = ?", *req.GroupID))
+	}
+	updated, err := setReadState(CurrentUser(c).ID, query, isRead)
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, "Failed to update changes: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, echo.Map{"data": echo.Map{"updated": updated}})
+}
+
+// setReadState marks the matched changes as read or unread for a user.
+func setReadState(userID uint, changes *gorm.DB, read bool) (int64, error) {
+	if read {
+		return services.MarkRead(database.DB, userID, changes)
+	}
+	return services.MarkUnread(database.DB, userID, changes)
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAPIUpdateURLKeepsConcurrentCheckResult(t *testing.T) {
+	useTestDB(t)
+	editor := newTestUser(t, "editor", models.RoleEditor)
+	urlEntry := newTestURL(t, models.WatchedUrl{URL: "https://example.com/app.js", IntervalSeconds: 300, LastContent: "old"})
+
+	checkDuringNextUpdate(t, "checked")
+	id := strconv.Itoa(int(urlEntry.ID))
+	c, rec := newJSONContext(http.MethodPatch, "/api/v1/urls/"+id, `{"beautify": true}`, editor, "id", id)
+	if err := APIUpdateURL(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	var saved models.WatchedUrl
+	database.DB.First(&saved, urlEntry.ID)
+	if !saved.Beautify || saved.LastContent != "checked" || saved.IntervalSeconds != 300 {
+		t.Errorf("after the update: beautify %v, content %q, interval %d", saved.Beautify, saved.LastContent, saved.IntervalSeconds)
+	}
+}
+
+// newTestToken creates an API token for user and returns it.
+func newTestToken(t *testing.T, user *models.User) string {
+	t.Helper()
+	token, hash, err := services.GenerateAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.DB.Create(&models.APIToken{Name: "test", TokenHash: hash, UserID: user.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAPIAuthMiddleware(t *testing.T) {
+	useTestDB(t)
+	user := newTestUser(t, "viewer", models.RoleViewer)
+	token := newTestToken(t, user)
+	revoked := newTestToken(t, user)
+	now := time.Now().UTC()
+	database.DB.Model(&models.APIToken{}).Where("token_hash = ?", services.HashAPIToken(revoked)).Update("revoked_at", now)
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"not bearer", "Basic " + token, http.StatusUnauthorized},
+		{"unknown", "Bearer jsw_unknown", http.StatusUnauthorized},
+		{"revoked", "Bearer " + revoked, http.StatusUnauthorized},
+		{"valid", "Bearer " + token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newJSONContext(http.MethodGet, "/api/v1/urls", "", nil)
+			c.Request().Header.Set("Authorization", tt.authorization)
+			var seen *models.User
+			err := APIAuthMiddleware(func(c echo.Context) error {
+				seen = CurrentUser(c)
+				return c.NoContent(http.StatusOK)
+			})(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if (seen != nil) != (tt.want == http.StatusOK) || (seen != nil && seen.ID != user.ID) {
+				t.Errorf("request ran as %+v", seen)
+			}
+		})
+	}
+}
+
+func TestAPIListURLsPagination(t *testing.T) {
+	useTestDB(t)
+	viewer := newTestUser(t, "viewer", models.RoleViewer)
+	for i := 1; i <= 5; i++ {
+		newTestURL(t, models.WatchedUrl{URL: fmt.Sprintf("https://example.com/%d.js", i)})
+	}
+
+	c, rec := newJSONContext(http.MethodGet, "/api/v1/urls?page=2&per_page=2", "", viewer)
+	if err := APIListURLs(c); err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Data       []apiURL      `json:"data"`
+		Pagination apiPagination `json:"pagination"`
+	}
+	decodeJSON(t, rec, &body)
+	if len(body.Data) != 2 || body.Data[0].URL != "https://example.com/3.js" || body.Data[1].URL != "https://example.com/4.js" {
+		t.Errorf("page 2 = %+v", body.Data)
+	}
+	if want := (apiPagination{Page: 2, PerPage: 2, Total: 5, TotalPages: 3}); body.Pagination != want {
+		t.Errorf("pagination = %+v, want %+v", body.Pagination, want)
+	}
+
+	for _, query := range []string{"page=0", "per_page=0", fmt.Sprintf("per_page=%d", maxPerPage+1), "page=x"} {
+		c, rec := newJSONContext(http.MethodGet, "/api/v1/urls?"+query, "", viewer)
+		if err := APIListURLs(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAPIUpdateURLRechecksTargetWithOverride(t *testing.T) {
+	useTestDB(t)
+	admin := newTestUser(t, "admin", models.RoleAdmin)
+	editor := newTestUser(t, "editor", models.RoleEditor)
+	urlEntry := newTestURL(t, models.WatchedUrl{URL: "http://127.0.0.1/internal.js", AllowPrivateTarget: true})
+	id := strconv.Itoa(int(urlEntry.ID))
+
+	c, rec := newJSONContext(http.MethodPatch, "/api/v1/urls/"+id, `{"allow_private_target": true}`, editor, "id", id)
+	if err := APIUpdateURL(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("editor resending the current override: status %d: %s", rec.Code, rec.Body)
+	}
+
+	c, rec = newJSONContext(http.MethodPatch, "/api/v1/urls/"+id, `{"allow_private_target": false}`, editor, "id", id)
+	if err := APIUpdateURL(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("editor clearing the override: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	c, rec = newJSONContext(http.MethodPatch, "/api/v1/urls/"+id, `{"allow_private_target": false}`, admin, "id", id)
+	if err := APIUpdateURL(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("clearing the override of a blocked address: status %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	var saved models.WatchedUrl
+	database.DB.First(&saved, urlEntry.ID)
+	if !saved.AllowPrivateTarget {
+		t.Error("the override was cleared although the address is blocked")
+	}
+}
+
+func TestAPIUpdateURLIntervalCountsFromLastCheck(t *testing.T) {
+	useTestDB(t)
+	editor := newTestUser(t, "editor", models.RoleEditor)
+	lastChecked := time.Now().UTC().Add(-time.Minute).Truncate(time.Second)
+	// A URL edited while due has no next check time.
+	urlEntry := newTestURL(t, models.WatchedUrl{URL: "https://example.com/app.js", IntervalSeconds: 300, LastChecked: &lastChecked})
+	id := strconv.Itoa(int(urlEntry.ID))
+
+	c, rec := newJSONContext(http.MethodPatch, "/api/v1/urls/"+id, `{"interval_seconds": 3600}`, editor, "id", id)
+	if err := APIUpdateURL(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var saved models.WatchedUrl
+	database.DB.First(&saved, urlEntry.ID)
+	if want := lastChecked.Add(time.Hour); saved.NextCheckAt == nil || !saved.NextCheckAt.Equal(want) {
+		t.Errorf("next check at %v, want %v", saved.NextCheckAt, want)
+	}
+}
+
+func TestAPIMarkChangesRead(t *testing.T) {
+	useTestDB(t)
+	alice := newTestUser(t, "alice", models.RoleViewer)
+	bob := newTestUser(t, "bob", models.RoleViewer)
+	urlEntry := newTestURL(t, models.WatchedUrl{URL: "https://example.com/app.js"})
+	for i := 0; i < 3; i++ {
+		database.DB.Create(&models.ChangeEvent{URLID: urlEntry.ID, DetectedAt: time.Now().UTC()})
+	}
+
+	markRead := func(user *models.User, body string) int64 {
+		t.Helper()
+		c, rec := newJSONContext(http.MethodPost, "/api/v1/changes/mark_read", body, user)
+		if err := APIMarkChangesRead(c); err != nil {
+			t.Fatal(err)
+		}
+		var resp struct {
+			Data struct {
+				Updated int64 `json:"updated"`
+			} `json:"data"`
+		}
+		decodeJSON(t, rec, &resp)
+		return resp.Data.Updated
+	}
+	countRead := func(user *models.User, isRead string) int {
+		t.Helper()
+		c, rec := newJSONContext(http.MethodGet, "/api/v1/changes?is_read="+isRead, "", user)
+		if err := APIListChanges(c); err != nil {
+			t.Fatal(err)
+		}
+		var resp struct {
+			Data []apiChange `json:"data"`
+		}
+		decodeJSON(t, rec, &resp)
+		for _, ch := range resp.Data {
+			if strconv.FormatBool(ch.IsRead) != isRead {
+				t.Errorf("is_read=%s listed change %d with is_read %v", isRead, ch.ID, ch.IsRead)
+			}
+		}
+		return len(resp.Data)
+	}
+
+	if updated := markRead(alice, ""); updated != 3 {
+		t.Errorf("marking everything read updated %d, want 3", updated)
+	}
+	if updated := markRead(alice, fmt.Sprintf(`{"url_id": %d, "is_read": false}`, urlEntry.ID)); updated != 3 {
+		t.Errorf("marking the URL's changes unread updated %d, want 3", updated)
+	}
+	if updated := markRead(alice, fmt.Sprintf(`{"url_id": %d}`, urlEntry.ID+1)); updated != 0 {
+		t.Errorf("marking another URL's changes read updated %d, want 0", updated)
+	}
+	markRead(bob, "")
+	if read, unread := countRead(alice, "true"), countRead(alice, "false"); read != 0 || unread != 3 {
+		t.Errorf("alice has %d read and %d unread changes, want 0 and 3", read, unread)
+	}
+	if read := countRead(bob, "true"); read != 3 {
+		t.Errorf("bob has %d read changes, want 3", read)
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// apiTokenRow is an APIToken with the name of the user it belongs to.
+type apiTokenRow struct {
+	models.APIToken
+	Owner string
+}
+
+// renderAPITokens shows the current user's tokens, or every token for admins.
+func renderAPITokens(c echo.Context, newToken string) error {
+	user := CurrentUser(c)
+	query := database.DB.Order("revoked_at IS NOT NULL, created_at DESC")
+	if !user.IsAdmin() {
+		query = query.Where("user_id = ?", user.ID)
+	}
+	var tokens []models.APIToken
+	if result := query.Find(&tokens); result.Error != nil {
+		Flash(c, "Database error loading API tokens: "+result.Error.Error())
+	}
+
+	usernames := map[uint]string{}
+	rows := make([]apiTokenRow, 0, len(tokens))
+	for _, token := range tokens {
+		owner, ok := usernames[token.UserID]
+		if !ok {
+			var tokenUser models.User
+			if database.DB.Select("id", "username").First(&tokenUser, token.UserID).Error == nil {
+				owner = tokenUser.Username
+			}
+			usernames[token.UserID] = owner
+		}
+		rows = append(rows, apiTokenRow{APIToken: token, Owner: owner})
+	}
+
+	return c.Render(http.StatusOK, "api_tokens.html", echo.Map{
+		"Tokens":     rows,
+		"ShowOwners": user.IsAdmin(),
+		"NewToken":   newToken,
+		"BaseURL":    BaseURL,
+		"Flashes":    GetFlashes(c),
+	})
+}
+
+func APITokensGet(c echo.Context) error {
+	return renderAPITokens(c, "")
+}
+
+// CreateAPIToken creates a token for the current user and shows it once. The page is rendered
+// directly instead of redirecting so the token never ends up in the session cookie.
+func CreateAPIToken(c echo.Context) error {
+	name := strings.TrimSpace(c.FormValue("name"))
+	if name == "" {
+		Flash(c, "Token name is required.")
+		return c.Redirect(http.StatusFound, "/api_tokens")
+	}
+
+	token, hash, err := services.GenerateAPIToken()
+	if err != nil {
+		Flash(c, "Failed to generate API token: "+err.Error())
+		return c.Redirect(http.StatusFound, "/api_tokens")
+	}
+	apiToken := models.APIToken{
+		Name:      name,
+		TokenHash: hash,
+		Prefix:    token[:12],
+		UserID:    CurrentUser(c).ID,
+	}
+	if result := database.DB.Create(&apiToken); result.Error != nil {
+		Flash(c, "Failed to save API token: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/api_tokens")
+	}
+
+	recordAudit(c, models.AuditEvent{Action: services.AuditAPITokenCreate, TargetType: "api_token", TargetID: apiToken.ID, Target: apiToken.Name, Details: "prefix " + apiToken.Prefix})
+	return renderAPITokens(c, token)
+}
+
+func RevokeAPIToken(c echo.Context) error {
+	tokenID, err := strconv.ParseUint(c.FormValue("id"), 10, 32)
+	if err != nil {
+		Flash(c, "Invalid token ID.")
+		return c.Redirect(http.StatusFound, "/api_tokens")
+	}
+
+	var apiToken models.APIToken
+	if result := database.DB.First(&apiToken, tokenID); result.Error != nil {
+		Flash(c, "API token not found.")
+		return c.Redirect(http.StatusFound, "/api_tokens")
+	}
+	if user := CurrentUser(c); apiToken.UserID != user.ID && !user.IsAdmin() {
+		Flash(c, "You can only revoke your own API tokens.")
+		return c.Redirect(http.StatusFound, "/api_tokens")
+	}
+	if apiToken.RevokedAt == nil {
+		now := time.Now().UTC()
+		if result := database.DB.Model(&apiToken).Update("revoked_at", now); result.Error != nil {
+			Flash(c, "Failed to revoke API token: "+result.Error.Error())
+			return c.Redirect(http.StatusFound, "/api_tokens")
+		}
+		recordAudit(c, models.AuditEvent{Action: services.AuditAPITokenRevoke, TargetType: "api_token", TargetID: apiToken.ID, Target: apiToken.Name, Details: "prefix " + apiToken.Prefix})
+	}
+
+	Flash(c, "API token '"+apiToken.Name+"' has been revoked.")
+	return c.Redirect(http.StatusFound, "/api_tokens")
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type archivedGroup struct {
+	models.URLGroup
+	URLCount int64 // URLs archived together with the group
+}
+
+type archivedURL struct {
+	models.WatchedUrl
+	ChangeCount int64
+	GroupName   string
+}
+
+// ArchiveGet lists archived groups and the URLs that were archived on their own.
+func ArchiveGet(c echo.Context) error {
+	var groups []models.URLGroup
+	if result := database.DB.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&groups); result.Error != nil {
+		Flash(c, "Database error loading archived groups: "+result.Error.Error())
+	}
+	archivedGroups := make([]archivedGroup, 0, len(groups))
+	archivedGroupIDs := []uint{0}
+	for _, group := range groups {
+		view := archivedGroup{URLGroup: group}
+		database.DB.Unscoped().Model(&models.WatchedUrl{}).
+			Where("group_id = ? AND archived_with_group = ?", group.ID, true).Count(&view.URLCount)
+		archivedGroups = append(archivedGroups, view)
+		archivedGroupIDs = append(archivedGroupIDs, group.ID)
+	}
+
+	// URLs in an archived group are restored and purged with their group.
+	var urls []models.WatchedUrl
+	result := database.DB.Unscoped().Omit("last_content").
+		Where("deleted_at IS NOT NULL AND (group_id IS NULL OR group_id NOT IN ?)", archivedGroupIDs).
+		Order("deleted_at DESC").Find(&urls)
+	if result.Error != nil {
+		Flash(c, "Database error loading archived URLs: "+result.Error.Error())
+	}
+	archivedURLs := make([]archivedURL, 0, len(urls))
+	for _, urlEntry := range urls {
+		view := archivedURL{WatchedUrl: urlEntry}
+		database.DB.Model(&models.ChangeEvent{}).Where("url_id = ?", urlEntry.ID).Count(&view.ChangeCount)
+		if urlEntry.GroupID != nil {
+			var group models.URLGroup
+			if database.DB.Select("id", "name").First(&group, *urlEntry.GroupID).Error == nil {
+				view.GroupName = group.Name
+			}
+		}
+		archivedURLs = append(archivedURLs, view)
+	}
+
+	return c.Render(http.StatusOK, "archive.html", echo.Map{
+		"Groups":  archivedGroups,
+		"URLs":    archivedURLs,
+		"Flashes": GetFlashes(c),
+	})
+}
+
+// loadArchivedURL loads the archived URL named by the "id" form field.
+func loadArchivedURL(c echo.Context) (*models.WatchedUrl, error) {
+	urlID, err := strconv.ParseUint(c.FormValue("id"), 10, 32)
+	if err != nil {
+		Flash(c, "Invalid URL ID.")
+		return nil, c.Redirect(http.StatusFound, "/archive")
+	}
+	var urlEntry models.WatchedUrl
+	if result := database.DB.Unscoped().Where("deleted_at IS NOT NULL").First(&urlEntry, urlID); result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			Flash(c, "Archived URL not found.")
+		} else {
+			Flash(c, "Database error finding URL: "+result.Error.Error())
+		}
+		return nil, c.Redirect(http.StatusFound, "/archive")
+	}
+	return &urlEntry, nil
+}
+
+// loadArchivedGroup loads the archived group named by the "group_id" form field.
+func loadArchivedGroup(c echo.Context) (*models.URLGroup, error) {
+	groupID, err := strconv.ParseUint(c.FormValue("group_id"), 10, 32)
+	if err != nil {
+		Flash(c, "Invalid group ID.")
+		return nil, c.Redirect(http.StatusFound, "/archive")
+	}
+	var group models.URLGroup
+	if result := database.DB.Unscoped().Where("deleted_at IS NOT NULL").First(&group, groupID); result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			Flash(c, "Archived group not found.")
+		} else {
+			Flash(c, "Database error finding group: "+result.Error.Error())
+		}
+		return nil, c.Redirect(http.StatusFound, "/archive")
+	}
+	return &group, nil
+}
+
+func RestoreURL(c echo.Context) error {
+	urlEntry, err := loadArchivedURL(c)
+	if urlEntry == nil {
+		return err
+	}
+	if urlEntry.GroupID != nil {
+		var count int64
+		database.DB.Unscoped().Model(&models.URLGroup{}).Where("id = ? AND deleted_at IS NOT NULL", *urlEntry.GroupID).Count(&count)
+		if count > 0 {
+			Flash(c, "This URL's group is archived. Restore the group instead.")
+			return c.Redirect(http.StatusFound, "/archive")
+		}
+	}
+	if err := services.RestoreURL(database.DB, urlEntry.ID); err != nil {
+		Flash(c, "Failed to restore URL: "+err.Error())
+		return c.Redirect(http.StatusFound, "/archive")
+	}
+
+	auditURL(c, services.AuditURLRestore, *urlEntry, "")
+	Flash(c, "Restored "+urlEntry.URL+".")
+	return c.Redirect(http.StatusFound, "/archive")
+}
+
+func PurgeURL(c echo.Context) error {
+	urlEntry, err := loadArchivedURL(c)
+	if urlEntry == nil {
+		return err
+	}
+	if err := services.PurgeURLs(database.DB, []uint{urlEntry.ID}); err != nil {
+		Flash(c, "Failed to purge URL: "+err.Error())
+		return c.Redirect(http.StatusFound, "/archive")
+	}
+
+	auditURL(c, services.AuditURLPurge, *urlEntry, "")
+	Flash(c, "Permanently deleted "+urlEntry.URL+" and its history.")
+	return c.Redirect(http.StatusFound, "/archive")
+}
+
+func RestoreGroup(c echo.Context) error {
+	group, err := loadArchivedGroup(c)
+	if group == nil {
+		return err
+	}
+	if err := services.RestoreGroup(database.DB, group); err != nil {
+		Flash(c, "Failed to restore group: "+err.Error())
+		return c.Redirect(http.StatusFound, "/archive")
+	}
+
+	auditGroup(c, services.AuditGroupRestore, *group, "")
+	Flash(c, "Restored group '"+group.Name+"'.")
+	return c.Redirect(http.StatusFound, "/archive")
+}
+
+func PurgeGroup(c echo.Context) error {
+	group, err := loadArchivedGroup(c)
+	if group == nil {
+		return err
+	}
+	if err := services.PurgeGroup(database.DB, group); err != nil {
+		Flash(c, "Failed to purge group: "+err.Error())
+		return c.Redirect(http.StatusFound, "/archive")
+	}
+
+	auditGroup(c, services.AuditGroupPurge, *group, "")
+	Flash(c, "Permanently deleted group '"+group.Name+"', its URLs and their history.")
+	return c.Redirect(http.StatusFound, "/archive")
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+)
+
+// archivedGroupFixture archives a group with one of its URLs; the other was
+// archived on its own before. It also archives an ungrouped URL.
+func archivedGroupFixture(t *testing.T) (group models.URLGroup, withGroup, alone, ungrouped models.WatchedUrl) {
+	t.Helper()
+	group = models.URLGroup{Name: "app", SourceURL: "https://example.com/"}
+	database.DB.Create(&group)
+	withGroup = newTestURL(t, models.WatchedUrl{URL: "https://example.com/a.js", GroupID: &group.ID})
+	alone = newTestURL(t, models.WatchedUrl{URL: "https://example.com/b.js", GroupID: &group.ID})
+	ungrouped = newTestURL(t, models.WatchedUrl{URL: "https://example.com/c.js"})
+	for _, urlEntry := range []*models.WatchedUrl{&alone, &ungrouped} {
+		if err := services.ArchiveURL(database.DB, urlEntry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := services.ArchiveGroup(database.DB, &group); err != nil {
+		t.Fatal(err)
+	}
+	return group, withGroup, alone, ungrouped
+}
+
+func isArchived(t *testing.T, urlID uint) bool {
+	t.Helper()
+	var urlEntry models.WatchedUrl
+	if err := database.DB.Unscoped().First(&urlEntry, urlID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return urlEntry.DeletedAt.Valid
+}
+
+func TestArchiveGet(t *testing.T) {
+	useTestDB(t)
+	editor := newTestUser(t, "editor", models.RoleEditor)
+	group, _, _, ungrouped := archivedGroupFixture(t)
+
+	c, _ := newGetContext("/archive", editor)
+	if err := ArchiveGet(c); err != nil {
+		t.Fatal(err)
+	}
+	_, data := rendered(c)
+	groups := data["Groups"].([]archivedGroup)
+	if len(groups) != 1 || groups[0].ID != group.ID || groups[0].URLCount != 1 {
+		t.Errorf("archived groups = %+v, want the group with 1 URL", groups)
+	}
+	// URLs of an archived group are only listed through the group.
+	urls := data["URLs"].([]archivedURL)
+	if len(urls) != 1 || urls[0].ID != ungrouped.ID {
+		t.Errorf("archived URLs = %+v, want only %s", urls, ungrouped.URL)
+	}
+}
+
+func TestRestoreGroupAndItsURLs(t *testing.T) {
+	useTestDB(t)
+	editor := newTestUser(t, "editor", models.RoleEditor)
+	group, withGroup, alone, _ := archivedGroupFixture(t)
+	restoreURL := func() []string {
+		c, rec := newFormContext("/restore_url", url.Values{"id": {strconv.Itoa(int(alone.ID))}}, editor)
+		if err := RestoreURL(c); err != nil {
+			t.Fatal(err)
+		}
+		return flashesOf(rec)
+	}
+
+	if flashes := restoreURL(); len(flashes) != 1 || flashes[0] != "This URL's group is archived. Restore the group instead." {
+		t.Errorf("restoring a URL of an archived group: flashes %q", flashes)
+	}
+	if !isArchived(t, alone.ID) {
+		t.Fatal("URL restored while its group is archived")
+	}
+
+	c, _ := newFormContext("/restore_group", url.Values{"group_id": {strconv.Itoa(int(group.ID))}}, editor)
+	if err := RestoreGroup(c); err != nil {
+		t.Fatal(err)
+	}
+	if isArchived(t, withGroup.ID) || !isArchived(t, alone.ID) {
+		t.Errorf("after restoring the group: %s archived %v, %s archived %v; want only the second",
+			withGroup.URL, isArchived(t, withGroup.ID), alone.URL, isArchived(t, alone.ID))
+	}
+
+	if flashes := restoreURL(); len(flashes) != 1 || flashes[0] != "Restored "+alone.URL+"." {
+		t.Errorf("restoring the URL after its group: flashes %q", flashes)
+	}
+	if isArchived(t, alone.ID) {
+		t.Error("URL still archived")
+	}
+}
+
+func TestPurgeGroupAndURL(t *testing.T) {
+	useTestDB(t)
+	admin := newTestUser(t, "admin", models.RoleAdmin)
+	group, withGroup, alone, ungrouped := archivedGroupFixture(t)
+	live := newTestURL(t, models.WatchedUrl{URL: "https://example.com/live.js"})
+	for _, urlEntry := range []models.WatchedUrl{withGroup, alone, ungrouped, live} {
+		change := models.ChangeEvent{URLID: urlEntry.ID}
+		database.DB.Create(&change)
+		database.DB.Create(&models.ChangeRead{UserID: admin.ID, ChangeEventID: change.ID})
+	}
+
+	c, _ := newFormContext("/purge_group", url.Values{"group_id": {strconv.Itoa(int(group.ID))}}, admin)
+	if err := PurgeGroup(c); err != nil {
+		t.Fatal(err)
+	}
+	c, _ = newFormContext("/purge_url", url.Values{"id": {strconv.Itoa(int(ungrouped.ID))}}, admin)
+	if err := PurgeURL(c); err != nil {
+		t.Fatal(err)
+	}
+	// A live URL can't be purged.
+	c, rec := newFormContext("/purge_url", url.Values{"id": {strconv.Itoa(int(live.ID))}}, admin)
+	if err := PurgeURL(c); err != nil {
+		t.Fatal(err)
+	}
+	if flashes := flashesOf(rec); len(flashes) != 1 || flashes[0] != "Archived URL not found." {
+		t.Errorf("purging a live URL: flashes %q", flashes)
+	}
+
+	var groups, urls, changes, reads int64
+	database.DB.Unscoped().Model(&models.URLGroup{}).Count(&groups)
+	database.DB.Unscoped().Model(&models.WatchedUrl{}).Count(&urls)
+	database.DB.Model(&models.ChangeEvent{}).Count(&changes)
+	database.DB.Model(&models.ChangeRead{}).Count(&reads)
+	if groups != 0 || urls != 1 || changes != 1 || reads != 1 {
+		t.Errorf("left %d groups, %d URLs, %d changes and %d read marks; want 0, 1, 1 and 1", groups, urls, changes, reads)
+	}
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	auditPageLimit   = 500
+	auditExportLimit = 10000
+)
+
+// recordAudit adds an entry for the current request to the audit log. The
+// user, IP and source default to the logged-in user and the request.
+func recordAudit(c echo.Context, event models.AuditEvent) {
+	if event.Username == "" {
+		if user := CurrentUser(c); user != nil {
+			event.UserID = user.ID
+			event.Username = user.Username
+		}
+	}
+	event.IP = c.RealIP()
+	event.Source = "web"
+	if strings.HasPrefix(c.Request().URL.Path, "/api/") {
+		event.Source = "api"
+	}
+	services.RecordAudit(database.DB, event)
+}
+
+// auditURL is a shorthand for entries about a watched URL.
+func auditURL(c echo.Context, action string, urlEntry models.WatchedUrl, details string) {
+	recordAudit(c, models.AuditEvent{Action: action, TargetType: "url", TargetID: urlEntry.ID, Target: urlEntry.URL, Details: details})
+}
+
+// auditGroup is a shorthand for entries about a URL group.
+func auditGroup(c echo.Context, action string, group models.URLGroup, details string) {
+	recordAudit(c, models.AuditEvent{Action: action, TargetType: "group", TargetID: group.ID, Target: group.Name, Details: details})
+}
+
+// auditUser is a shorthand for entries about a user account.
+func auditUser(c echo.Context, action string, user models.User, details string) {
+	recordAudit(c, models.AuditEvent{Action: action, TargetType: "user", TargetID: user.ID, Target: user.Username, Details: details})
+}
+
+// describeURLChanges lists the settings an edit changed, for the audit details.
+func describeURLChanges(before, after models.WatchedUrl) string {
+	var changed []string
+	if before.URL != after.URL {
+		changed = append(changed, fmt.Sprintf("url %s -> %s", before.URL, after.URL))
+	}
+	if before.IntervalSeconds != after.IntervalSeconds {
+		changed = append(changed, fmt.Sprintf("interval %ds -> %ds", before.IntervalSeconds, after.IntervalSeconds))
+	}
+	if before.Beautify != after.Beautify {
+		changed = append(changed, fmt.Sprintf("beautify %t -> %t", before.Beautify, after.Beautify))
+	}
+	if before.IsActive != after.IsActive {
+		changed = append(changed, fmt.Sprintf("active %t -> %t", before.IsActive, after.IsActive))
+	}
+	if before.AllowPrivateTarget != after.AllowPrivateTarget {
+		changed = append(changed, fmt.Sprintf("allow private target %t -> %t", before.AllowPrivateTarget, after.AllowPrivateTarget))
+	}
+	if groupIDString(before.GroupID) != groupIDString(after.GroupID) {
+		changed = append(changed, fmt.Sprintf("group %s -> %s", groupIDString(before.GroupID), groupIDString(after.GroupID)))
+	}
+	if len(changed) == 0 {
+		return "no changes"
+	}
+	return strings.Join(changed, ", ")
+}
+
+// describeGroupChanges lists the settings an edit changed, for the audit details.
+func describeGroupChanges(before, after models.URLGroup) string {
+	var changed []string
+	if before.Name != after.Name {
+		changed = append(changed, fmt.Sprintf("name %q -> %q", before.Name, after.Name))
+	}
+	if before.SourceURL != after.SourceURL {
+		changed = append(changed, fmt.Sprintf("source %s -> %s", before.SourceURL, after.SourceURL))
+	}
+	if before.ReextractHours != after.ReextractHours {
+		changed = append(changed, fmt.Sprintf("re-extract every %dh -> %dh", before.ReextractHours, after.ReextractHours))
+	}
+	if before.ExtractTool != after.ExtractTool {
+		changed = append(changed, fmt.Sprintf("tool %q -> %q", before.ExtractTool, after.ExtractTool))
+	}
+	if before.AutoWatchNewScripts != after.AutoWatchNewScripts {
+		changed = append(changed, fmt.Sprintf("auto-watch %t -> %t", before.AutoWatchNewScripts, after.AutoWatchNewScripts))
+	}
+	if before.AutoWatchChunks != after.AutoWatchChunks {
+		changed = append(changed, fmt.Sprintf("auto-watch chunks %t -> %t", before.AutoWatchChunks, after.AutoWatchChunks))
+	}
+	if before.ScriptInterval != after.ScriptInterval {
+		changed = append(changed, fmt.Sprintf("new script interval %ds -> %ds", before.ScriptInterval, after.ScriptInterval))
+	}
+	if before.ScriptBeautify != after.ScriptBeautify {
+		changed = append(changed, fmt.Sprintf("new script beautify %t -> %t", before.ScriptBeautify, after.ScriptBeautify))
+	}
+	if before.AllowPrivateTarget != after.AllowPrivateTarget {
+		changed = append(changed, fmt.Sprintf("allow private target %t -> %t", before.AllowPrivateTarget, after.AllowPrivateTarget))
+	}
+	if len(changed) == 0 {
+		return "no changes"
+	}
+	return strings.Join(changed, ", ")
+}
+
+func groupIDString(groupID *uint) string {
+	if groupID == nil {
+		return "none"
+	}
+	return fmt.Sprint(*groupID)
+}
+
+type auditFilter struct {
+	Action     string
+	Username   string
+	TargetType string
+	From       string
+	To         string
+}
+
+func (f auditFilter) values() url.Values {
+	values := url.Values{}
+	for key, value := range map[string]string{"action": f.Action, "username": f.Username, "target_type": f.TargetType, "from": f.From, "to": f.To} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	return values
+}
+
+// auditQuery applies the audit page's filters. From and To are dates
+// (YYYY-MM-DD); To includes the whole day.
+func auditQuery(c echo.Context) (*gorm.DB, auditFilter, error) {
+	filter := auditFilter{
+		Action:     c.QueryParam("action"),
+		Username:   c.QueryParam("username"),
+		TargetType: c.QueryParam("target_type"),
+		From:       c.QueryParam("from"),
+		To:         c.QueryParam("to"),
+	}
+	query := database.DB.Model(&models.AuditEvent{})
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Username != "" {
+		query = query.Where("username = ?", filter.Username)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.From != "" {
+		from, err := time.Parse("2006-01-02", filter.From)
+		if err != nil {
+			return nil, filter, fmt.Errorf("invalid from date %q", filter.From)
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if filter.To != "" {
+		to, err := time.Parse("2006-01-02", filter.To)
+		if err != nil {
+			return nil, filter, fmt.Errorf("invalid to date %q", filter.To)
+		}
+		query = query.Where("created_at < ?", to.AddDate(0, 0, 1))
+	}
+	return query.Order("created_at DESC, id DESC"), filter, nil
+}
+
+// AuditGet shows the most recent audit entries matching the filters.
+func AuditGet(c echo.Context) error {
+	var events []models.AuditEvent
+	query, filter, err := auditQuery(c)
+	if err != nil {
+		Flash(c, "Invalid filter: "+err.Error())
+	} else if res := query.Limit(auditPageLimit).Find(&events); res.Error != nil {
+		Flash(c, "Database error loading audit log: "+res.Error.Error())
+	}
+
+	var targetTypes []string
+	if res := database.DB.Model(&models.AuditEvent{}).Distinct().Order("target_type").Pluck("target_type", &targetTypes); res.Error != nil {
+		Flash(c, "Database error loading audit log: "+res.Error.Error())
+	}
+
+	return c.Render(http.StatusOK, "audit.html", echo.Map{
+		"Events":      events,
+		"Filter":      filter,
+		"Actions":     services.AuditActions,
+		"TargetTypes": targetTypes,
+		"Limit":       auditPageLimit,
+		"ExportURL":   template.URL("/audit/export.json?" + filter.values().Encode()),
+		"Flashes":     GetFlashes(c),
+	})
+}
+
+type auditExportEntry struct {
+	ID         uint      `json:"id"`
+	Time       time.Time `json:"time"`
+	UserID     uint      `json:"user_id,omitempty"`
+	Username   string    `json:"username"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type,omitempty"`
+	TargetID   uint      `json:"target_id,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	Details    string    `json:"details,omitempty"`
+	IP         string    `json:"ip"`
+	Source     string    `json:"source"`
+}
+
+// AuditExport downloads the entries matching the audit page's filters as JSON.
+func AuditExport(c echo.Context) error {
+	query, _, err := auditQuery(c)
+	if err != nil {
+		Flash(c, "Invalid filter: "+err.Error())
+		return c.Redirect(http.StatusFound, "/audit")
+	}
+	var events []models.AuditEvent
+	if res := query.Limit(auditExportLimit).Find(&events); res.Error != nil {
+		Flash(c, "Database error exporting audit log: "+res.Error.Error())
+		return c.Redirect(http.StatusFound, "/audit")
+	}
+
+	entries := make([]auditExportEntry, len(events))
+	for i, e := range events {
+		entries[i] = auditExportEntry{
+			ID:         e.ID,
+			Time:       e.CreatedAt,
+			UserID:     e.UserID,
+			Username:   e.Username,
+			Action:     e.Action,
+			TargetType: e.TargetType,
+			TargetID:   e.TargetID,
+			Target:     e.Target,
+			Details:    e.Details,
+			IP:         e.IP,
+			Source:     e.Source,
+		}
+	}
+	filename := "audit-" + time.Now().UTC().Format("20060102-150405") + ".json"
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	return c.JSONPretty(http.StatusOK, entries, "  ")
+}
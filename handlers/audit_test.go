@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+)
+
+func TestDescribeURLChanges(t *testing.T) {
+	groupID := uint(3)
+	before := models.WatchedUrl{URL: "https://example.com/a.js", IntervalSeconds: 300, IsActive: true}
+	tests := []struct {
+		name   string
+		change func(*models.WatchedUrl)
+		want   string
+	}{
+		{"nothing", func(u *models.WatchedUrl) {}, "no changes"},
+		{"url", func(u *models.WatchedUrl) { u.URL = "https://example.com/b.js" }, "url https://example.com/a.js -> https://example.com/b.js"},
+		{"interval and pause", func(u *models.WatchedUrl) { u.IntervalSeconds = 60; u.IsActive = false }, "interval 300s -> 60s, active true -> false"},
+		{"private target", func(u *models.WatchedUrl) { u.AllowPrivateTarget = true }, "allow private target false -> true"},
+		{"group", func(u *models.WatchedUrl) { u.GroupID = &groupID }, "group none -> 3"},
+	}
+	for _, tt := range tests {
+		after := before
+		tt.change(&after)
+		if got := describeURLChanges(before, after); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEditsAreAudited(t *testing.T) {
+	useTestDB(t)
+	editor := newTestUser(t, "editor", models.RoleEditor)
+	urlEntry := newTestURL(t, models.WatchedUrl{URL: "https://example.com/app.js", IntervalSeconds: 300})
+	id := strconv.Itoa(int(urlEntry.ID))
+
+	c, _ := newJSONContext(http.MethodPatch, "/api/v1/urls/"+id, `{"interval_seconds": 60}`, editor, "id", id)
+	c.Request().RemoteAddr = "192.0.2.7:1234"
+	if err := APIUpdateURL(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []models.AuditEvent
+	database.DB.Find(&events)
+	if len(events) != 1 {
+		t.Fatalf("%d audit events, want 1", len(events))
+	}
+	want := models.AuditEvent{
+		ID: events[0].ID, CreatedAt: events[0].CreatedAt,
+		UserID: editor.ID, Username: "editor", Action: services.AuditURLEdit,
+		TargetType: "url", TargetID: urlEntry.ID, Target: urlEntry.URL,
+		Details: "interval 300s -> 60s", IP: "192.0.2.7", Source: "api",
+	}
+	if events[0] != want {
+		t.Errorf("audit event = %+v, want %+v", events[0], want)
+	}
+}
+
+func TestAuditFilters(t *testing.T) {
+	useTestDB(t)
+	admin := newTestUser(t, "admin", models.RoleAdmin)
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 12, 0, 0, 0, time.UTC) }
+	events := []models.AuditEvent{
+		{CreatedAt: day(1), Username: "alice", Action: services.AuditURLAdd, TargetType: "url"},
+		{CreatedAt: day(2), Username: "bob", Action: services.AuditURLAdd, TargetType: "url"},
+		{CreatedAt: day(2), Username: "alice", Action: services.AuditGroupAdd, TargetType: "group"},
+		{CreatedAt: day(3), Username: "alice", Action: services.AuditLogin},
+	}
+	database.DB.Create(&events)
+
+	tests := []struct {
+		query string
+		want  []uint // newest first
+	}{
+		{"", []uint{events[3].ID, events[2].ID, events[1].ID, events[0].ID}},
+		{"action=url.add", []uint{events[1].ID, events[0].ID}},
+		{"username=alice&target_type=url", []uint{events[0].ID}},
+		{"from=2026-03-02&to=2026-03-02", []uint{events[2].ID, events[1].ID}},
+		{"to=2026-03-01", []uint{events[0].ID}},
+	}
+	for _, tt := range tests {
+		c, rec := newGetContext("/audit/export.json?"+tt.query, admin)
+		if err := AuditExport(c); err != nil {
+			t.Fatal(err)
+		}
+		var exported []auditExportEntry
+		decodeJSON(t, rec, &exported)
+		var got []uint
+		for _, entry := range exported {
+			got = append(got, entry.ID)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q exported %v, want %v", tt.query, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q exported %v, want %v", tt.query, got, tt.want)
+				break
+			}
+		}
+
+		c, _ = newGetContext("/audit?"+tt.query, admin)
+		if err := AuditGet(c); err != nil {
+			t.Fatal(err)
+		}
+		if _, data := rendered(c); len(data["Events"].([]models.AuditEvent)) != len(tt.want) {
+			t.Errorf("%q listed %d events, want %d", tt.query, len(data["Events"].([]models.AuditEvent)), len(tt.want))
+		}
+	}
+
+	c, rec := newGetContext("/audit/export.json?from=yesterday", admin)
+	if err := AuditExport(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/audit" {
+		t.Errorf("invalid date: status %d to %q, want a redirect to /audit", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// loadWatchedURL loads the URL with the given ID, flashing an error and
+// returning nil if there is none.
+func loadWatchedURL(c echo.Context, urlIDStr string) (*models.WatchedUrl, error) {
+	urlID, err := strconv.ParseUint(urlIDStr, 10, 32)
+	if err != nil {
+		Flash(c, "Invalid URL ID.")
+		return nil, c.Redirect(http.StatusFound, "/dashboard")
+	}
+	var watchedURL models.WatchedUrl
+	if result := database.DB.First(&watchedURL, urlID); result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			Flash(c, "URL not found.")
+		} else {
+			Flash(c, "Database error finding URL: "+result.Error.Error())
+		}
+		return nil, c.Redirect(http.StatusFound, "/dashboard")
+	}
+	return &watchedURL, nil
+}
+
+// ChunksGet lists the chunks found in a bundle and which of them are watched.
+func ChunksGet(c echo.Context) error {
+	watchedURL, err := loadWatchedURL(c, c.Param("url_id"))
+	if watchedURL == nil {
+		return err
+	}
+	chunks := watchedURL.ChunkURLs()
+
+	// Archived chunks can't be watched again until they are restored or purged.
+	var existing []models.WatchedUrl
+	if len(chunks) > 0 {
+		if result := database.DB.Unscoped().Select("id", "url", "group_id", "deleted_at").Where("url IN ?", chunks).Find(&existing); result.Error != nil {
+			Flash(c, "Database error loading watched URLs: "+result.Error.Error())
+		}
+	}
+	watched := make(map[string]uint, len(existing))
+	archived := make(map[string]bool)
+	for _, u := range existing {
+		if u.DeletedAt.Valid {
+			archived[u.URL] = true
+		} else {
+			watched[u.URL] = u.ID
+		}
+	}
+
+	var group *models.URLGroup
+	if watchedURL.GroupID != nil {
+		var g models.URLGroup
+		if database.DB.First(&g, *watchedURL.GroupID).Error == nil {
+			group = &g
+		}
+	}
+
+	return c.Render(http.StatusOK, "chunks.html", echo.Map{
+		"WatchedURL":     watchedURL,
+		"Chunks":         chunks,
+		"Watched":        watched,
+		"Archived":       archived,
+		"UnwatchedCount": len(chunks) - len(existing),
+		"Group":          group,
+		"Flashes":        GetFlashes(c),
+	})
+}
+
+// FindChunks analyzes a bundle's last content for chunks again.
+func FindChunks(c echo.Context) error {
+	watchedURL, err := loadWatchedURL(c, c.FormValue("url_id"))
+	if watchedURL == nil {
+		return err
+	}
+	chunksPage := fmt.Sprintf("/chunks/%d", watchedURL.ID)
+	if watchedURL.LastContent == "" {
+		Flash(c, "This URL has not been fetched yet.")
+		return c.Redirect(http.StatusFound, chunksPage)
+	}
+
+	count, err := services.RefreshChunks(database.DB, watchedURL.ID)
+	if err != nil {
+		Flash(c, "Failed to look for chunks: "+err.Error())
+		return c.Redirect(http.StatusFound, chunksPage)
+	}
+	Flash(c, fmt.Sprintf("Found %d chunks in %s.", count, watchedURL.URL))
+	return c.Redirect(http.StatusFound, chunksPage)
+}
+
+// WatchChunks watches the selected chunks of a bundle, in the bundle's group
+// if it has one.
+func WatchChunks(c echo.Context) error {
+	watchedURL, err := loadWatchedURL(c, c.FormValue("url_id"))
+	if watchedURL == nil {
+		return err
+	}
+	chunksPage := fmt.Sprintf("/chunks/%d", watchedURL.ID)
+
+	form, err := c.FormParams()
+	if err != nil {
+		Flash(c, "Invalid form data.")
+		return c.Redirect(http.StatusFound, chunksPage)
+	}
+	// Only chunks that were actually found in the bundle can be added this way.
+	found := make(map[string]bool)
+	for _, chunk := range watchedURL.ChunkURLs() {
+		found[chunk] = true
+	}
+	var selected []string
+	for _, chunk := range form["chunks"] {
+		if found[chunk] {
+			selected = append(selected, chunk)
+		}
+	}
+	if len(selected) == 0 {
+		Flash(c, "No chunks selected.")
+		return c.Redirect(http.StatusFound, chunksPage)
+	}
+
+	added, err := services.WatchChunkURLs(database.DB, *watchedURL, selected)
+	if err != nil {
+		Flash(c, "Failed to watch chunks: "+err.Error())
+	}
+	if len(added) > 0 {
+		auditURL(c, services.AuditURLChunks, *watchedURL, fmt.Sprintf("watched %d chunks", len(added)))
+	}
+	if skipped := len(selected) - len(added); skipped > 0 && err == nil {
+		Flash(c, fmt.Sprintf("Watching %d chunks. %d were skipped because they are already watched or archived, or not allowed by the fetch target policy.", len(added), skipped))
+	} else if err == nil {
+		Flash(c, fmt.Sprintf("Watching %d chunks.", len(added)))
+	}
+	return c.Redirect(http.StatusFound, chunksPage)
+}
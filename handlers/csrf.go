@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	csrfContextKey = "csrf"
+	csrfFormField  = "csrf_token" // Hidden field every POST form in the templates must include
+)
+
+// cookieSecure and cookieSameSite are shared by the session and CSRF cookies.
+var (
+	cookieSecure   bool
+	cookieSameSite = http.SameSiteLaxMode
+)
+
+// CSRF checks the csrf_token form field of every state-changing request
+// against the token in the _csrf cookie. The JSON API is skipped because it
+// authenticates with bearer tokens, which a foreign page can't attach.
+func CSRF() echo.MiddlewareFunc {
+	return middleware.CSRFWithConfig(middleware.CSRFConfig{
+		Skipper: func(c echo.Context) bool {
+			return strings.HasPrefix(c.Request().URL.Path, "/api/")
+		},
+		TokenLookup:    "form:" + csrfFormField,
+		ContextKey:     csrfContextKey,
+		CookiePath:     "/",
+		CookieMaxAge:   86400 * 7,
+		CookieHTTPOnly: true,
+		CookieSecure:   cookieSecure,
+		CookieSameSite: cookieSameSite,
+		ErrorHandler: func(err error, c echo.Context) error {
+			Flash(c, "Your form was missing a valid security token, so nothing was changed. Please try again.")
+			if c.Request().URL.Path == "/login" {
+				return c.Redirect(http.StatusFound, "/login")
+			}
+			return c.Redirect(http.StatusFound, "/dashboard")
+		},
+	})
+}
+
+// CSRFToken returns the token templates put in the csrf_token field of their forms.
+func CSRFToken(c echo.Context) string {
+	token, _ := c.Get(csrfContextKey).(string)
+	return token
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+const endpointsPageLimit = 500
+
+// endpointFilter reads the endpoint inventory's filters: q (part of the
+// value), kind, group_id (a group ID, or "none" for URLs without a group) and
+// url_id.
+func endpointFilter(c echo.Context) (services.EndpointFilter, error) {
+	filter := services.EndpointFilter{
+		Search: c.QueryParam("q"),
+		Kind:   c.QueryParam("kind"),
+	}
+	if filter.Kind != "" && !containsString(services.EndpointKinds, filter.Kind) {
+		return filter, fmt.Errorf("kind must be one of %v", services.EndpointKinds)
+	}
+	switch groupID := c.QueryParam("group_id"); groupID {
+	case "":
+	case "none":
+		filter.NoGroup = true
+	default:
+		id, err := strconv.ParseUint(groupID, 10, 32)
+		if err != nil {
+			return filter, fmt.Errorf("group_id must be a number or \"none\"")
+		}
+		group := uint(id)
+		filter.GroupID = &group
+	}
+	if urlID := c.QueryParam("url_id"); urlID != "" {
+		id, err := strconv.ParseUint(urlID, 10, 32)
+		if err != nil {
+			return filter, fmt.Errorf("url_id must be a number")
+		}
+		filter.URLID = uint(id)
+	}
+	return filter, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// EndpointsGet searches the endpoints found in the scripts of every watched URL.
+func EndpointsGet(c echo.Context) error {
+	var rows []services.EndpointRow
+	var total int64
+	filter, err := endpointFilter(c)
+	if err != nil {
+		Flash(c, "Invalid filter: "+err.Error())
+	} else {
+		query := services.EndpointInventory(database.DB, filter)
+		if res := query.Count(&total); res.Error != nil {
+			Flash(c, "Database error loading endpoints: "+res.Error.Error())
+		} else if res := query.Select(services.EndpointRowColumns).Order("endpoints.value, watched_urls.url").Limit(endpointsPageLimit).Scan(&rows); res.Error != nil {
+			Flash(c, "Database error loading endpoints: "+res.Error.Error())
+		}
+	}
+
+	var groups []models.URLGroup
+	database.DB.Order("name ASC").Find(&groups)
+	var filterURL *models.WatchedUrl
+	if filter.URLID != 0 {
+		var u models.WatchedUrl
+		if database.DB.Select("id", "url").First(&u, filter.URLID).Error == nil {
+			filterURL = &u
+		}
+	}
+
+	return c.Render(http.StatusOK, "endpoints.html", echo.Map{
+		"Endpoints": rows,
+		"Total":     total,
+		"Limit":     endpointsPageLimit,
+		"Filter":    filter,
+		"GroupID":   c.QueryParam("group_id"),
+		"FilterURL": filterURL,
+		"Kinds":     services.EndpointKinds,
+		"Groups":    groups,
+		"Flashes":   GetFlashes(c),
+	})
+}
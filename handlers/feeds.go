@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	feedEntryLimit = 50
+
+	// Diffs larger than this are left out of feed entries; readers follow the link instead.
+	maxFeedDiffSize = 64 * 1024
+)
+
+// feedView is a Feed with its decrypted token and a readable title for the feeds page.
+type feedView struct {
+	models.Feed
+	Title   string
+	AtomURL string
+	RSSURL  string
+}
+
+// feedTitle describes what a feed covers.
+func feedTitle(feed models.Feed) string {
+	switch feed.Scope {
+	case "group":
+		var group models.URLGroup
+		if feed.GroupID != nil && database.DB.First(&group, *feed.GroupID).Error == nil {
+			return "Changes in group " + group.Name
+		}
+		return "Changes in a removed group"
+	case "url":
+		var urlEntry models.WatchedUrl
+		if feed.URLID != nil && database.DB.Select("id", "url").First(&urlEntry, *feed.URLID).Error == nil {
+			return "Changes in " + urlEntry.URL
+		}
+		return "Changes in a removed URL"
+	}
+	return "All changes"
+}
+
+// externalBaseURL is APP_BASE_URL, or the address of the current request when it is not set.
+func externalBaseURL(c echo.Context) string {
+	if BaseURL != "" {
+		return strings.TrimRight(BaseURL, "/")
+	}
+	return c.Scheme() + "://" + c.Request().Host
+}
+
+func FeedsGet(c echo.Context) error {
+	var feeds []models.Feed
+	if result := database.DB.Order("created_at ASC").Find(&feeds); result.Error != nil {
+		Flash(c, "Database error loading feeds: "+result.Error.Error())
+	}
+
+	base := externalBaseURL(c)
+	views := make([]feedView, 0, len(feeds))
+	for _, feed := range feeds {
+		token, err := services.DecryptSecret(feed.Token)
+		if err != nil {
+			Flash(c, fmt.Sprintf("Could not decrypt the token of feed %d: %v", feed.ID, err))
+			continue
+		}
+		views = append(views, feedView{
+			Feed:    feed,
+			Title:   feedTitle(feed),
+			AtomURL: fmt.Sprintf("%s/feeds/%s/atom.xml", base, token),
+			RSSURL:  fmt.Sprintf("%s/feeds/%s/rss.xml", base, token),
+		})
+	}
+
+	var groups []models.URLGroup
+	database.DB.Order("name ASC").Find(&groups)
+	var urls []models.WatchedUrl
+	database.DB.Select("id", "url").Order("url ASC").Find(&urls)
+
+	return c.Render(http.StatusOK, "feeds.html", echo.Map{
+		"Feeds":   views,
+		"Groups":  groups,
+		"URLs":    urls,
+		"Flashes": GetFlashes(c),
+	})
+}
+
+// CreateFeed creates a feed. The "target" form field is "all", "group:<id>" or "url:<id>".
+func CreateFeed(c echo.Context) error {
+	feed := models.Feed{Scope: "all"}
+	if scope, idStr, ok := strings.Cut(c.FormValue("target"), ":"); ok {
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			Flash(c, "Invalid feed target.")
+			return c.Redirect(http.StatusFound, "/feeds")
+		}
+		targetID := uint(id)
+		switch scope {
+		case "group":
+			if database.DB.First(&models.URLGroup{}, targetID).Error != nil {
+				Flash(c, "Group not found.")
+				return c.Redirect(http.StatusFound, "/feeds")
+			}
+			feed.Scope, feed.GroupID = "group", &targetID
+		case "url":
+			if database.DB.First(&models.WatchedUrl{}, targetID).Error != nil {
+				Flash(c, "URL not found.")
+				return c.Redirect(http.StatusFound, "/feeds")
+			}
+			feed.Scope, feed.URLID = "url", &targetID
+		default:
+			Flash(c, "Invalid feed target.")
+			return c.Redirect(http.StatusFound, "/feeds")
+		}
+	}
+
+	_, hash, encrypted, err := services.GenerateFeedToken()
+	if err != nil {
+		Flash(c, "Failed to generate feed token: "+err.Error())
+		return c.Redirect(http.StatusFound, "/feeds")
+	}
+	feed.TokenHash = hash
+	feed.Token = encrypted
+
+	if result := database.DB.Create(&feed); result.Error != nil {
+		Flash(c, "Failed to create feed: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/feeds")
+	}
+
+	recordAudit(c, models.AuditEvent{Action: services.AuditFeedCreate, TargetType: "feed", TargetID: feed.ID, Target: feedTitle(feed)})
+	Flash(c, "Feed created: "+feedTitle(feed)+".")
+	return c.Redirect(http.StatusFound, "/feeds")
+}
+
+// RotateFeedToken replaces a feed's token, so subscribers using the old feed URL lose access.
+func RotateFeedToken(c echo.Context) error {
+	var feed models.Feed
+	if result := database.DB.First(&feed, c.FormValue("id")); result.Error != nil {
+		Flash(c, "Feed not found.")
+		return c.Redirect(http.StatusFound, "/feeds")
+	}
+
+	_, hash, encrypted, err := services.GenerateFeedToken()
+	if err != nil {
+		Flash(c, "Failed to generate feed token: "+err.Error())
+		return c.Redirect(http.StatusFound, "/feeds")
+	}
+	if result := database.DB.Model(&feed).Updates(map[string]interface{}{"token_hash": hash, "token": encrypted}); result.Error != nil {
+		Flash(c, "Failed to update feed: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/feeds")
+	}
+
+	recordAudit(c, models.AuditEvent{Action: services.AuditFeedRotate, TargetType: "feed", TargetID: feed.ID, Target: feedTitle(feed)})
+	Flash(c, "Feed URL regenerated. Update it in your feed reader.")
+	return c.Redirect(http.StatusFound, "/feeds")
+}
+
+func RemoveFeed(c echo.Context) error {
+	var feed models.Feed
+	if result := database.DB.First(&feed, c.FormValue("id")); result.Error != nil {
+		Flash(c, "Feed not found.")
+		return c.Redirect(http.StatusFound, "/feeds")
+	}
+	if result := database.DB.Unscoped().Delete(&feed); result.Error != nil {
+		Flash(c, "Failed to remove feed: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/feeds")
+	}
+
+	recordAudit(c, models.AuditEvent{Action: services.AuditFeedDelete, TargetType: "feed", TargetID: feed.ID, Target: feedTitle(feed)})
+	Flash(c, "Feed removed.")
+	return c.Redirect(http.StatusFound, "/feeds")
+}
+
+// feedEntry is one change, ready to be written as an Atom entry or RSS item.
+type feedEntry struct {
+	Title   string
+	Link    string
+	Updated time.Time
+	Content string
+}
+
+// loadFeed looks up the feed named by the :token route parameter and builds its entries.
+func loadFeed(c echo.Context) (*models.Feed, []feedEntry, error) {
+	var feed models.Feed
+	result := database.DB.Where("token_hash = ?", services.ContentHash(c.Param("token"))).First(&feed)
+	if result.Error == gorm.ErrRecordNotFound {
+		return nil, nil, c.String(http.StatusNotFound, "Feed not found.")
+	} else if result.Error != nil {
+		return nil, nil, c.String(http.StatusInternalServerError, "Database error loading feed.")
+	}
+
+	query := database.DB.Model(&models.ChangeEvent{}).Scopes(services.OfLiveURLs(database.DB))
+	switch feed.Scope {
+	case "group":
+		query = query.Where("url_id IN (?)", database.DB.Model(&models.WatchedUrl{}).Select("id").Where("group_id = ?", feed.GroupID))
+	case "url":
+		query = query.Where("url_id = ?", feed.URLID)
+	}
+	var changes []models.ChangeEvent
+	if result := query.Order("detected_at DESC, id DESC").Limit(feedEntryLimit).Find(&changes); result.Error != nil {
+		return nil, nil, c.String(http.StatusInternalServerError, "Database error loading changes.")
+	}
+
+	urlNames := map[uint]string{}
+	base := externalBaseURL(c)
+	entries := make([]feedEntry, 0, len(changes))
+	for _, change := range changes {
+		name, ok := urlNames[change.URLID]
+		if !ok {
+			var urlEntry models.WatchedUrl
+			if database.DB.Select("id", "url").First(&urlEntry, change.URLID).Error == nil {
+				name = urlEntry.URL
+			} else {
+				name = fmt.Sprintf("URL #%d", change.URLID)
+			}
+			urlNames[change.URLID] = name
+		}
+
+		content := `<pre style="white-space: pre-wrap;">` + change.DiffText + `</pre>`
+		if len(change.DiffText) > maxFeedDiffSize {
+			content = "<p>The diff is too large to include in the feed. Follow the link to view it.</p>"
+		}
+		entries = append(entries, feedEntry{
+			Title:   "Change detected in " + name,
+			Link:    fmt.Sprintf("%s/diff/%d", base, change.ID),
+			Updated: change.DetectedAt,
+			Content: content,
+		})
+	}
+	return &feed, entries, nil
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title   string    `xml:"title"`
+	ID      string    `xml:"id"`
+	Link    atomLink  `xml:"link"`
+	Updated string    `xml:"updated"`
+	Content *atomText `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func FeedAtom(c echo.Context) error {
+	feed, entries, err := loadFeed(c)
+	if feed == nil {
+		return err
+	}
+
+	selfURL := externalBaseURL(c) + c.Request().URL.Path
+	out := atomFeed{
+		Title:   "JS Watcher: " + feedTitle(*feed),
+		ID:      selfURL,
+		Updated: feed.CreatedAt.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: selfURL, Rel: "self"},
+			{Href: externalBaseURL(c) + "/dashboard"},
+		},
+	}
+	if len(entries) > 0 {
+		out.Updated = entries[0].Updated.UTC().Format(time.RFC3339)
+	}
+	for _, entry := range entries {
+		out.Entries = append(out.Entries, atomEntry{
+			Title:   entry.Title,
+			ID:      entry.Link,
+			Link:    atomLink{Href: entry.Link},
+			Updated: entry.Updated.UTC().Format(time.RFC3339),
+			Content: &atomText{Type: "html", Body: entry.Content},
+		})
+	}
+	return writeFeedXML(c, "application/atom+xml; charset=utf-8", out)
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+func FeedRSS(c echo.Context) error {
+	feed, entries, err := loadFeed(c)
+	if feed == nil {
+		return err
+	}
+
+	title := feedTitle(*feed)
+	out := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       "JS Watcher: " + title,
+			Link:        externalBaseURL(c) + "/dashboard",
+			Description: title + " detected by JS Watcher",
+		},
+	}
+	if len(entries) > 0 {
+		out.Channel.LastBuildDate = entries[0].Updated.UTC().Format(time.RFC1123Z)
+	}
+	for _, entry := range entries {
+		out.Channel.Items = append(out.Channel.Items, rssItem{
+			Title:       entry.Title,
+			Link:        entry.Link,
+			GUID:        rssGUID{IsPermaLink: true, Value: entry.Link},
+			PubDate:     entry.Updated.UTC().Format(time.RFC1123Z),
+			Description: entry.Content,
+		})
+	}
+	return writeFeedXML(c, "application/rss+xml; charset=utf-8", out)
+}
+
+func writeFeedXML(c echo.Context, contentType string, v interface{}) error {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to build feed.")
+	}
+	return c.Blob(http.StatusOK, contentType, append([]byte(xml.Header), body...))
+}
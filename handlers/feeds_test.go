@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+)
+
+// newTestFeed creates a feed and returns it with its token.
+func newTestFeed(t *testing.T, feed models.Feed) (models.Feed, string) {
+	t.Helper()
+	token, hash, encrypted, err := services.GenerateFeedToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.TokenHash, feed.Token = hash, encrypted
+	if err := database.DB.Create(&feed).Error; err != nil {
+		t.Fatal(err)
+	}
+	return feed, token
+}
+
+// readAtom requests the Atom feed with token and returns the entry titles,
+// sorted, or the status if it isn't 200.
+func readAtom(t *testing.T, token string) ([]string, int) {
+	t.Helper()
+	c, rec := newGetContext("/feeds/"+token+"/atom.xml", nil, "token", token)
+	if err := FeedAtom(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		return nil, rec.Code
+	}
+	var feed atomFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("parsing the feed: %v\n%s", err, rec.Body)
+	}
+	var titles []string
+	for _, entry := range feed.Entries {
+		titles = append(titles, entry.Title)
+	}
+	sort.Strings(titles)
+	return titles, rec.Code
+}
+
+func TestFeedScopes(t *testing.T) {
+	useTestDB(t)
+	group := models.URLGroup{Name: "app", SourceURL: "https://example.com/"}
+	database.DB.Create(&group)
+	inGroup := newTestURL(t, models.WatchedUrl{URL: "https://example.com/a.js", GroupID: &group.ID})
+	alone := newTestURL(t, models.WatchedUrl{URL: "https://example.com/b.js"})
+	archived := newTestURL(t, models.WatchedUrl{URL: "https://example.com/c.js"})
+	for _, urlEntry := range []models.WatchedUrl{inGroup, alone, archived} {
+		database.DB.Create(&models.ChangeEvent{URLID: urlEntry.ID, DetectedAt: time.Now().UTC(), DiffText: "<ins>x</ins>"})
+	}
+	services.ArchiveURL(database.DB, &archived)
+
+	_, allToken := newTestFeed(t, models.Feed{Scope: "all"})
+	_, groupToken := newTestFeed(t, models.Feed{Scope: "group", GroupID: &group.ID})
+	_, urlToken := newTestFeed(t, models.Feed{Scope: "url", URLID: &alone.ID})
+
+	tests := []struct {
+		name  string
+		token string
+		want  []string
+	}{
+		{"all", allToken, []string{"Change detected in https://example.com/a.js", "Change detected in https://example.com/b.js"}},
+		{"group", groupToken, []string{"Change detected in https://example.com/a.js"}},
+		{"url", urlToken, []string{"Change detected in https://example.com/b.js"}},
+	}
+	for _, tt := range tests {
+		titles, status := readAtom(t, tt.token)
+		if status != http.StatusOK || len(titles) != len(tt.want) {
+			t.Errorf("%s feed: %d %q, want %q", tt.name, status, titles, tt.want)
+			continue
+		}
+		for i := range titles {
+			if titles[i] != tt.want[i] {
+				t.Errorf("%s feed: %q, want %q", tt.name, titles, tt.want)
+			}
+		}
+	}
+
+	if _, status := readAtom(t, "jsf_unknown"); status != http.StatusNotFound {
+		t.Errorf("unknown token: status %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestFeedRSS(t *testing.T) {
+	useTestDB(t)
+	urlEntry := newTestURL(t, models.WatchedUrl{URL: "https://example.com/app.js"})
+	change := models.ChangeEvent{URLID: urlEntry.ID, DetectedAt: time.Now().UTC(), DiffText: "<ins>x</ins>"}
+	database.DB.Create(&change)
+	_, token := newTestFeed(t, models.Feed{Scope: "all"})
+
+	BaseURL = "https://watcher.example.com"
+	defer func() { BaseURL = "" }()
+	c, rec := newGetContext("/feeds/"+token+"/rss.xml", nil, "token", token)
+	if err := FeedRSS(c); err != nil {
+		t.Fatal(err)
+	}
+	var feed rssFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("parsing the feed: %v\n%s", err, rec.Body)
+	}
+	if len(feed.Channel.Items) != 1 {
+		t.Fatalf("%d items, want 1", len(feed.Channel.Items))
+	}
+	item := feed.Channel.Items[0]
+	if want := "https://watcher.example.com/diff/" + strconv.Itoa(int(change.ID)); item.Link != want {
+		t.Errorf("item link = %q, want %q", item.Link, want)
+	}
+	if item.Description != `<pre style="white-space: pre-wrap;"><ins>x</ins></pre>` {
+		t.Errorf("item description = %q", item.Description)
+	}
+}
+
+func TestRotateFeedToken(t *testing.T) {
+	useTestDB(t)
+	editor := newTestUser(t, "editor", models.RoleEditor)
+	feed, oldToken := newTestFeed(t, models.Feed{Scope: "all"})
+
+	c, _ := newFormContext("/rotate_feed_token", url.Values{"id": {strconv.Itoa(int(feed.ID))}}, editor)
+	if err := RotateFeedToken(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, status := readAtom(t, oldToken); status != http.StatusNotFound {
+		t.Errorf("old token after rotation: status %d, want %d", status, http.StatusNotFound)
+	}
+	database.DB.First(&feed, feed.ID)
+	newToken, err := services.DecryptSecret(feed.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, status := readAtom(t, newToken); status != http.StatusOK {
+		t.Errorf("new token: status %d, want %d", status, http.StatusOK)
+	}
+}
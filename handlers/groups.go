@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// inventoryChangesLimit is how many inventory changes the group page lists.
+const inventoryChangesLimit = 100
+
+// loadGroup loads the group with the given ID, flashing an error and
+// returning nil if there is none.
+func loadGroup(c echo.Context, groupIDStr string) (*models.URLGroup, error) {
+	groupID, err := strconv.ParseUint(groupIDStr, 10, 32)
+	if err != nil {
+		Flash(c, "Invalid group ID.")
+		return nil, c.Redirect(http.StatusFound, "/dashboard")
+	}
+	var group models.URLGroup
+	if result := database.DB.First(&group, groupID); result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			Flash(c, "Group not found.")
+		} else {
+			Flash(c, "Database error finding group: "+result.Error.Error())
+		}
+		return nil, c.Redirect(http.StatusFound, "/dashboard")
+	}
+	return &group, nil
+}
+
+// GroupGet shows a group's re-extraction settings, its current script
+// inventory and the inventory changes found so far.
+func GroupGet(c echo.Context) error {
+	group, err := loadGroup(c, c.Param("id"))
+	if group == nil {
+		return err
+	}
+
+	var watchedURLs []string
+	if result := database.DB.Model(&models.WatchedUrl{}).Where("group_id = ?", group.ID).Pluck("url", &watchedURLs); result.Error != nil {
+		Flash(c, "Database error loading group URLs: "+result.Error.Error())
+	}
+	watched := make(map[string]bool, len(watchedURLs))
+	for _, u := range watchedURLs {
+		watched[u] = true
+	}
+
+	var changes []models.InventoryChange
+	result := database.DB.Where("group_id = ?", group.ID).Order("detected_at DESC, id DESC").Limit(inventoryChangesLimit).Find(&changes)
+	if result.Error != nil {
+		Flash(c, "Database error loading inventory changes: "+result.Error.Error())
+	}
+
+	return c.Render(http.StatusOK, "group.html", echo.Map{
+		"Group":        group,
+		"Watched":      watched,
+		"WatchedCount": len(watchedURLs),
+		"Changes":      changes,
+		"ExtractTools": services.AvailableExtractTools(),
+		"MaxHours":     services.MaxReextractHours,
+		"Flashes":      GetFlashes(c),
+	})
+}
+
+// SaveGroupExtraction updates a group's re-extraction schedule and the
+// settings used for scripts that are watched automatically.
+func SaveGroupExtraction(c echo.Context) error {
+	group, err := loadGroup(c, c.FormValue("group_id"))
+	if group == nil {
+		return err
+	}
+	groupPage := fmt.Sprintf("/group/%d", group.ID)
+
+	hours, err := strconv.Atoi(c.FormValue("reextract_hours"))
+	if err != nil || hours < 0 || hours > services.MaxReextractHours {
+		Flash(c, fmt.Sprintf("Invalid re-extraction interval. Must be between 0 and %d hours.", services.MaxReextractHours))
+		return c.Redirect(http.StatusFound, groupPage)
+	}
+	scriptInterval, err := strconv.Atoi(c.FormValue("script_interval"))
+	if err != nil || scriptInterval < 0 {
+		Flash(c, "Invalid interval for new scripts. Must be a positive number, or 0 to copy the group's newest URL.")
+		return c.Redirect(http.StatusFound, groupPage)
+	}
+	tool := c.FormValue("tool")
+	if tool == services.ExtractToolBuiltin {
+		tool = ""
+	} else if tool != group.ExtractTool && !containsTool(services.AvailableExtractTools(), tool) {
+		Flash(c, "Invalid tool selected.")
+		return c.Redirect(http.StatusFound, groupPage)
+	}
+
+	before := *group
+	group.ReextractHours = hours
+	group.ExtractTool = tool
+	group.AutoWatchNewScripts = c.FormValue("auto_watch") == "on"
+	group.AutoWatchChunks = c.FormValue("auto_watch_chunks") == "on"
+	group.ScriptInterval = scriptInterval
+	group.ScriptBeautify = c.FormValue("script_beautify") == "on"
+	if CurrentUser(c).IsAdmin() {
+		group.AllowPrivateTarget = requestedPrivateTarget(c)
+	}
+	// A new schedule counts from the last extraction; without one the group is due now.
+	group.NextExtractAt = nil
+	if hours > 0 && group.LastExtractedAt != nil {
+		next := group.LastExtractedAt.Add(time.Duration(hours) * time.Hour)
+		group.NextExtractAt = &next
+	}
+
+	result := database.DB.Model(group).
+		Select("reextract_hours", "extract_tool", "auto_watch_new_scripts", "auto_watch_chunks", "script_interval", "script_beautify", "allow_private_target", "next_extract_at").
+		Updates(group)
+	if result.Error != nil {
+		Flash(c, "Failed to save re-extraction settings: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, groupPage)
+	}
+
+	auditGroup(c, services.AuditGroupEdit, *group, describeGroupChanges(before, *group))
+	Flash(c, "Re-extraction settings saved.")
+	return c.Redirect(http.StatusFound, groupPage)
+}
+
+// ReextractGroup extracts a group's source page right away.
+func ReextractGroup(c echo.Context) error {
+	group, err := loadGroup(c, c.FormValue("group_id"))
+	if group == nil {
+		return err
+	}
+	groupPage := fmt.Sprintf("/group/%d", group.ID)
+
+	change, err := services.ReextractNow(group.ID)
+	if err != nil {
+		Flash(c, "Re-extraction failed: "+err.Error())
+		return c.Redirect(http.StatusFound, groupPage)
+	}
+
+	details := "no changes"
+	if change != nil {
+		details = fmt.Sprintf("%d added, %d removed, %d watched", len(change.AddedURLs()), len(change.RemovedURLs()), len(change.WatchedURLs()))
+	}
+	auditGroup(c, services.AuditGroupExtract, *group, details)
+	Flash(c, "Re-extracted "+group.SourceURL+": "+details+".")
+	return c.Redirect(http.StatusFound, groupPage)
+}
+
+func containsTool(tools []string, tool string) bool {
+	for _, t := range tools {
+		if t == tool {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
-	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"time"
@@ -24,24 +23,33 @@ import (
 
 const (
 	sessionName   = "js-watcher-session"
-	sessionKey    = "logged_in"
+	sessionKey    = "user_id"
 	flashMessages = "flash_messages"
 )
 
 var (
 	store *sessions.CookieStore
 
-	AppUsername string
-	AppPassword string
-	BaseURL     string
+	BaseURL string
 )
 
-func SetSessionStore(secretKey []byte) {
+// SetSessionStore configures the session cookie. secure and sameSite also
+// apply to the CSRF cookie, so call it before installing the CSRF middleware.
+// Browsers reject SameSite=None cookies that aren't Secure, so None implies secure.
+func SetSessionStore(secretKey []byte, secure bool, sameSite http.SameSite) {
+	if sameSite == http.SameSiteNoneMode {
+		secure = true
+	}
+	cookieSecure = secure
+	cookieSameSite = sameSite
+
 	store = sessions.NewCookieStore(secretKey)
 	store.Options = &sessions.Options{
 		Path:     "/",
 		MaxAge:   86400 * 7,
 		HttpOnly: true,
+		Secure:   secure,
+		SameSite: sameSite,
 	}
 }
 
@@ -72,12 +80,28 @@ func GetFlashes(c echo.Context) []string {
 	return messages
 }
 
+// AuthMiddleware requires a logged-in user and makes it available through currentUser.
 func AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		session, _ := store.Get(c.Request(), sessionName)
-		if auth, ok := session.Values[sessionKey].(bool); !ok || !auth {
+		userID, ok := session.Values[sessionKey].(uint)
+		if !ok || userID == 0 {
 			return c.Redirect(http.StatusFound, "/login")
 		}
+
+		var user models.User
+		if result := database.DB.First(&user, userID); result.Error != nil {
+			// The account was removed while the session was still open.
+			delete(session.Values, sessionKey)
+			session.Save(c.Request(), c.Response())
+			return c.Redirect(http.StatusFound, "/login")
+		}
+		c.Set(userContextKey, &user)
+
+		if needsTOTPEnrollment(&user) && !enrollmentPaths[c.Path()] {
+			Flash(c, "Two-factor authentication is required for your account. Set it up to continue.")
+			return c.Redirect(http.StatusFound, "/account/2fa")
+		}
 		return next(c)
 	}
 }
@@ -92,20 +116,49 @@ func LoginPost(c echo.Context) error {
 	username := c.FormValue("username")
 	password := c.FormValue("password")
 
-	if username == AppUsername && password == AppPassword {
+	attempt := beginLogin(c, username)
+	if attempt == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	user, err := services.Authenticate(database.DB, username, password)
+	if err != nil {
+		services.FinishLoginAttempt(database.DB, attempt, models.LoginFailure, "error: "+err.Error())
+		Flash(c, "Login failed: "+err.Error())
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	if user != nil {
 		session, _ := store.Get(c.Request(), sessionName)
-		session.Values[sessionKey] = true
+		if user.TOTPEnabled {
+			// The password was right; the session is only logged in after the TOTP step.
+			session.Values[pendingUserKey] = user.ID
+			session.Values[pendingSinceKey] = time.Now().Unix()
+			session.Save(c.Request(), c.Response())
+			// Neither a failure nor a login yet: the outcome is recorded at the second step.
+			services.ReleaseLoginAttempt(database.DB, attempt)
+			return c.Redirect(http.StatusFound, "/login/2fa")
+		}
+		session.Values[sessionKey] = user.ID
 		session.Save(c.Request(), c.Response())
+		services.FinishLoginAttempt(database.DB, attempt, models.LoginSuccess, "password")
+		recordAudit(c, models.AuditEvent{UserID: user.ID, Username: user.Username, Action: services.AuditLogin, Details: "password"})
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
 
+	services.FinishLoginAttempt(database.DB, attempt, models.LoginFailure, "invalid credentials")
 	Flash(c, "Invalid credentials")
 	return c.Redirect(http.StatusFound, "/login")
 }
 
 func Logout(c echo.Context) error {
 	session, _ := store.Get(c.Request(), sessionName)
-	session.Values[sessionKey] = false
+	if userID, ok := session.Values[sessionKey].(uint); ok {
+		var user models.User
+		if result := database.DB.First(&user, userID); result.Error == nil {
+			recordAudit(c, models.AuditEvent{UserID: user.ID, Username: user.Username, Action: services.AuditLogout})
+		}
+	}
+	delete(session.Values, sessionKey)
 	session.Options.MaxAge = -1
 	session.Save(c.Request(), c.Response())
 	return c.Redirect(http.StatusFound, "/login")
@@ -140,13 +193,31 @@ func Dashboard(c echo.Context) error {
 	// 	}
 	// }
 
+	user := CurrentUser(c)
+	var recentChanges []*models.ChangeEvent
+	for i := range urls {
+		for j := range urls[i].Changes {
+			recentChanges = append(recentChanges, &urls[i].Changes[j])
+		}
+	}
+	for i := range urlGroups {
+		for j := range urlGroups[i].URLs {
+			for k := range urlGroups[i].URLs[j].Changes {
+				recentChanges = append(recentChanges, &urlGroups[i].URLs[j].Changes[k])
+			}
+		}
+	}
+	if err := services.FillReadState(database.DB, user.ID, recentChanges); err != nil {
+		Flash(c, "Error loading read state: "+err.Error())
+	}
+
 	var totalURLs int64
 	database.DB.Model(&models.WatchedUrl{}).Count(&totalURLs)
 
 	var urlsWithUnreadChanges int64
 	database.DB.Model(&models.WatchedUrl{}).
 		Joins("JOIN change_events ON watched_urls.id = change_events.url_id").
-		Where("change_events.is_read = ?", false).
+		Scopes(services.UnreadBy(user.ID)).
 		Distinct("watched_urls.id").
 		Count(&urlsWithUnreadChanges)
 
@@ -159,8 +230,8 @@ func Dashboard(c echo.Context) error {
 	sevenDaysAgo := now.Add(-7 * 24 * time.Hour)
 
 	var changes24h, changes7d int64
-	database.DB.Model(&models.ChangeEvent{}).Where("detected_at >= ?", twentyFourHoursAgo).Count(&changes24h)
-	database.DB.Model(&models.ChangeEvent{}).Where("detected_at >= ?", sevenDaysAgo).Count(&changes7d)
+	database.DB.Model(&models.ChangeEvent{}).Scopes(services.OfLiveURLs(database.DB)).Where("detected_at >= ?", twentyFourHoursAgo).Count(&changes24h)
+	database.DB.Model(&models.ChangeEvent{}).Scopes(services.OfLiveURLs(database.DB)).Where("detected_at >= ?", sevenDaysAgo).Count(&changes7d)
 
 	return c.Render(http.StatusOK, "dashboard.html", echo.Map{
 		"WatchedUrls":      urls,
@@ -171,10 +242,18 @@ func Dashboard(c echo.Context) error {
 		"AvgCheckInterval": fmt.Sprintf("%.1f seconds", averageIntervalSeconds),
 		"ChangesLast24h":   changes24h,
 		"ChangesLast7d":    changes7d,
+		"ExtractTools":     services.AvailableExtractTools(),
 	})
 }
 
-func AddURL(c echo.Context, botToken, chatID string) error {
+// requestedPrivateTarget reports whether an admin ticked the box that lets a
+// URL reach networks blocked by the fetch target policy. The box is ignored
+// for everyone else.
+func requestedPrivateTarget(c echo.Context) bool {
+	return c.FormValue("allow_private_target") == "on" && CurrentUser(c).IsAdmin()
+}
+
+func AddURL(c echo.Context) error {
 	url := c.FormValue("url")
 	intervalStr := c.FormValue("interval")
 
@@ -195,9 +274,19 @@ func AddURL(c echo.Context, botToken, chatID string) error {
 		interval = parsedInterval
 	}
 
+	allowPrivate := requestedPrivateTarget(c)
+	if err := services.CheckTargetURL(url, allowPrivate); err != nil {
+		Flash(c, "This URL can't be watched: "+err.Error())
+		return c.Redirect(http.StatusFound, "/dashboard")
+	}
+
 	var existingURL models.WatchedUrl
-	if result := database.DB.Where("url = ?", url).First(&existingURL); result.Error == nil {
-		Flash(c, "This URL is already being watched.")
+	if result := database.DB.Unscoped().Where("url = ?", url).First(&existingURL); result.Error == nil {
+		if existingURL.DeletedAt.Valid {
+			Flash(c, "This URL is archived. Restore it from the Archive page.")
+		} else {
+			Flash(c, "This URL is already being watched.")
+		}
 		return c.Redirect(http.StatusFound, "/dashboard")
 	} else if result.Error != gorm.ErrRecordNotFound {
 		Flash(c, "Database error checking existing URL: "+result.Error.Error())
@@ -205,9 +294,11 @@ func AddURL(c echo.Context, botToken, chatID string) error {
 	}
 
 	newURL := models.WatchedUrl{
-		URL:             url,
-		IntervalSeconds: interval,
-		Status:          " Scheduled for first check",
+		URL:                url,
+		IntervalSeconds:    interval,
+		Status:             " Scheduled for first check",
+		Beautify:           c.FormValue("beautify") == "on",
+		AllowPrivateTarget: allowPrivate,
 	}
 
 	if result := database.DB.Create(&newURL); result.Error != nil {
@@ -215,9 +306,10 @@ func AddURL(c echo.Context, botToken, chatID string) error {
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
 
+	auditURL(c, services.AuditURLAdd, newURL, fmt.Sprintf("interval %ds", interval))
 	Flash(c, "Started watching "+url+".")
 
-	go services.CheckURLForChanges(newURL.ID, BaseURL, botToken, chatID)
+	services.TriggerCheck(newURL.ID)
 
 	return c.Redirect(http.StatusFound, "/dashboard")
 }
@@ -240,12 +332,13 @@ func RemoveURL(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
 
-	if result := database.DB.Unscoped().Delete(&urlToDelete); result.Error != nil {
-		Flash(c, "Failed to remove URL: "+result.Error.Error())
+	if err := services.ArchiveURL(database.DB, &urlToDelete); err != nil {
+		Flash(c, "Failed to archive URL: "+err.Error())
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
 
-	Flash(c, "Stopped watching "+urlToDelete.URL+".")
+	auditURL(c, services.AuditURLArchive, urlToDelete, "")
+	Flash(c, "Archived "+urlToDelete.URL+". It can be restored from the Archive page.")
 	return c.Redirect(http.StatusFound, "/dashboard")
 }
 
@@ -257,19 +350,19 @@ func ViewDiff(c echo.Context) error {
 	}
 
 	var changeEvent models.ChangeEvent
-	if result := database.DB.First(&changeEvent, eventID); result.Error != nil {
+	if result := database.DB.Preload("SourceFiles", func(db *gorm.DB) *gorm.DB {
+		return db.Order("path")
+	}).First(&changeEvent, eventID); result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			return c.String(http.StatusNotFound, "Change event not found.")
 		}
 		return c.String(http.StatusInternalServerError, "Database error retrieving change event: "+result.Error.Error())
 	}
 
-	if !changeEvent.IsRead {
-		changeEvent.IsRead = true
-		if result := database.DB.Save(&changeEvent); result.Error != nil {
-			log.Printf("Error marking change event %d as read: %v", changeEvent.ID, result.Error)
-		}
+	if _, err := services.MarkRead(database.DB, CurrentUser(c).ID, database.DB.Model(&models.ChangeEvent{}).Where("id = ?", changeEvent.ID)); err != nil {
+		log.Printf("Error marking change event %d as read: %v", changeEvent.ID, err)
 	}
+	changeEvent.IsRead = true
 
 	var watchedURL models.WatchedUrl
 	if result := database.DB.First(&watchedURL, changeEvent.URLID); result.Error != nil {
@@ -298,14 +391,33 @@ func ViewDiff(c echo.Context) error {
 	}
 
 	return c.Render(http.StatusOK, "view_diff.html", echo.Map{
-		"ChangeEvent":  changeEvent,
-		"WatchedURL":   watchedURL,
-		"DiffContent":  template.HTML(changeEvent.DiffText),
-		"PrevChangeID": prevChangeID,
-		"NextChangeID": nextChangeID,
+		"ChangeEvent":      changeEvent,
+		"WatchedURL":       watchedURL,
+		"DiffContent":      template.HTML(changeEvent.DiffText),
+		"SourceDiffs":      sourceDiffs(changeEvent.SourceFiles),
+		"AddedEndpoints":   changeEvent.AddedEndpoints(),
+		"RemovedEndpoints": changeEvent.RemovedEndpoints(),
+		"PrevChangeID":     prevChangeID,
+		"NextChangeID":     nextChangeID,
 	})
 }
 
+// sourceDiff is one changed original file on the diff page.
+type sourceDiff struct {
+	Path   string
+	Status string
+	Diff   template.HTML
+}
+
+func sourceDiffs(changes []models.SourceFileChange) []sourceDiff {
+	diffs := make([]sourceDiff, len(changes))
+	for i, change := range changes {
+		// DiffText is produced by RenderDiff, which escapes the compared content.
+		diffs[i] = sourceDiff{Path: change.Path, Status: change.Status, Diff: template.HTML(change.DiffText)}
+	}
+	return diffs
+}
+
 func EditURLGet(c echo.Context) error {
 	urlIDStr := c.Param("id")
 	urlID, err := strconv.ParseUint(urlIDStr, 10, 32)
@@ -324,13 +436,39 @@ func EditURLGet(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
 
-	return c.Render(http.StatusOK, "edit_url.html", echo.Map{
-		"URL":     urlToEdit,
-		"Flashes": GetFlashes(c),
-	})
+	var group *models.URLGroup
+	if urlToEdit.GroupID != nil {
+		var urlGroup models.URLGroup
+		if result := database.DB.First(&urlGroup, *urlToEdit.GroupID); result.Error == nil {
+			group = &urlGroup
+		}
+	}
+
+	rules, err := services.LoadIgnoreRules(database.DB, urlToEdit)
+	if err != nil {
+		Flash(c, "Database error loading ignore rules: "+err.Error())
+	}
+
+	requestSettings, err := loadRequestSettingsForms(urlToEdit, group)
+	if err != nil {
+		Flash(c, "Error loading request settings: "+err.Error())
+	}
+
+	data := echo.Map{
+		"URL":             urlToEdit,
+		"Group":           group,
+		"IgnoreRules":     rules,
+		"RequestSettings": requestSettings,
+	}
+	if c.QueryParam("preview") != "" {
+		addIgnoreRulePreview(c, data, urlToEdit, rules)
+	}
+	data["Flashes"] = GetFlashes(c)
+
+	return c.Render(http.StatusOK, "edit_url.html", data)
 }
 
-func EditURLPost(c echo.Context, botToken, chatID string) error {
+func EditURLPost(c echo.Context) error {
 	urlIDStr := c.FormValue("id")
 	newURL := c.FormValue("url")
 	newIntervalStr := c.FormValue("interval")
@@ -379,14 +517,50 @@ func EditURLPost(c echo.Context, botToken, chatID string) error {
 		}
 	}
 
+	allowPrivate := existingURL.AllowPrivateTarget
+	if CurrentUser(c).IsAdmin() {
+		allowPrivate = requestedPrivateTarget(c)
+	}
+	if existingURL.URL != newURL || allowPrivate != existingURL.AllowPrivateTarget {
+		if err := services.CheckTargetURL(newURL, allowPrivate); err != nil {
+			Flash(c, "This URL can't be watched: "+err.Error())
+			return c.Redirect(http.StatusFound, fmt.Sprintf("/edit_url/%d", urlID))
+		}
+	}
+
+	// Only the edited columns are written, so a check running at the same
+	// time keeps its result and doesn't overwrite the edit with its own copy.
+	before := existingURL
+	updates := map[string]interface{}{
+		"url":                  newURL,
+		"interval_seconds":     newInterval,
+		"beautify":             c.FormValue("beautify") == "on",
+		"allow_private_target": allowPrivate,
+	}
+	if existingURL.URL != newURL {
+		// A new address should be checked right away, without the old address's validators.
+		existingURL.NextCheckAt = nil
+		existingURL.ETag = ""
+		existingURL.LastModified = ""
+		updates["next_check_at"] = nil
+		updates["e_tag"] = ""
+		updates["last_modified"] = ""
+	} else if existingURL.IntervalSeconds != newInterval && existingURL.LastChecked != nil {
+		nextCheck := existingURL.LastChecked.Add(time.Duration(newInterval) * time.Second)
+		existingURL.NextCheckAt = &nextCheck
+		updates["next_check_at"] = nextCheck
+	}
 	existingURL.URL = newURL
 	existingURL.IntervalSeconds = newInterval
+	existingURL.Beautify = c.FormValue("beautify") == "on"
+	existingURL.AllowPrivateTarget = allowPrivate
 
-	if result := database.DB.Save(&existingURL); result.Error != nil {
+	if result := database.DB.Model(&models.WatchedUrl{}).Where("id = ?", existingURL.ID).Updates(updates); result.Error != nil {
 		Flash(c, "Failed to update URL: "+result.Error.Error())
 		return c.Redirect(http.StatusFound, fmt.Sprintf("/edit_url/%d", urlID))
 	}
 
+	auditURL(c, services.AuditURLEdit, existingURL, describeURLChanges(before, existingURL))
 	Flash(c, "URL updated successfully.")
 	return c.Redirect(http.StatusFound, "/dashboard")
 }
@@ -411,14 +585,20 @@ func ToggleURLActive(c echo.Context) error {
 
 	urlEntry.IsActive = !urlEntry.IsActive
 
-	if result := database.DB.Save(&urlEntry); result.Error != nil {
+	if result := database.DB.Model(&models.WatchedUrl{}).Where("id = ?", urlEntry.ID).Update("is_active", urlEntry.IsActive); result.Error != nil {
 		Flash(c, "Failed to toggle URL status: "+result.Error.Error())
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
 
+	if urlEntry.IsActive {
+		auditURL(c, services.AuditURLToggle, urlEntry, "resumed")
+	} else {
+		auditURL(c, services.AuditURLToggle, urlEntry, "paused")
+	}
+
 	if urlEntry.IsActive {
 		Flash(c, fmt.Sprintf("Started watching %s again.", urlEntry.URL))
-		go services.CheckURLForChanges(urlEntry.ID, BaseURL, os.Getenv("TELEGRAM_BOT_TOKEN"), os.Getenv("TELEGRAM_CHAT_ID"))
+		services.TriggerCheck(urlEntry.ID)
 	} else {
 		Flash(c, fmt.Sprintf("Stopped watching %s.", urlEntry.URL))
 	}
@@ -445,7 +625,9 @@ func AllChangesGet(c echo.Context) error {
 	}
 
 	var changes []models.ChangeEvent
-	if result := database.DB.Where("url_id = ?", urlID).Order("detected_at DESC, id DESC").Find(&changes); result.Error != nil {
+	if result := database.DB.Preload("SourceFiles", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "change_event_id", "path", "status").Order("path")
+	}).Where("url_id = ?", urlID).Order("detected_at DESC, id DESC").Find(&changes); result.Error != nil {
 		Flash(c, "Database error retrieving changes: "+result.Error.Error())
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
@@ -454,13 +636,57 @@ func AllChangesGet(c echo.Context) error {
 	// 	changes[i].DetectedAt = changes[i].DetectedAt.Local()
 	// }
 
+	changePtrs := make([]*models.ChangeEvent, len(changes))
+	for i := range changes {
+		changePtrs[i] = &changes[i]
+	}
+	if err := services.FillReadState(database.DB, CurrentUser(c).ID, changePtrs); err != nil {
+		Flash(c, "Error loading read state: "+err.Error())
+	}
+
 	return c.Render(http.StatusOK, "all_changes.html", echo.Map{
-		"WatchedURL": watchedURL,
+		"WatchedURL": &watchedURL,
 		"Changes":    changes,
 		"Flashes":    GetFlashes(c),
 	})
 }
 
+// MarkRead marks every change as read for the current user, or only those of
+// the URL or group given by the url_id or group_id form field.
+func MarkRead(c echo.Context) error {
+	changes := database.DB.Model(&models.ChangeEvent{})
+	redirectTo := "/dashboard"
+	scope := "all changes"
+
+	if urlIDStr := c.FormValue("url_id"); urlIDStr != "" {
+		urlID, err := strconv.ParseUint(urlIDStr, 10, 32)
+		if err != nil {
+			Flash(c, "Invalid URL ID.")
+			return c.Redirect(http.StatusFound, redirectTo)
+		}
+		changes = changes.Where("url_id = ?", urlID)
+		redirectTo = fmt.Sprintf("/all_changes/%d", urlID)
+		scope = "changes of this URL"
+	} else if groupIDStr := c.FormValue("group_id"); groupIDStr != "" {
+		groupID, err := strconv.ParseUint(groupIDStr, 10, 32)
+		if err != nil {
+			Flash(c, "Invalid group ID.")
+			return c.Redirect(http.StatusFound, redirectTo)
+		}
+		changes = changes.Where("url_id IN (?)", database.DB.Model(&models.WatchedUrl{}).Select("id").Where("group_id = ?", groupID))
+		scope = "changes in this group"
+	}
+
+	marked, err := services.MarkRead(database.DB, CurrentUser(c).ID, changes)
+	if err != nil {
+		Flash(c, "Failed to mark changes as read: "+err.Error())
+		return c.Redirect(http.StatusFound, redirectTo)
+	}
+
+	Flash(c, fmt.Sprintf("Marked %s as read (%d newly read).", scope, marked))
+	return c.Redirect(http.StatusFound, redirectTo)
+}
+
 func ExtractJS(c echo.Context) error {
 	url := c.FormValue("url")
 	tool := c.FormValue("tool")
@@ -469,45 +695,54 @@ func ExtractJS(c echo.Context) error {
 		Flash(c, "URL is required.")
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
-
-	var cmd *exec.Cmd
-	switch tool {
-	case "getJS":
-		cmd = exec.Command("getJS", "-url", url)
-	case "jsxtract":
-		cmd = exec.Command("jsxtract", url)
-	default:
-		Flash(c, "Invalid tool selected.")
+	// The built-in extractor checks every connection it makes again; the
+	// external tools only run when the policy doesn't apply to this target.
+	allowPrivate := requestedPrivateTarget(c)
+	if err := services.CheckTargetURL(url, allowPrivate); err != nil {
+		Flash(c, "Can't extract from this URL: "+err.Error())
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
 
-	output, err := cmd.Output()
+	jsFiles, err := services.ExtractScripts(url, tool, allowPrivate)
 	if err != nil {
-		Flash(c, "Error executing tool: "+err.Error())
+		Flash(c, "Error extracting JS files: "+err.Error())
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
-
-	jsFiles := strings.Split(string(output), "\n")
-	var cleanedJSFiles []string
-	for _, file := range jsFiles {
-		if strings.TrimSpace(file) != "" {
-			cleanedJSFiles = append(cleanedJSFiles, strings.TrimSpace(file))
-		}
+	if len(jsFiles) == 0 {
+		Flash(c, "No JS files found on "+url+".")
+		return c.Redirect(http.StatusFound, "/dashboard")
 	}
 
+	// Extracting the source of an existing group again keeps its schedule.
+	var existing models.URLGroup
+	database.DB.Where("source_url = ?", url).Limit(1).Find(&existing)
+
 	return c.Render(http.StatusOK, "extract_results.html", echo.Map{
-		"SourceURL": url,
-		"GroupName": "Scripts from " + url,
-		"JSFiles":   cleanedJSFiles,
-		"Flashes":   GetFlashes(c),
+		"SourceURL":          url,
+		"GroupName":          "Scripts from " + url,
+		"JSFiles":            jsFiles,
+		"AllowPrivateTarget": allowPrivate,
+		"Tool":               tool,
+		"ReextractHours":     existing.ReextractHours,
+		"AutoWatch":          existing.AutoWatchNewScripts,
+		"MaxHours":           services.MaxReextractHours,
+		"Flashes":            GetFlashes(c),
 	})
 }
 
-func AddExtractedJS(c echo.Context, botToken, chatID string) error {
+func AddExtractedJS(c echo.Context) error {
 	sourceURL := c.FormValue("source_url")
 	groupName := c.FormValue("group_name")
 	jsFiles := c.Request().Form["js_files"]
+	extractedFiles := c.Request().Form["extracted_files"] // Everything the extraction found, selected or not
+	tool := c.FormValue("tool")
+	if tool == services.ExtractToolBuiltin {
+		tool = ""
+	}
 	intervalStr := c.FormValue("interval")
+	beautify := c.FormValue("beautify") == "on"
+	autoWatch := c.FormValue("auto_watch") == "on"
+	allowPrivate := requestedPrivateTarget(c)
 
 	interval, err := strconv.Atoi(intervalStr)
 	if err != nil || interval <= 0 {
@@ -515,22 +750,47 @@ func AddExtractedJS(c echo.Context, botToken, chatID string) error {
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
 
+	reextractHours := 0
+	if hoursStr := c.FormValue("reextract_hours"); hoursStr != "" {
+		reextractHours, err = strconv.Atoi(hoursStr)
+		if err != nil || reextractHours < 0 || reextractHours > services.MaxReextractHours {
+			Flash(c, fmt.Sprintf("Invalid re-extraction interval. Must be between 0 and %d hours.", services.MaxReextractHours))
+			return c.Redirect(http.StatusFound, "/dashboard")
+		}
+	}
+
 	if len(jsFiles) == 0 {
 		Flash(c, "No JS files selected.")
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
 
+	var blocked []string
+	allowedFiles := make([]string, 0, len(jsFiles))
+	for _, jsFile := range jsFiles {
+		if err := services.CheckTargetURL(jsFile, allowPrivate); err != nil {
+			blocked = append(blocked, jsFile)
+			continue
+		}
+		allowedFiles = append(allowedFiles, jsFile)
+	}
+	if len(allowedFiles) == 0 {
+		Flash(c, "None of the selected JS files are allowed by the fetch target policy.")
+		return c.Redirect(http.StatusFound, "/dashboard")
+	}
+	jsFiles = allowedFiles
+
 	var urlGroup models.URLGroup
+	added := 0
 	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		added = 0
 		// Check if a URLGroup with the same sourceURL already exists
-		result := tx.Where("source_url = ?", sourceURL).First(&urlGroup)
-		if result.Error == nil {
+		result := tx.Unscoped().Where("source_url = ?", sourceURL).First(&urlGroup)
+		if result.Error == nil && urlGroup.DeletedAt.Valid {
+			return errors.New("the group for this source URL is archived, restore it from the Archive page first")
+		} else if result.Error == nil {
 			// Group exists, update name if provided
-			if groupName != "" && groupName != urlGroup.Name {
+			if groupName != "" {
 				urlGroup.Name = groupName
-				if result := tx.Save(&urlGroup); result.Error != nil {
-					return result.Error
-				}
 			}
 		} else if result.Error == gorm.ErrRecordNotFound {
 			// Create new group if none exists
@@ -538,13 +798,38 @@ func AddExtractedJS(c echo.Context, botToken, chatID string) error {
 				Name:      groupName,
 				SourceURL: sourceURL,
 			}
-			if result := tx.Create(&urlGroup); result.Error != nil {
-				return result.Error
-			}
 		} else {
 			return result.Error
 		}
 
+		// This extraction is the baseline re-extraction compares against, and
+		// its settings are used for scripts watched automatically later.
+		now := time.Now().UTC()
+		urlGroup.ExtractTool = tool
+		urlGroup.ReextractHours = reextractHours
+		urlGroup.AutoWatchNewScripts = autoWatch
+		urlGroup.NextExtractAt = nil
+		if reextractHours > 0 {
+			next := now.Add(time.Duration(reextractHours) * time.Hour)
+			urlGroup.NextExtractAt = &next
+		}
+		urlGroup.ScriptInterval = interval
+		urlGroup.ScriptBeautify = beautify
+		if CurrentUser(c).IsAdmin() {
+			urlGroup.AllowPrivateTarget = allowPrivate
+		}
+		// Re-extraction fetches the source URL again, so it must pass the same policy.
+		if err := services.CheckTargetURL(urlGroup.SourceURL, urlGroup.AllowPrivateTarget); err != nil {
+			return fmt.Errorf("the source URL can't be re-extracted: %v", err)
+		}
+		if len(extractedFiles) > 0 {
+			urlGroup.Inventory = strings.Join(extractedFiles, "\n")
+			urlGroup.LastExtractedAt = &now
+		}
+		if result := tx.Save(&urlGroup); result.Error != nil {
+			return result.Error
+		}
+
 		// Add new JS files as WatchedUrl entries
 		for _, jsFile := range jsFiles {
 			// Check if the URL is already being watched
@@ -556,14 +841,17 @@ func AddExtractedJS(c echo.Context, botToken, chatID string) error {
 			}
 
 			newURL := models.WatchedUrl{
-				URL:             jsFile,
-				IntervalSeconds: interval,
-				Status:          "Scheduled for first check",
-				GroupID:         &urlGroup.ID,
+				URL:                jsFile,
+				IntervalSeconds:    interval,
+				Status:             "Scheduled for first check",
+				GroupID:            &urlGroup.ID,
+				Beautify:           beautify,
+				AllowPrivateTarget: allowPrivate,
 			}
 			if result := tx.Create(&newURL); result.Error != nil {
 				return result.Error
 			}
+			added++
 		}
 		return nil
 	})
@@ -577,11 +865,16 @@ func AddExtractedJS(c echo.Context, botToken, chatID string) error {
 	for _, jsFile := range jsFiles {
 		var newURL models.WatchedUrl
 		if result := database.DB.Where("url = ? AND group_id = ?", jsFile, urlGroup.ID).First(&newURL); result.Error == nil {
-			go services.CheckURLForChanges(newURL.ID, BaseURL, botToken, chatID)
+			services.TriggerCheck(newURL.ID)
 		}
 	}
 
-	Flash(c, fmt.Sprintf("Added %d JS files to be watched under the group '%s'.", len(jsFiles), groupName))
+	auditGroup(c, services.AuditGroupAdd, urlGroup, fmt.Sprintf("%d of %d selected JS files added from %s", added, len(jsFiles), sourceURL))
+	message := fmt.Sprintf("Added %d JS files to be watched under the group '%s'.", added, urlGroup.Name)
+	if len(blocked) > 0 {
+		message += fmt.Sprintf(" Skipped %d blocked by the fetch target policy: %s", len(blocked), strings.Join(blocked, ", "))
+	}
+	Flash(c, message)
 	return c.Redirect(http.StatusFound, "/dashboard")
 }
 
@@ -603,25 +896,13 @@ func RemoveGroup(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
 
-	err = database.DB.Transaction(func(tx *gorm.DB) error {
-		// Delete all associated WatchedUrl records (and their ChangeEvent records due to CASCADE)
-		if result := tx.Unscoped().Where("group_id = ?", groupID).Delete(&models.WatchedUrl{}); result.Error != nil {
-			return result.Error
-		}
-
-		// Delete the group
-		if result := tx.Unscoped().Delete(&group); result.Error != nil {
-			return result.Error
-		}
-		return nil
-	})
-
-	if err != nil {
-		Flash(c, "Failed to remove group and its URLs: "+err.Error())
+	if err := services.ArchiveGroup(database.DB, &group); err != nil {
+		Flash(c, "Failed to archive group and its URLs: "+err.Error())
 		return c.Redirect(http.StatusFound, "/dashboard")
 	}
 
-	Flash(c, "Group '"+group.Name+"' and all its URLs have been removed.")
+	auditGroup(c, services.AuditGroupArchive, group, "")
+	Flash(c, "Group '"+group.Name+"' and all its URLs have been archived. They can be restored from the Archive page.")
 	return c.Redirect(http.StatusFound, "/dashboard")
 }
 
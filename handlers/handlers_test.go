@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"gorm.io/gorm"
+)
+
+// checkDuringNextUpdate makes the next update of a URL run as if a check
+// saved its result in between the handler loading the row and writing it.
+func checkDuringNextUpdate(t *testing.T, content string) {
+	t.Helper()
+	done := false
+	err := database.DB.Callback().Update().Before("gorm:update").Register("test:concurrent_check", func(tx *gorm.DB) {
+		if done || tx.Statement.Table != "watched_urls" {
+			return
+		}
+		done = true
+		tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE watched_urls SET last_content = ?, status = ?", content, "OK")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestURL(t *testing.T, urlEntry models.WatchedUrl) models.WatchedUrl {
+	t.Helper()
+	if err := database.DB.Create(&urlEntry).Error; err != nil {
+		t.Fatal(err)
+	}
+	return urlEntry
+}
+
+func TestEditURLPostKeepsConcurrentCheckResult(t *testing.T) {
+	useTestDB(t)
+	editor := newTestUser(t, "editor", models.RoleEditor)
+	lastChecked := time.Now().UTC().Add(-time.Minute)
+	urlEntry := newTestURL(t, models.WatchedUrl{URL: "https://example.com/app.js", IntervalSeconds: 300, LastContent: "old", LastChecked: &lastChecked})
+
+	checkDuringNextUpdate(t, "checked")
+	c, rec := newFormContext("/edit_url", url.Values{
+		"id":       {strconv.Itoa(int(urlEntry.ID))},
+		"url":      {urlEntry.URL},
+		"interval": {"60"},
+		"beautify": {"on"},
+	}, editor)
+	if err := EditURLPost(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/dashboard" {
+		t.Fatalf("response %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	var saved models.WatchedUrl
+	database.DB.First(&saved, urlEntry.ID)
+	if saved.LastContent != "checked" || saved.Status != "OK" {
+		t.Errorf("the edit overwrote the check result: content %q, status %q", saved.LastContent, saved.Status)
+	}
+	if saved.IntervalSeconds != 60 || !saved.Beautify {
+		t.Errorf("edit not saved: interval %d, beautify %v", saved.IntervalSeconds, saved.Beautify)
+	}
+	if want := lastChecked.Add(time.Minute); saved.NextCheckAt == nil || !saved.NextCheckAt.Equal(want) {
+		t.Errorf("next check at %v, want %v", saved.NextCheckAt, want)
+	}
+}
+
+func TestToggleURLActiveKeepsConcurrentCheckResult(t *testing.T) {
+	useTestDB(t)
+	editor := newTestUser(t, "editor", models.RoleEditor)
+	urlEntry := newTestURL(t, models.WatchedUrl{URL: "https://example.com/app.js", LastContent: "old"})
+
+	checkDuringNextUpdate(t, "checked")
+	c, _ := newFormContext("/toggle_url_active", url.Values{"id": {strconv.Itoa(int(urlEntry.ID))}}, editor)
+	if err := ToggleURLActive(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var saved models.WatchedUrl
+	database.DB.First(&saved, urlEntry.ID)
+	if saved.IsActive || saved.LastContent != "checked" {
+		t.Errorf("after pausing: active %v, content %q", saved.IsActive, saved.LastContent)
+	}
+}
+
+func TestAddExtractedJSChecksSourceURL(t *testing.T) {
+	useTestDB(t)
+	editor := newTestUser(t, "editor", models.RoleEditor)
+
+	c, rec := newFormContext("/add_extracted_js", url.Values{
+		"source_url": {"http://127.0.0.1/admin"},
+		"group_name": {"Internal"},
+		"js_files":   {"https://93.184.216.34/app.js"},
+		"interval":   {"300"},
+	}, editor)
+	if err := AddExtractedJS(c); err != nil {
+		t.Fatal(err)
+	}
+	if flashes := flashesOf(rec); len(flashes) != 1 || !strings.Contains(flashes[0], "source URL") {
+		t.Errorf("flashes = %q, want the source URL refused", flashes)
+	}
+	var groups, urls int64
+	database.DB.Model(&models.URLGroup{}).Count(&groups)
+	database.DB.Model(&models.WatchedUrl{}).Count(&urls)
+	if groups != 0 || urls != 0 {
+		t.Errorf("stored %d groups and %d URLs for a blocked source URL", groups, urls)
+	}
+}
+
+func TestAddExtractedJSCountsAddedFiles(t *testing.T) {
+	useTestDB(t)
+	editor := newTestUser(t, "editor", models.RoleEditor)
+	newTestURL(t, models.WatchedUrl{URL: "https://93.184.216.34/already-watched.js"})
+
+	c, rec := newFormContext("/add_extracted_js", url.Values{
+		"source_url": {"https://93.184.216.34/"},
+		"group_name": {"Example"},
+		"js_files":   {"https://93.184.216.34/already-watched.js", "https://93.184.216.34/new.js"},
+		"interval":   {"300"},
+	}, editor)
+	if err := AddExtractedJS(c); err != nil {
+		t.Fatal(err)
+	}
+	want := "Added 1 JS files to be watched under the group 'Example'."
+	if flashes := flashesOf(rec); len(flashes) != 1 || flashes[0] != want {
+		t.Errorf("flashes = %q, want %q", flashes, want)
+	}
+}
+
+func TestAddExtractedJSPrivateTargetFollowsAdmin(t *testing.T) {
+	useTestDB(t)
+	admin := newTestUser(t, "admin", models.RoleAdmin)
+	editor := newTestUser(t, "editor", models.RoleEditor)
+	group := models.URLGroup{Name: "Example", SourceURL: "https://93.184.216.34/", AllowPrivateTarget: true}
+	if err := database.DB.Create(&group).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	add := func(user *models.User, allowPrivate string) bool {
+		t.Helper()
+		c, _ := newFormContext("/add_extracted_js", url.Values{
+			"source_url":           {group.SourceURL},
+			"js_files":             {"https://93.184.216.34/app.js"},
+			"interval":             {"300"},
+			"allow_private_target": {allowPrivate},
+		}, user)
+		if err := AddExtractedJS(c); err != nil {
+			t.Fatal(err)
+		}
+		var saved models.URLGroup
+		database.DB.First(&saved, group.ID)
+		return saved.AllowPrivateTarget
+	}
+
+	if !add(editor, "on") {
+		t.Error("an editor's re-add cleared the admin's override")
+	}
+	if add(admin, "") {
+		t.Error("an admin unticking the box didn't clear the override")
+	}
+	if add(editor, "on") {
+		t.Error("an editor set the override")
+	}
+	if !add(admin, "on") {
+		t.Error("an admin ticking the box didn't set the override")
+	}
+}
+
+func TestMarkReadOnlyMarksForCurrentUser(t *testing.T) {
+	useTestDB(t)
+	alice := newTestUser(t, "alice", models.RoleViewer)
+	bob := newTestUser(t, "bob", models.RoleViewer)
+	first := newTestURL(t, models.WatchedUrl{URL: "https://example.com/a.js"})
+	second := newTestURL(t, models.WatchedUrl{URL: "https://example.com/b.js"})
+	database.DB.Create(&models.ChangeEvent{URLID: first.ID})
+	database.DB.Create(&models.ChangeEvent{URLID: second.ID})
+
+	c, rec := newFormContext("/mark_read", url.Values{"url_id": {strconv.Itoa(int(first.ID))}}, alice)
+	if err := MarkRead(c); err != nil {
+		t.Fatal(err)
+	}
+	if flashes := flashesOf(rec); len(flashes) != 1 || flashes[0] != "Marked changes of this URL as read (1 newly read)." {
+		t.Errorf("flashes = %q", flashes)
+	}
+
+	unread := func(user *models.User) []uint {
+		var urlIDs []uint
+		database.DB.Model(&models.ChangeEvent{}).Scopes(services.UnreadBy(user.ID)).Order("url_id").Pluck("url_id", &urlIDs)
+		return urlIDs
+	}
+	if got := unread(alice); len(got) != 1 || got[0] != second.ID {
+		t.Errorf("alice's unread changes are of URLs %v, want [%d]", got, second.ID)
+	}
+	if got := unread(bob); len(got) != 2 {
+		t.Errorf("bob's unread changes are of URLs %v, want both", got)
+	}
+}
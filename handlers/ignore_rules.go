@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// addIgnoreRulePreview renders what the URL's ignore rules, plus an optional
+// candidate rule from the query string, would mask in its latest content.
+func addIgnoreRulePreview(c echo.Context, data echo.Map, urlEntry models.WatchedUrl, rules []models.IgnoreRule) {
+	candidate := models.IgnoreRule{
+		Kind:        c.QueryParam("kind"),
+		Pattern:     c.QueryParam("pattern"),
+		Replacement: c.QueryParam("replacement"),
+	}
+	data["Candidate"] = candidate
+
+	if candidate.Pattern != "" {
+		if err := services.ValidateIgnoreRule(candidate); err != nil {
+			Flash(c, "Cannot preview rule: "+err.Error())
+			return
+		}
+		rules = append(rules, candidate)
+	}
+
+	if urlEntry.LastContent == "" {
+		Flash(c, "Nothing to preview yet, the URL has not been fetched.")
+		return
+	}
+
+	masked := services.ApplyIgnoreRules(urlEntry.LastContent, rules)
+	data["Preview"] = template.HTML(services.RenderDiff(urlEntry.LastContent, masked))
+	data["PreviewUnchanged"] = masked == urlEntry.LastContent
+}
+
+func AddIgnoreRule(c echo.Context) error {
+	urlIDStr := c.FormValue("url_id")
+	urlID, err := strconv.ParseUint(urlIDStr, 10, 32)
+	if err != nil {
+		Flash(c, "Invalid URL ID.")
+		return c.Redirect(http.StatusFound, "/dashboard")
+	}
+	editPage := fmt.Sprintf("/edit_url/%d", urlID)
+
+	var urlEntry models.WatchedUrl
+	if result := database.DB.First(&urlEntry, urlID); result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			Flash(c, "URL not found.")
+		} else {
+			Flash(c, "Database error finding URL: "+result.Error.Error())
+		}
+		return c.Redirect(http.StatusFound, "/dashboard")
+	}
+
+	rule := models.IgnoreRule{
+		Kind:        c.FormValue("kind"),
+		Pattern:     c.FormValue("pattern"),
+		Replacement: c.FormValue("replacement"),
+	}
+	if err := services.ValidateIgnoreRule(rule); err != nil {
+		Flash(c, "Invalid ignore rule: "+err.Error())
+		return c.Redirect(http.StatusFound, editPage)
+	}
+
+	if c.FormValue("scope") == "group" {
+		if urlEntry.GroupID == nil {
+			Flash(c, "This URL does not belong to a group.")
+			return c.Redirect(http.StatusFound, editPage)
+		}
+		rule.GroupID = urlEntry.GroupID
+	} else {
+		rule.URLID = &urlEntry.ID
+	}
+
+	if result := database.DB.Create(&rule); result.Error != nil {
+		Flash(c, "Failed to add ignore rule: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, editPage)
+	}
+
+	auditURL(c, services.AuditIgnoreRuleAdd, urlEntry, fmt.Sprintf("%s rule for the %s: %s", rule.Kind, ruleScope(rule), rule.Pattern))
+	Flash(c, "Ignore rule added.")
+	return c.Redirect(http.StatusFound, editPage)
+}
+
+func RemoveIgnoreRule(c echo.Context) error {
+	ruleIDStr := c.FormValue("id")
+	ruleID, err := strconv.ParseUint(ruleIDStr, 10, 32)
+	if err != nil {
+		Flash(c, "Invalid rule ID.")
+		return c.Redirect(http.StatusFound, "/dashboard")
+	}
+
+	// Return to the page the rule was removed from.
+	redirectTo := "/dashboard"
+	if urlID, err := strconv.ParseUint(c.FormValue("url_id"), 10, 32); err == nil {
+		redirectTo = fmt.Sprintf("/edit_url/%d", urlID)
+	}
+
+	var rule models.IgnoreRule
+	if result := database.DB.First(&rule, ruleID); result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			Flash(c, "Ignore rule not found.")
+		} else {
+			Flash(c, "Database error finding ignore rule: "+result.Error.Error())
+		}
+		return c.Redirect(http.StatusFound, redirectTo)
+	}
+
+	if result := database.DB.Unscoped().Delete(&rule); result.Error != nil {
+		Flash(c, "Failed to remove ignore rule: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, redirectTo)
+	}
+
+	recordAudit(c, models.AuditEvent{
+		Action:     services.AuditIgnoreRuleDelete,
+		TargetType: "ignore_rule",
+		TargetID:   rule.ID,
+		Details:    fmt.Sprintf("%s rule for the %s: %s", rule.Kind, ruleScope(rule), rule.Pattern),
+	})
+	Flash(c, "Ignore rule removed.")
+	return c.Redirect(http.StatusFound, redirectTo)
+}
+
+func ruleScope(rule models.IgnoreRule) string {
+	if rule.GroupID != nil {
+		return fmt.Sprintf("group %d", *rule.GroupID)
+	}
+	if rule.URLID != nil {
+		return fmt.Sprintf("URL %d", *rule.URLID)
+	}
+	return "unknown scope"
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxLoggedUsernameLength keeps junk submitted as a username from bloating the login log.
+const maxLoggedUsernameLength = 100
+
+func newLoginAttempt(c echo.Context, username string) models.LoginAttempt {
+	if len(username) > maxLoggedUsernameLength {
+		username = username[:maxLoggedUsernameLength]
+	}
+	return models.LoginAttempt{Username: username, IP: c.RealIP(), UserAgent: c.Request().UserAgent()}
+}
+
+func recordLogin(c echo.Context, username, result, reason string) {
+	attempt := newLoginAttempt(c, username)
+	attempt.Result = result
+	attempt.Reason = reason
+	services.RecordLoginAttempt(database.DB, attempt)
+}
+
+// beginLogin reserves a login attempt for the username and the client's IP
+// before its credentials are checked; finish it with services.FinishLoginAttempt
+// or services.ReleaseLoginAttempt. It returns nil, with a flash, if recent
+// failures require the attempt to wait. Blocked attempts are logged, but don't
+// count as failures themselves.
+func beginLogin(c echo.Context, username string) *models.LoginAttempt {
+	attempt, wait, err := services.ReserveLoginAttempt(database.DB, newLoginAttempt(c, username))
+	if err != nil {
+		Flash(c, "Login failed: "+err.Error())
+		return nil
+	}
+	if attempt != nil {
+		return attempt
+	}
+	recordLogin(c, username, models.LoginBlocked, "too many failed attempts")
+	// Round up so the message never says "try again in 0s".
+	wait = (wait + time.Second - 1).Truncate(time.Second)
+	Flash(c, fmt.Sprintf("Too many failed login attempts. Try again in %s.", wait))
+	return nil
+}
+
+// LoginAttemptsGet shows the most recent login attempts, optionally filtered
+// by result, username or IP.
+func LoginAttemptsGet(c echo.Context) error {
+	query := database.DB.Model(&models.LoginAttempt{})
+	result := c.QueryParam("result")
+	if result != "" {
+		query = query.Where("result = ?", result)
+	}
+	username := c.QueryParam("username")
+	if username != "" {
+		query = query.Where("username = ?", username)
+	}
+	ip := c.QueryParam("ip")
+	if ip != "" {
+		query = query.Where("ip = ?", ip)
+	}
+
+	var attempts []models.LoginAttempt
+	if res := query.Order("created_at DESC, id DESC").Limit(200).Find(&attempts); res.Error != nil {
+		Flash(c, "Database error loading login attempts: "+res.Error.Error())
+	}
+
+	return c.Render(http.StatusOK, "login_attempts.html", echo.Map{
+		"Attempts":    attempts,
+		"Result":      result,
+		"Username":    username,
+		"IP":          ip,
+		"Results":     []string{models.LoginFailure, models.LoginBlocked, models.LoginSuccess},
+		"MaxFailures": services.LoginMaxFailures,
+		"Lockout":     services.LoginLockout,
+		"AlertAfter":  services.LoginAlertAfter,
+		"Flashes":     GetFlashes(c),
+	})
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMain(m *testing.M) {
+	SetSessionStore([]byte("handlers-test-session-key"), false, http.SameSiteLaxMode)
+	services.SetEncryptionKey([]byte("handlers-test-encryption-key"))
+	os.Exit(m.Run())
+}
+
+// useTestDB points the package-level database at a migrated in-memory
+// database for one test.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	db, err := database.OpenInMemory()
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+func newTestUser(t *testing.T, username, role string) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, PasswordHash: "-", Role: role}
+	if err := database.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// newFormContext builds the context of a form post by user, as it reaches
+// the handler behind AuthMiddleware.
+func newFormContext(target string, form url.Values, user *models.User) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	return newContext(req, user)
+}
+
+// newGetContext builds the context of a page request by user. params are
+// the route's path parameters.
+func newGetContext(target string, user *models.User, params ...string) (echo.Context, *httptest.ResponseRecorder) {
+	c, rec := newContext(httptest.NewRequest(http.MethodGet, target, nil), user)
+	setParams(c, params)
+	return c, rec
+}
+
+// newJSONContext builds the context of an API request by user, as it reaches
+// the handler behind APIAuthMiddleware. params are the route's path parameters.
+func newJSONContext(method, target, body string, user *models.User, params ...string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c, rec := newContext(req, user)
+	setParams(c, params)
+	return c, rec
+}
+
+// setParams sets path parameters given as name, value pairs.
+func setParams(c echo.Context, params []string) {
+	var names, values []string
+	for i := 0; i+1 < len(params); i += 2 {
+		names = append(names, params[i])
+		values = append(values, params[i+1])
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+}
+
+// testRenderer keeps the template and data of the last rendered page.
+type testRenderer struct {
+	name string
+	data echo.Map
+}
+
+func (r *testRenderer) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
+	r.name = name
+	r.data, _ = data.(echo.Map)
+	return nil
+}
+
+// rendered returns the template and data a handler rendered with c.
+func rendered(c echo.Context) (string, echo.Map) {
+	r := c.Echo().Renderer.(*testRenderer)
+	return r.name, r.data
+}
+
+func newContext(req *http.Request, user *models.User) (echo.Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	e := echo.New()
+	e.Renderer = &testRenderer{}
+	c := e.NewContext(req, rec)
+	if user != nil {
+		c.Set(userContextKey, user)
+	}
+	return c, rec
+}
+
+// decodeJSON decodes a JSON response body into v.
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+}
+
+// flashesOf returns the flash messages a response left in the session.
+func flashesOf(rec *httptest.ResponseRecorder) []string {
+	req := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	c, _ := newContext(req, nil)
+	return GetFlashes(c)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// requestSettingsView is a RequestConfig decrypted for the edit form. The auth
+// secret is never sent back to the browser, only whether one is stored.
+type requestSettingsView struct {
+	UserAgent    string
+	Headers      string
+	Cookies      string
+	AuthType     string
+	AuthUsername string
+	HasSecret    bool
+}
+
+// loadRequestSettingsView loads the request settings matching the given
+// column ("url_id" or "group_id"), or empty settings if none are stored.
+func loadRequestSettingsView(column string, id uint) (requestSettingsView, error) {
+	var config models.RequestConfig
+	result := database.DB.Where(column+" = ?", id).First(&config)
+	if result.Error == gorm.ErrRecordNotFound {
+		return requestSettingsView{}, nil
+	} else if result.Error != nil {
+		return requestSettingsView{}, result.Error
+	}
+
+	headers, err := services.DecryptSecret(config.Headers)
+	if err != nil {
+		return requestSettingsView{}, err
+	}
+	cookies, err := services.DecryptSecret(config.Cookies)
+	if err != nil {
+		return requestSettingsView{}, err
+	}
+	return requestSettingsView{
+		UserAgent:    config.UserAgent,
+		Headers:      headers,
+		Cookies:      cookies,
+		AuthType:     config.AuthType,
+		AuthUsername: config.AuthUsername,
+		HasSecret:    config.AuthSecret != "",
+	}, nil
+}
+
+// requestSettingsForm is one request settings form on the edit page, either
+// for the URL itself or for its whole group.
+type requestSettingsForm struct {
+	Scope    string
+	Title    string
+	Settings requestSettingsView
+}
+
+// loadRequestSettingsForms builds the URL form and, for grouped URLs, the group form.
+func loadRequestSettingsForms(urlEntry models.WatchedUrl, group *models.URLGroup) ([]requestSettingsForm, error) {
+	urlSettings, err := loadRequestSettingsView("url_id", urlEntry.ID)
+	if err != nil {
+		return nil, err
+	}
+	forms := []requestSettingsForm{{Scope: "url", Title: "This URL", Settings: urlSettings}}
+
+	if group != nil {
+		groupSettings, err := loadRequestSettingsView("group_id", group.ID)
+		if err != nil {
+			return nil, err
+		}
+		forms = append(forms, requestSettingsForm{
+			Scope:    "group",
+			Title:    fmt.Sprintf("Group \"%s\" (inherited by every URL in the group)", group.Name),
+			Settings: groupSettings,
+		})
+	}
+	return forms, nil
+}
+
+func SaveRequestSettings(c echo.Context) error {
+	urlIDStr := c.FormValue("url_id")
+	urlID, err := strconv.ParseUint(urlIDStr, 10, 32)
+	if err != nil {
+		Flash(c, "Invalid URL ID.")
+		return c.Redirect(http.StatusFound, "/dashboard")
+	}
+	editPage := fmt.Sprintf("/edit_url/%d", urlID)
+
+	var urlEntry models.WatchedUrl
+	if result := database.DB.First(&urlEntry, urlID); result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			Flash(c, "URL not found.")
+		} else {
+			Flash(c, "Database error finding URL: "+result.Error.Error())
+		}
+		return c.Redirect(http.StatusFound, "/dashboard")
+	}
+
+	authType := c.FormValue("auth_type")
+	if authType != services.AuthNone && authType != services.AuthBasic && authType != services.AuthBearer {
+		Flash(c, "Invalid authentication type.")
+		return c.Redirect(http.StatusFound, editPage)
+	}
+
+	var config models.RequestConfig
+	query := database.DB.Where("url_id = ?", urlEntry.ID)
+	if c.FormValue("scope") == "group" {
+		if urlEntry.GroupID == nil {
+			Flash(c, "This URL does not belong to a group.")
+			return c.Redirect(http.StatusFound, editPage)
+		}
+		query = database.DB.Where("group_id = ?", *urlEntry.GroupID)
+		config.GroupID = urlEntry.GroupID
+	} else {
+		config.URLID = &urlEntry.ID
+	}
+	if result := query.First(&config); result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+		Flash(c, "Database error loading request settings: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, editPage)
+	}
+
+	headers, err := services.EncryptSecret(c.FormValue("headers"))
+	if err != nil {
+		Flash(c, "Failed to encrypt headers: "+err.Error())
+		return c.Redirect(http.StatusFound, editPage)
+	}
+	cookies, err := services.EncryptSecret(c.FormValue("cookies"))
+	if err != nil {
+		Flash(c, "Failed to encrypt cookies: "+err.Error())
+		return c.Redirect(http.StatusFound, editPage)
+	}
+
+	config.UserAgent = c.FormValue("user_agent")
+	config.Headers = headers
+	config.Cookies = cookies
+	config.AuthType = authType
+	config.AuthUsername = c.FormValue("auth_username")
+
+	// An empty secret field keeps the stored secret unless it is explicitly cleared.
+	if secret := c.FormValue("auth_secret"); secret != "" {
+		encrypted, err := services.EncryptSecret(secret)
+		if err != nil {
+			Flash(c, "Failed to encrypt credentials: "+err.Error())
+			return c.Redirect(http.StatusFound, editPage)
+		}
+		config.AuthSecret = encrypted
+	} else if c.FormValue("clear_secret") == "on" || authType == services.AuthNone {
+		config.AuthSecret = ""
+	}
+
+	if config.UserAgent == "" && config.Headers == "" && config.Cookies == "" && config.AuthType == services.AuthNone {
+		if config.ID != 0 {
+			if result := database.DB.Unscoped().Delete(&config); result.Error != nil {
+				Flash(c, "Failed to clear request settings: "+result.Error.Error())
+				return c.Redirect(http.StatusFound, editPage)
+			}
+		}
+		auditURL(c, services.AuditRequestSettings, urlEntry, "cleared "+requestSettingsScope(config))
+		Flash(c, "Request settings cleared.")
+		return c.Redirect(http.StatusFound, editPage)
+	}
+
+	if result := database.DB.Save(&config); result.Error != nil {
+		Flash(c, "Failed to save request settings: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, editPage)
+	}
+
+	// Only say what changed in kind, never the header, cookie or credential values.
+	auditURL(c, services.AuditRequestSettings, urlEntry, fmt.Sprintf("saved %s, auth %s", requestSettingsScope(config), config.AuthType))
+	Flash(c, "Request settings saved.")
+	return c.Redirect(http.StatusFound, editPage)
+}
+
+func requestSettingsScope(config models.RequestConfig) string {
+	if config.GroupID != nil {
+		return "group settings"
+	}
+	return "URL settings"
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// snapshotHeader is a single response header shown on the snapshot page.
+type snapshotHeader struct {
+	Name  string
+	Value string
+}
+
+func HistoryGet(c echo.Context) error {
+	urlIDStr := c.Param("url_id")
+	urlID, err := strconv.ParseUint(urlIDStr, 10, 32)
+	if err != nil {
+		Flash(c, "Invalid URL ID.")
+		return c.Redirect(http.StatusFound, "/dashboard")
+	}
+
+	var watchedURL models.WatchedUrl
+	if result := database.DB.First(&watchedURL, urlID); result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			Flash(c, "URL not found.")
+			return c.Redirect(http.StatusFound, "/dashboard")
+		}
+		Flash(c, "Database error finding URL: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/dashboard")
+	}
+
+	// Leave out the content itself, the list only needs the metadata.
+	var snapshots []models.Snapshot
+	if result := database.DB.Select("id", "url_id", "content_hash", "size", "fetched_at").
+		Where("url_id = ?", urlID).Order("fetched_at DESC, id DESC").Find(&snapshots); result.Error != nil {
+		Flash(c, "Database error retrieving history: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/dashboard")
+	}
+
+	return c.Render(http.StatusOK, "history.html", echo.Map{
+		"WatchedURL": watchedURL,
+		"Snapshots":  snapshots,
+		"LastIndex":  len(snapshots) - 1,
+		"Flashes":    GetFlashes(c),
+	})
+}
+
+// loadSnapshot fetches a snapshot by the :id route parameter and writes an
+// error response if it cannot be found.
+func loadSnapshot(c echo.Context) (*models.Snapshot, error) {
+	snapshotID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return nil, c.String(http.StatusBadRequest, "Invalid snapshot ID")
+	}
+
+	var snapshot models.Snapshot
+	if result := database.DB.First(&snapshot, snapshotID); result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, c.String(http.StatusNotFound, "Snapshot not found.")
+		}
+		return nil, c.String(http.StatusInternalServerError, "Database error retrieving snapshot: "+result.Error.Error())
+	}
+	return &snapshot, nil
+}
+
+func SnapshotGet(c echo.Context) error {
+	snapshot, err := loadSnapshot(c)
+	if snapshot == nil {
+		return err
+	}
+
+	var watchedURL models.WatchedUrl
+	if result := database.DB.First(&watchedURL, snapshot.URLID); result.Error != nil {
+		return c.String(http.StatusNotFound, "Associated URL not found for snapshot.")
+	}
+
+	var headers []snapshotHeader
+	if snapshot.Headers != "" {
+		var headerMap http.Header
+		if err := json.Unmarshal([]byte(snapshot.Headers), &headerMap); err == nil {
+			for name, values := range headerMap {
+				headers = append(headers, snapshotHeader{Name: name, Value: strings.Join(values, ", ")})
+			}
+			sort.Slice(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+		}
+	}
+
+	return c.Render(http.StatusOK, "snapshot.html", echo.Map{
+		"Snapshot":   snapshot,
+		"WatchedURL": watchedURL,
+		"Headers":    headers,
+	})
+}
+
+func SnapshotDownload(c echo.Context) error {
+	snapshot, err := loadSnapshot(c)
+	if snapshot == nil {
+		return err
+	}
+
+	var watchedURL models.WatchedUrl
+	database.DB.Select("id", "url").First(&watchedURL, snapshot.URLID)
+
+	filename := path.Base(strings.SplitN(watchedURL.URL, "?", 2)[0])
+	if filename == "" || filename == "." || filename == "/" {
+		filename = "content"
+	}
+	filename = fmt.Sprintf("%d-%s-%s", snapshot.URLID, snapshot.ContentHash[:12], filename)
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	return c.Blob(http.StatusOK, "application/octet-stream", []byte(snapshot.Content))
+}
+
+func CompareGet(c echo.Context) error {
+	urlIDStr := c.Param("url_id")
+	urlID, err := strconv.ParseUint(urlIDStr, 10, 32)
+	if err != nil {
+		Flash(c, "Invalid URL ID.")
+		return c.Redirect(http.StatusFound, "/dashboard")
+	}
+
+	var watchedURL models.WatchedUrl
+	if result := database.DB.First(&watchedURL, urlID); result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			Flash(c, "URL not found.")
+			return c.Redirect(http.StatusFound, "/dashboard")
+		}
+		Flash(c, "Database error finding URL: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/dashboard")
+	}
+
+	var snapshots []models.Snapshot
+	if result := database.DB.Select("id", "url_id", "content_hash", "size", "fetched_at").
+		Where("url_id = ?", urlID).Order("fetched_at ASC, id ASC").Find(&snapshots); result.Error != nil {
+		Flash(c, "Database error retrieving history: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/dashboard")
+	}
+	if len(snapshots) < 2 {
+		Flash(c, "At least two versions are needed to compare.")
+		return c.Redirect(http.StatusFound, fmt.Sprintf("/history/%d", urlID))
+	}
+
+	// Default to the whole recorded history: first-seen against latest.
+	fromID := snapshots[0].ID
+	toID := snapshots[len(snapshots)-1].ID
+	if parsed, err := strconv.ParseUint(c.QueryParam("from"), 10, 32); err == nil {
+		fromID = uint(parsed)
+	}
+	if parsed, err := strconv.ParseUint(c.QueryParam("to"), 10, 32); err == nil {
+		toID = uint(parsed)
+	}
+
+	var fromSnapshot, toSnapshot models.Snapshot
+	if result := database.DB.Where("url_id = ?", urlID).First(&fromSnapshot, fromID); result.Error != nil {
+		Flash(c, "The selected 'from' version does not belong to this URL.")
+		return c.Redirect(http.StatusFound, fmt.Sprintf("/history/%d", urlID))
+	}
+	if result := database.DB.Where("url_id = ?", urlID).First(&toSnapshot, toID); result.Error != nil {
+		Flash(c, "The selected 'to' version does not belong to this URL.")
+		return c.Redirect(http.StatusFound, fmt.Sprintf("/history/%d", urlID))
+	}
+
+	fromContent, toContent := fromSnapshot.Content, toSnapshot.Content
+	if watchedURL.Beautify {
+		contentType := services.ContentTypeFromHeaders(toSnapshot.Headers)
+		fromContent = services.Beautify(fromContent, contentType, watchedURL.URL)
+		toContent = services.Beautify(toContent, contentType, watchedURL.URL)
+	}
+
+	return c.Render(http.StatusOK, "compare.html", echo.Map{
+		"WatchedURL":   watchedURL,
+		"Snapshots":    snapshots,
+		"FromSnapshot": fromSnapshot,
+		"ToSnapshot":   toSnapshot,
+		"DiffContent":  template.HTML(services.RenderDiff(fromContent, toContent)),
+		"Flashes":      GetFlashes(c),
+	})
+}
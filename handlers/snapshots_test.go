@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newTestSnapshot(t *testing.T, urlID uint, content string, fetchedAt time.Time) models.Snapshot {
+	t.Helper()
+	snapshot := models.Snapshot{URLID: urlID, ContentHash: services.ContentHash(content), Size: len(content), Content: content, FetchedAt: fetchedAt}
+	if err := database.DB.Create(&snapshot).Error; err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+func TestCompareGet(t *testing.T) {
+	useTestDB(t)
+	viewer := newTestUser(t, "viewer", models.RoleViewer)
+	urlEntry := newTestURL(t, models.WatchedUrl{URL: "https://example.com/app.js"})
+	other := newTestURL(t, models.WatchedUrl{URL: "https://example.com/other.js"})
+	start := time.Now().UTC().Add(-time.Hour)
+	first := newTestSnapshot(t, urlEntry.ID, "var version = 1;", start)
+	second := newTestSnapshot(t, urlEntry.ID, "var version = 2;", start.Add(time.Minute))
+	latest := newTestSnapshot(t, urlEntry.ID, "var version = 3;", start.Add(2*time.Minute))
+	foreign := newTestSnapshot(t, other.ID, "var other = 1;", start)
+	id := strconv.Itoa(int(urlEntry.ID))
+
+	compare := func(query string) (echo.Map, *httptest.ResponseRecorder) {
+		t.Helper()
+		c, rec := newGetContext("/compare/"+id+query, viewer, "url_id", id)
+		if err := CompareGet(c); err != nil {
+			t.Fatal(err)
+		}
+		_, data := rendered(c)
+		return data, rec
+	}
+
+	data, _ := compare("")
+	if data["FromSnapshot"].(models.Snapshot).ID != first.ID || data["ToSnapshot"].(models.Snapshot).ID != latest.ID {
+		t.Errorf("default comparison is %d to %d, want the first and latest versions", data["FromSnapshot"].(models.Snapshot).ID, data["ToSnapshot"].(models.Snapshot).ID)
+	}
+
+	data, _ = compare(fmt.Sprintf("?from=%d&to=%d", latest.ID, second.ID))
+	if data["FromSnapshot"].(models.Snapshot).ID != latest.ID || data["ToSnapshot"].(models.Snapshot).ID != second.ID {
+		t.Error("the selected versions were not compared")
+	}
+	if diff := fmt.Sprint(data["DiffContent"]); !strings.Contains(diff, "3") || !strings.Contains(diff, "2") {
+		t.Errorf("diff %q doesn't show both versions", diff)
+	}
+
+	data, rec := compare(fmt.Sprintf("?from=%d", foreign.ID))
+	if data != nil || rec.Header().Get("Location") != "/history/"+id {
+		t.Errorf("comparing with another URL's version: %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestCompareGetNeedsTwoVersions(t *testing.T) {
+	useTestDB(t)
+	viewer := newTestUser(t, "viewer", models.RoleViewer)
+	urlEntry := newTestURL(t, models.WatchedUrl{URL: "https://example.com/app.js"})
+	newTestSnapshot(t, urlEntry.ID, "only version", time.Now().UTC())
+	id := strconv.Itoa(int(urlEntry.ID))
+
+	c, rec := newGetContext("/compare/"+id, viewer, "url_id", id)
+	if err := CompareGet(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/history/"+id {
+		t.Errorf("response %d to %q, want a redirect to the history", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Session keys for a login that passed the password check and still needs a TOTP code.
+	pendingUserKey  = "pending_user_id"
+	pendingSinceKey = "pending_since"
+
+	pendingLoginTTL = 5 * time.Minute
+)
+
+// Require2FA makes every user set up two-factor authentication before they
+// can use the dashboard. Admins can also require it for single users.
+var Require2FA bool
+
+// enrollmentPaths stay reachable while a user is made to enroll.
+var enrollmentPaths = map[string]bool{"/account/2fa": true, "/account/2fa/enable": true}
+
+func needsTOTPEnrollment(user *models.User) bool {
+	return !user.TOTPEnabled && (Require2FA || user.TOTPRequired)
+}
+
+// pendingLogin returns the user waiting for the second login step, or nil if
+// there is none or it expired.
+func pendingLogin(c echo.Context) *models.User {
+	session, _ := store.Get(c.Request(), sessionName)
+	userID, ok := session.Values[pendingUserKey].(uint)
+	since, _ := session.Values[pendingSinceKey].(int64)
+	if !ok || time.Since(time.Unix(since, 0)) > pendingLoginTTL {
+		return nil
+	}
+	var user models.User
+	if result := database.DB.First(&user, userID); result.Error != nil || !user.TOTPEnabled {
+		return nil
+	}
+	return &user
+}
+
+func clearPendingLogin(c echo.Context) {
+	session, _ := store.Get(c.Request(), sessionName)
+	delete(session.Values, pendingUserKey)
+	delete(session.Values, pendingSinceKey)
+	session.Save(c.Request(), c.Response())
+}
+
+func LoginTOTPGet(c echo.Context) error {
+	if pendingLogin(c) == nil {
+		clearPendingLogin(c)
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	return c.Render(http.StatusOK, "login_2fa.html", echo.Map{
+		"Flashes": GetFlashes(c),
+	})
+}
+
+func LoginTOTPPost(c echo.Context) error {
+	user := pendingLogin(c)
+	if user == nil {
+		clearPendingLogin(c)
+		Flash(c, "Your login expired. Please log in again.")
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	attempt := beginLogin(c, user.Username)
+	if attempt == nil {
+		return c.Redirect(http.StatusFound, "/login/2fa")
+	}
+
+	// Wrong codes are counted per user on the server; a session cookie could
+	// simply be replayed to start over.
+	allowed, err := services.ReserveSecondFactorAttempt(database.DB, user.ID)
+	if err != nil {
+		services.ReleaseLoginAttempt(database.DB, attempt)
+		Flash(c, "Login failed: "+err.Error())
+		return c.Redirect(http.StatusFound, "/login/2fa")
+	}
+	if !allowed {
+		services.FinishLoginAttempt(database.DB, attempt, models.LoginBlocked, "too many invalid two-factor codes")
+		clearPendingLogin(c)
+		Flash(c, fmt.Sprintf("Too many invalid codes. Try again in %s.", services.LoginLockout))
+		return c.Redirect(http.StatusFound, "/login")
+	}
+
+	code := c.FormValue("code")
+	ok, err := services.VerifyTOTP(database.DB, user, code)
+	usedRecoveryCode := false
+	if err == nil && !ok {
+		ok, err = services.UseRecoveryCode(database.DB, user.ID, code)
+		usedRecoveryCode = ok
+	}
+	if err != nil {
+		services.FinishLoginAttempt(database.DB, attempt, models.LoginFailure, "error: "+err.Error())
+		Flash(c, "Login failed: "+err.Error())
+		return c.Redirect(http.StatusFound, "/login/2fa")
+	}
+
+	session, _ := store.Get(c.Request(), sessionName)
+	if !ok {
+		services.FinishLoginAttempt(database.DB, attempt, models.LoginFailure, "invalid two-factor code")
+		if failures, err := services.SecondFactorFailures(database.DB, user.ID); err != nil || failures >= services.MaxSecondFactorFailures {
+			clearPendingLogin(c)
+			Flash(c, "Too many invalid codes. Please log in again.")
+			return c.Redirect(http.StatusFound, "/login")
+		}
+		Flash(c, "Invalid authentication code.")
+		return c.Redirect(http.StatusFound, "/login/2fa")
+	}
+
+	if err := services.ClearSecondFactorFailures(database.DB, user.ID); err != nil {
+		log.Printf("Error clearing second-factor failures of %s: %v", user.Username, err)
+	}
+	delete(session.Values, pendingUserKey)
+	delete(session.Values, pendingSinceKey)
+	session.Values[sessionKey] = user.ID
+	session.Save(c.Request(), c.Response())
+
+	if usedRecoveryCode {
+		services.FinishLoginAttempt(database.DB, attempt, models.LoginSuccess, "password and recovery code")
+		recordAudit(c, models.AuditEvent{UserID: user.ID, Username: user.Username, Action: services.AuditLogin, Details: "password and recovery code"})
+		remaining, _ := services.CountRecoveryCodes(database.DB, user.ID)
+		Flash(c, fmt.Sprintf("You signed in with a recovery code. %d recovery codes are left; generate new ones from your account page.", remaining))
+	} else {
+		services.FinishLoginAttempt(database.DB, attempt, models.LoginSuccess, "password and two-factor code")
+		recordAudit(c, models.AuditEvent{UserID: user.ID, Username: user.Username, Action: services.AuditLogin, Details: "password and two-factor code"})
+	}
+	return c.Redirect(http.StatusFound, "/dashboard")
+}
+
+// renderTwoFactor shows the 2FA page: the QR code while enrolling, or the
+// status and settings once enabled. Freshly generated recovery codes are
+// passed in so they are shown exactly once.
+func renderTwoFactor(c echo.Context, recoveryCodes []string) error {
+	user := CurrentUser(c)
+	data := echo.Map{
+		"Required":      Require2FA || user.TOTPRequired,
+		"RecoveryCodes": recoveryCodes,
+		"Issuer":        services.TOTPIssuer,
+	}
+
+	if user.TOTPEnabled {
+		remaining, err := services.CountRecoveryCodes(database.DB, user.ID)
+		if err != nil {
+			Flash(c, "Database error counting recovery codes: "+err.Error())
+		}
+		data["RemainingCodes"] = remaining
+	} else {
+		if user.TOTPSecret == "" {
+			if err := services.StartTOTPEnrollment(database.DB, user); err != nil {
+				Flash(c, "Failed to start two-factor setup: "+err.Error())
+			}
+		}
+		if user.TOTPSecret != "" {
+			secret, keyURL, qrCode, err := services.TOTPProvisioning(user)
+			if err != nil {
+				Flash(c, "Failed to load two-factor setup: "+err.Error())
+			} else {
+				data["Secret"] = secret
+				data["KeyURL"] = keyURL
+				data["QRCode"] = template.URL(qrCode) // A data: URI we generated ourselves
+			}
+		}
+	}
+
+	data["Flashes"] = GetFlashes(c)
+	return c.Render(http.StatusOK, "two_factor.html", data)
+}
+
+func TwoFactorGet(c echo.Context) error {
+	return renderTwoFactor(c, nil)
+}
+
+func EnableTOTP(c echo.Context) error {
+	user := CurrentUser(c)
+	if user.TOTPEnabled {
+		Flash(c, "Two-factor authentication is already enabled.")
+		return c.Redirect(http.StatusFound, "/account/2fa")
+	}
+	codes, err := services.ConfirmTOTPEnrollment(database.DB, user, c.FormValue("code"))
+	if err != nil {
+		Flash(c, "Could not enable two-factor authentication: "+err.Error())
+		return c.Redirect(http.StatusFound, "/account/2fa")
+	}
+	user.TOTPEnabled = true
+
+	auditUser(c, services.Audit2FAEnable, *user, "")
+	Flash(c, "Two-factor authentication is now enabled.")
+	return renderTwoFactor(c, codes)
+}
+
+func DisableTOTP(c echo.Context) error {
+	user := CurrentUser(c)
+	if Require2FA || user.TOTPRequired {
+		Flash(c, "Two-factor authentication is required for your account and cannot be disabled.")
+		return c.Redirect(http.StatusFound, "/account/2fa")
+	}
+	if !services.CheckPassword(user, c.FormValue("password")) {
+		Flash(c, "Password is incorrect.")
+		return c.Redirect(http.StatusFound, "/account/2fa")
+	}
+	if err := services.DisableTOTP(database.DB, user.ID); err != nil {
+		Flash(c, "Failed to disable two-factor authentication: "+err.Error())
+		return c.Redirect(http.StatusFound, "/account/2fa")
+	}
+
+	auditUser(c, services.Audit2FADisable, *user, "")
+	Flash(c, "Two-factor authentication has been disabled.")
+	return c.Redirect(http.StatusFound, "/account")
+}
+
+func RegenerateRecoveryCodes(c echo.Context) error {
+	user := CurrentUser(c)
+	if !user.TOTPEnabled {
+		Flash(c, "Enable two-factor authentication first.")
+		return c.Redirect(http.StatusFound, "/account/2fa")
+	}
+	if !services.CheckPassword(user, c.FormValue("password")) {
+		Flash(c, "Password is incorrect.")
+		return c.Redirect(http.StatusFound, "/account/2fa")
+	}
+	codes, err := services.GenerateRecoveryCodes(database.DB, user.ID)
+	if err != nil {
+		Flash(c, "Failed to generate recovery codes: "+err.Error())
+		return c.Redirect(http.StatusFound, "/account/2fa")
+	}
+
+	auditUser(c, services.AuditRecoveryCodes, *user, "")
+	Flash(c, "New recovery codes generated. The old ones no longer work.")
+	return renderTwoFactor(c, codes)
+}
+
+// UpdateUserTOTPRequired lets an admin require two-factor authentication for a user.
+func UpdateUserTOTPRequired(c echo.Context) error {
+	user, err := loadUserFromForm(c)
+	if user == nil {
+		return err
+	}
+	required := c.FormValue("required") == "on"
+	if result := database.DB.Model(user).Update("totp_required", required); result.Error != nil {
+		Flash(c, "Failed to update two-factor requirement: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/users")
+	}
+
+	auditUser(c, services.AuditUser2FARequire, *user, fmt.Sprintf("required %t", required))
+	if required {
+		Flash(c, user.Username+" must now use two-factor authentication.")
+	} else {
+		Flash(c, user.Username+" no longer has to use two-factor authentication.")
+	}
+	return c.Redirect(http.StatusFound, "/users")
+}
+
+// ResetUserTOTP removes a user's authenticator and recovery codes, e.g. after
+// they lost their device. If 2FA is required they enroll again on next login.
+func ResetUserTOTP(c echo.Context) error {
+	user, err := loadUserFromForm(c)
+	if user == nil {
+		return err
+	}
+	if err := services.DisableTOTP(database.DB, user.ID); err != nil {
+		Flash(c, "Failed to reset two-factor authentication: "+err.Error())
+		return c.Redirect(http.StatusFound, "/users")
+	}
+
+	auditUser(c, services.AuditUser2FAReset, *user, "")
+	Flash(c, "Two-factor authentication for "+user.Username+" has been reset.")
+	return c.Redirect(http.StatusFound, "/users")
+}
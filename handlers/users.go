@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const userContextKey = "user"
+
+// CurrentUser returns the user of the current session or API token. It is
+// only nil on routes outside AuthMiddleware and APIAuthMiddleware.
+func CurrentUser(c echo.Context) *models.User {
+	user, _ := c.Get(userContextKey).(*models.User)
+	return user
+}
+
+// RequireRole allows a route only for users with at least the given role.
+// Browsers are sent back to the dashboard; API clients get a JSON 403.
+func RequireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if user := CurrentUser(c); user != nil && user.HasRole(role) {
+				return next(c)
+			}
+			if strings.HasPrefix(c.Request().URL.Path, "/api/") {
+				return apiError(c, http.StatusForbidden, "This action requires the "+role+" role.")
+			}
+			Flash(c, "You do not have permission to do that. It requires the "+role+" role.")
+			return c.Redirect(http.StatusFound, "/dashboard")
+		}
+	}
+}
+
+func validRole(role string) bool {
+	for _, r := range models.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// countOtherAdmins counts admins other than the given user, so the last admin
+// can't be demoted or removed.
+func countOtherAdmins(userID uint) (int64, error) {
+	var count int64
+	result := database.DB.Model(&models.User{}).Where("role = ? AND id != ?", models.RoleAdmin, userID).Count(&count)
+	return count, result.Error
+}
+
+func UsersGet(c echo.Context) error {
+	var users []models.User
+	if result := database.DB.Order("username ASC").Find(&users); result.Error != nil {
+		Flash(c, "Database error loading users: "+result.Error.Error())
+	}
+	return c.Render(http.StatusOK, "users.html", echo.Map{
+		"Users":             users,
+		"Roles":             models.Roles,
+		"MinPasswordLength": services.MinPasswordLength,
+		"Require2FA":        Require2FA,
+		"Flashes":           GetFlashes(c),
+	})
+}
+
+func CreateUser(c echo.Context) error {
+	username := strings.TrimSpace(c.FormValue("username"))
+	role := c.FormValue("role")
+
+	if username == "" {
+		Flash(c, "Username is required.")
+		return c.Redirect(http.StatusFound, "/users")
+	}
+	if !validRole(role) {
+		Flash(c, "Invalid role.")
+		return c.Redirect(http.StatusFound, "/users")
+	}
+	hash, err := services.HashPassword(c.FormValue("password"))
+	if err != nil {
+		Flash(c, "Invalid password: "+err.Error())
+		return c.Redirect(http.StatusFound, "/users")
+	}
+
+	var count int64
+	if result := database.DB.Unscoped().Model(&models.User{}).Where("username = ?", username).Count(&count); result.Error != nil {
+		Flash(c, "Database error checking existing user: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/users")
+	} else if count > 0 {
+		Flash(c, "A user named "+username+" already exists.")
+		return c.Redirect(http.StatusFound, "/users")
+	}
+
+	user := models.User{Username: username, PasswordHash: hash, Role: role}
+	if result := database.DB.Create(&user); result.Error != nil {
+		Flash(c, "Failed to create user: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/users")
+	}
+
+	auditUser(c, services.AuditUserCreate, user, "role "+role)
+	Flash(c, "Created "+role+" "+username+".")
+	return c.Redirect(http.StatusFound, "/users")
+}
+
+// loadUserFromForm loads the user named by the "id" form field.
+func loadUserFromForm(c echo.Context) (*models.User, error) {
+	userID, err := strconv.ParseUint(c.FormValue("id"), 10, 32)
+	if err != nil {
+		Flash(c, "Invalid user ID.")
+		return nil, c.Redirect(http.StatusFound, "/users")
+	}
+	var user models.User
+	if result := database.DB.First(&user, userID); result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			Flash(c, "User not found.")
+		} else {
+			Flash(c, "Database error finding user: "+result.Error.Error())
+		}
+		return nil, c.Redirect(http.StatusFound, "/users")
+	}
+	return &user, nil
+}
+
+func UpdateUserRole(c echo.Context) error {
+	user, err := loadUserFromForm(c)
+	if user == nil {
+		return err
+	}
+	role := c.FormValue("role")
+	if !validRole(role) {
+		Flash(c, "Invalid role.")
+		return c.Redirect(http.StatusFound, "/users")
+	}
+
+	if user.Role == models.RoleAdmin && role != models.RoleAdmin {
+		if others, err := countOtherAdmins(user.ID); err != nil {
+			Flash(c, "Database error counting admins: "+err.Error())
+			return c.Redirect(http.StatusFound, "/users")
+		} else if others == 0 {
+			Flash(c, "Cannot change the role of the last admin.")
+			return c.Redirect(http.StatusFound, "/users")
+		}
+	}
+
+	oldRole := user.Role
+	if result := database.DB.Model(user).Update("role", role); result.Error != nil {
+		Flash(c, "Failed to update role: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/users")
+	}
+
+	auditUser(c, services.AuditUserRole, *user, oldRole+" -> "+role)
+	Flash(c, user.Username+" is now "+role+".")
+	return c.Redirect(http.StatusFound, "/users")
+}
+
+func ResetUserPassword(c echo.Context) error {
+	user, err := loadUserFromForm(c)
+	if user == nil {
+		return err
+	}
+	hash, err := services.HashPassword(c.FormValue("password"))
+	if err != nil {
+		Flash(c, "Invalid password: "+err.Error())
+		return c.Redirect(http.StatusFound, "/users")
+	}
+	if result := database.DB.Model(user).Update("password_hash", hash); result.Error != nil {
+		Flash(c, "Failed to reset password: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/users")
+	}
+
+	auditUser(c, services.AuditUserPasswordReset, *user, "")
+	Flash(c, "Password for "+user.Username+" has been reset.")
+	return c.Redirect(http.StatusFound, "/users")
+}
+
+func RemoveUser(c echo.Context) error {
+	user, err := loadUserFromForm(c)
+	if user == nil {
+		return err
+	}
+	if user.ID == CurrentUser(c).ID {
+		Flash(c, "You cannot remove your own account.")
+		return c.Redirect(http.StatusFound, "/users")
+	}
+	if user.Role == models.RoleAdmin {
+		if others, err := countOtherAdmins(user.ID); err != nil {
+			Flash(c, "Database error counting admins: "+err.Error())
+			return c.Redirect(http.StatusFound, "/users")
+		} else if others == 0 {
+			Flash(c, "Cannot remove the last admin.")
+			return c.Redirect(http.StatusFound, "/users")
+		}
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// The user's API tokens stop working together with the account.
+		if result := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.APIToken{}); result.Error != nil {
+			return result.Error
+		}
+		if result := tx.Where("user_id = ?", user.ID).Delete(&models.ChangeRead{}); result.Error != nil {
+			return result.Error
+		}
+		return tx.Unscoped().Delete(user).Error
+	})
+	if err != nil {
+		Flash(c, "Failed to remove user: "+err.Error())
+		return c.Redirect(http.StatusFound, "/users")
+	}
+
+	auditUser(c, services.AuditUserDelete, *user, "role "+user.Role)
+	Flash(c, "Removed user "+user.Username+".")
+	return c.Redirect(http.StatusFound, "/users")
+}
+
+func AccountGet(c echo.Context) error {
+	return c.Render(http.StatusOK, "account.html", echo.Map{
+		"MinPasswordLength": services.MinPasswordLength,
+		"Flashes":           GetFlashes(c),
+	})
+}
+
+func ChangePassword(c echo.Context) error {
+	user := CurrentUser(c)
+	if !services.CheckPassword(user, c.FormValue("current_password")) {
+		Flash(c, "Current password is incorrect.")
+		return c.Redirect(http.StatusFound, "/account")
+	}
+	if c.FormValue("new_password") != c.FormValue("confirm_password") {
+		Flash(c, "The new passwords do not match.")
+		return c.Redirect(http.StatusFound, "/account")
+	}
+	hash, err := services.HashPassword(c.FormValue("new_password"))
+	if err != nil {
+		Flash(c, "Invalid password: "+err.Error())
+		return c.Redirect(http.StatusFound, "/account")
+	}
+	if result := database.DB.Model(user).Update("password_hash", hash); result.Error != nil {
+		Flash(c, "Failed to change password: "+result.Error.Error())
+		return c.Redirect(http.StatusFound, "/account")
+	}
+
+	auditUser(c, services.AuditPasswordChange, *user, "")
+	Flash(c, "Your password has been changed.")
+	return c.Redirect(http.StatusFound, "/account")
+}
@@ -5,12 +5,16 @@ import (
 	"html/template"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"go-js-watcher/database"
 	"go-js-watcher/handlers"
+	"go-js-watcher/models"
 	"go-js-watcher/services"
 
 	"github.com/joho/godotenv"
@@ -23,9 +27,31 @@ type Template struct {
 }
 
 func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
+	// Every page can show or hide controls based on the logged-in user's role,
+	// and every form needs the CSRF token.
+	if m, ok := data.(echo.Map); ok {
+		if _, set := m["CurrentUser"]; !set {
+			m["CurrentUser"] = handlers.CurrentUser(c)
+		}
+		m["CSRFToken"] = handlers.CSRFToken(c)
+	}
 	return t.templates.ExecuteTemplate(w, name, data)
 }
 
+// loadTemplates parses the page templates in dir.
+func loadTemplates(dir string) *Template {
+	// Register custom template functions here
+	funcMap := template.FuncMap{
+		"humanReadableTime": humanReadableTime, // Register our new function
+	}
+
+	templatesPath := filepath.Join(dir, "*.html")
+	// Use Funcs() to add our custom functions before parsing templates
+	return &Template{
+		templates: template.Must(template.New("templates").Funcs(funcMap).ParseGlob(templatesPath)),
+	}
+}
+
 // humanReadableTime converts a time.Time to a human-readable string like "2 minutes ago".
 // It handles nil pointers for optional times.
 func humanReadableTime(t *time.Time) string {
@@ -65,6 +91,62 @@ func pluralS(count int) string {
 	return ""
 }
 
+// getEnvInt reads an integer environment variable, falling back to def when it is unset or invalid.
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %d", key, value, def)
+		return def
+	}
+	return parsed
+}
+
+// getEnvBool reads a boolean environment variable ("true", "1", "false", ...), falling back to def.
+func getEnvBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %t", key, value, def)
+		return def
+	}
+	return parsed
+}
+
+// getEnvSameSite reads a SameSite cookie mode ("lax", "strict" or "none"), falling back to def.
+func getEnvSameSite(key string, def http.SameSite) http.SameSite {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv(key)))
+	switch value {
+	case "":
+		return def
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	log.Printf("Invalid value for %s (%q), using default", key, value)
+	return def
+}
+
+// splitList splits a comma-separated environment value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func main() {
 	if os.Getenv("ENVIRONMENT") != "docker" {
 		err := godotenv.Load()
@@ -91,19 +173,96 @@ func main() {
 		log.Println("APP_BASE_URL not set")
 	}
 
-	telegramBotToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-	telegramChatID := os.Getenv("TELEGRAM_CHAT_ID")
+	// --- Notification Backends ---
+	// Every configured backend receives every notification.
+	if botToken, chatID := os.Getenv("TELEGRAM_BOT_TOKEN"), os.Getenv("TELEGRAM_CHAT_ID"); botToken != "" && chatID != "" {
+		services.RegisterNotifier(&services.TelegramNotifier{BotToken: botToken, ChatID: chatID})
+	}
+	if webhookURL := os.Getenv("SLACK_WEBHOOK_URL"); webhookURL != "" {
+		services.RegisterNotifier(&services.SlackNotifier{WebhookURL: webhookURL})
+	}
+	if webhookURL := os.Getenv("DISCORD_WEBHOOK_URL"); webhookURL != "" {
+		services.RegisterNotifier(&services.DiscordNotifier{WebhookURL: webhookURL})
+	}
+	if webhookURL := os.Getenv("WEBHOOK_URL"); webhookURL != "" {
+		services.RegisterNotifier(&services.WebhookNotifier{URL: webhookURL, Secret: os.Getenv("WEBHOOK_SECRET")})
+	}
+	if smtpHost := os.Getenv("SMTP_HOST"); smtpHost != "" {
+		recipients := splitList(os.Getenv("SMTP_TO"))
+		from := os.Getenv("SMTP_FROM")
+		if from == "" || len(recipients) == 0 {
+			log.Fatal("SMTP_HOST is set but SMTP_FROM or SMTP_TO is missing")
+		}
+		services.RegisterNotifier(&services.EmailNotifier{
+			Host:     smtpHost,
+			Port:     getEnvInt("SMTP_PORT", 587),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+			To:       recipients,
+			StartTLS: getEnvBool("SMTP_STARTTLS", true),
+			BaseURL:  baseURL,
+		})
+	}
+
+	if codes := os.Getenv("DOWNTIME_STATUS_CODES"); codes != "" {
+		if err := services.SetDowntimeStatusCodes(codes); err != nil {
+			log.Fatalf("Invalid DOWNTIME_STATUS_CODES: %v", err)
+		}
+	}
+	services.DowntimeEscalateAfter = getEnvInt("DOWNTIME_ESCALATE_AFTER", 0)
+
+	// The fetch target policy keeps checks and extraction away from internal
+	// services. FETCH_BLOCKED_NETWORKS replaces the default ranges; "none" blocks nothing.
+	if schemes := splitList(os.Getenv("FETCH_ALLOWED_SCHEMES")); len(schemes) > 0 {
+		services.AllowedSchemes = schemes
+	}
+	if networks := os.Getenv("FETCH_BLOCKED_NETWORKS"); strings.EqualFold(strings.TrimSpace(networks), "none") {
+		services.BlockedNetworks = nil
+	} else if cidrs := splitList(networks); len(cidrs) > 0 {
+		parsed, err := services.ParseNetworks(cidrs)
+		if err != nil {
+			log.Fatalf("Invalid FETCH_BLOCKED_NETWORKS: %v", err)
+		}
+		services.BlockedNetworks = parsed
+	}
+
+	schedulerWorkers := getEnvInt("SCHEDULER_WORKERS", 8)
+	schedulerJitter := time.Duration(getEnvInt("SCHEDULER_JITTER_SECONDS", 30)) * time.Second
 
-	handlers.AppUsername = appUsername
-	handlers.AppPassword = appPassword
 	handlers.BaseURL = baseURL
-	handlers.SetSessionStore([]byte(flaskSecretKey))
+	handlers.Require2FA = getEnvBool("REQUIRE_2FA", false)
+	if maxFailures := getEnvInt("LOGIN_MAX_FAILURES", 5); maxFailures > 0 {
+		services.LoginMaxFailures = int64(maxFailures)
+	}
+	if lockoutMinutes := getEnvInt("LOGIN_LOCKOUT_MINUTES", 15); lockoutMinutes > 0 {
+		services.LoginLockout = time.Duration(lockoutMinutes) * time.Minute
+	}
+	services.LoginAlertAfter = int64(getEnvInt("LOGIN_ALERT_AFTER", 0))
+	// Set COOKIE_SECURE=true when serving over HTTPS so cookies are never sent in clear text.
+	handlers.SetSessionStore([]byte(flaskSecretKey), getEnvBool("COOKIE_SECURE", false), getEnvSameSite("COOKIE_SAMESITE", http.SameSiteLaxMode))
+	services.SetEncryptionKey([]byte(flaskSecretKey))
 
 	// --- Database Initialization ---
 	database.Init()
 
+	// APP_USERNAME/APP_PASSWORD only create the first admin; after that users are managed in the UI.
+	if err := services.BootstrapAdmin(database.DB, appUsername, appPassword); err != nil {
+		log.Fatalf("Failed to create the initial admin user: %v", err)
+	}
+	if err := services.MigrateLegacyReadState(database.DB); err != nil {
+		log.Fatalf("Failed to migrate read state: %v", err)
+	}
+
 	// --- Web Server Setup (Echo) ---
 	e := echo.New()
+	// Login throttling is keyed by client IP. Only trust X-Forwarded-For when a
+	// reverse proxy sets it; otherwise clients could pick any IP they like.
+	if getEnvBool("TRUST_PROXY", false) {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	} else {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
 
 	// e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
@@ -111,63 +270,140 @@ func main() {
 	staticPath := filepath.Join(getExecutableDir(), "static")
 	e.Static("/static", staticPath)
 
-	// Register custom template functions here
-	funcMap := template.FuncMap{
-		"humanReadableTime": humanReadableTime, // Register our new function
-	}
+	e.Renderer = loadTemplates(filepath.Join(getExecutableDir(), "templates"))
 
-	templatesPath := filepath.Join(getExecutableDir(), "templates", "*.html")
-	// Use Funcs() to add our custom functions before parsing templates
-	tmpl := &Template{
-		templates: template.Must(template.New("templates").Funcs(funcMap).ParseGlob(templatesPath)),
+	registerRoutes(e)
+
+	// --- Start Background Scheduler ---
+	services.StartScheduler(baseURL, schedulerWorkers, schedulerJitter)
+
+	// --- Start the Web Server ---
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8090"
+	}
+	host := os.Getenv("HOST")
+	if host == "" {
+		host = "localhost"
 	}
-	e.Renderer = tmpl
+	log.Printf("Starting web server on :%s", port)
+	e.Logger.Fatal(e.Start(host + ":" + port))
+}
+
+// registerRoutes installs the CSRF protection and every route of the web
+// interface, the feeds and the JSON API.
+func registerRoutes(e *echo.Echo) {
+	e.Use(handlers.CSRF())
 
-	// --- Routes ---
 	e.GET("/login", handlers.LoginGet)
 	e.POST("/login", handlers.LoginPost)
+	e.GET("/login/2fa", handlers.LoginTOTPGet)
+	e.POST("/login/2fa", handlers.LoginTOTPPost)
 	e.GET("/logout", handlers.Logout)
 
+	// Feeds are authenticated by the secret token in their URL, not by the session.
+	e.GET("/feeds/:token/atom.xml", handlers.FeedAtom)
+	e.GET("/feeds/:token/rss.xml", handlers.FeedRSS)
+
 	authGroup := e.Group("")
 	authGroup.Use(handlers.AuthMiddleware)
 
+	// Viewers can read everything; changing what is watched needs an editor,
+	// purging archived items and managing users needs an admin.
+	editor := handlers.RequireRole(models.RoleEditor)
+	admin := handlers.RequireRole(models.RoleAdmin)
+
 	authGroup.GET("/", handlers.Dashboard)
 	authGroup.GET("/dashboard", handlers.Dashboard)
-	authGroup.POST("/add_url", func(c echo.Context) error {
-		return handlers.AddURL(c, telegramBotToken, telegramChatID)
-	})
-	authGroup.POST("/remove_url", handlers.RemoveURL)
+	authGroup.POST("/add_url", handlers.AddURL, editor)
+	authGroup.POST("/remove_url", handlers.RemoveURL, editor)
 	authGroup.GET("/diff/:event_id", handlers.ViewDiff)
+	authGroup.POST("/mark_read", handlers.MarkRead)
+
+	authGroup.GET("/edit_url/:id", handlers.EditURLGet, editor)
+	authGroup.POST("/edit_url", handlers.EditURLPost, editor)
 
-	authGroup.GET("/edit_url/:id", handlers.EditURLGet)
-	authGroup.POST("/edit_url", func(c echo.Context) error {
-		return handlers.EditURLPost(c, telegramBotToken, telegramChatID)
-	})
+	authGroup.POST("/request_settings", handlers.SaveRequestSettings, editor)
+	authGroup.POST("/add_ignore_rule", handlers.AddIgnoreRule, editor)
+	authGroup.POST("/remove_ignore_rule", handlers.RemoveIgnoreRule, editor)
 
-	authGroup.POST("/toggle_url_active", handlers.ToggleURLActive)
+	authGroup.POST("/toggle_url_active", handlers.ToggleURLActive, editor)
 
 	authGroup.GET("/all_changes/:url_id", handlers.AllChangesGet)
+	authGroup.GET("/history/:url_id", handlers.HistoryGet)
+	authGroup.GET("/compare/:url_id", handlers.CompareGet)
+	authGroup.GET("/snapshot/:id", handlers.SnapshotGet)
+	authGroup.GET("/snapshot/:id/download", handlers.SnapshotDownload)
+	authGroup.GET("/chunks/:url_id", handlers.ChunksGet)
+	authGroup.POST("/find_chunks", handlers.FindChunks, editor)
+	authGroup.POST("/watch_chunks", handlers.WatchChunks, editor)
+	authGroup.GET("/endpoints", handlers.EndpointsGet)
 
-	authGroup.POST("/extract_js", handlers.ExtractJS)
-	authGroup.POST("/add_extracted_js", func(c echo.Context) error {
-		return handlers.AddExtractedJS(c, telegramBotToken, telegramChatID)
-	})
-	authGroup.POST("/remove_group", handlers.RemoveGroup)
+	authGroup.POST("/extract_js", handlers.ExtractJS, editor)
+	authGroup.POST("/add_extracted_js", handlers.AddExtractedJS, editor)
+	authGroup.POST("/remove_group", handlers.RemoveGroup, editor)
+	authGroup.GET("/group/:id", handlers.GroupGet)
+	authGroup.POST("/group_extraction", handlers.SaveGroupExtraction, editor)
+	authGroup.POST("/reextract_group", handlers.ReextractGroup, editor)
 
-	// --- Start Background Scheduler ---
-	services.StartScheduler(baseURL, telegramBotToken, telegramChatID)
+	authGroup.GET("/archive", handlers.ArchiveGet)
+	authGroup.POST("/restore_url", handlers.RestoreURL, editor)
+	authGroup.POST("/purge_url", handlers.PurgeURL, admin)
+	authGroup.POST("/restore_group", handlers.RestoreGroup, editor)
+	authGroup.POST("/purge_group", handlers.PurgeGroup, admin)
 
-	// --- Start the Web Server ---
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8090"
-	}
-	host := os.Getenv("HOST")
-	if host == "" {
-		host = "localhost"
-	}
-	log.Printf("Starting web server on :%s", port)
-	e.Logger.Fatal(e.Start(host + ":" + port))
+	authGroup.GET("/feeds", handlers.FeedsGet)
+	authGroup.POST("/feeds", handlers.CreateFeed, editor)
+	authGroup.POST("/rotate_feed_token", handlers.RotateFeedToken, editor)
+	authGroup.POST("/remove_feed", handlers.RemoveFeed, editor)
+
+	authGroup.GET("/api_tokens", handlers.APITokensGet)
+	authGroup.POST("/api_tokens", handlers.CreateAPIToken)
+	authGroup.POST("/revoke_api_token", handlers.RevokeAPIToken)
+
+	authGroup.GET("/account", handlers.AccountGet)
+	authGroup.POST("/account/password", handlers.ChangePassword)
+	authGroup.GET("/account/2fa", handlers.TwoFactorGet)
+	authGroup.POST("/account/2fa/enable", handlers.EnableTOTP)
+	authGroup.POST("/account/2fa/disable", handlers.DisableTOTP)
+	authGroup.POST("/account/2fa/recovery_codes", handlers.RegenerateRecoveryCodes)
+
+	authGroup.GET("/users", handlers.UsersGet, admin)
+	authGroup.POST("/users", handlers.CreateUser, admin)
+	authGroup.POST("/update_user_role", handlers.UpdateUserRole, admin)
+	authGroup.POST("/reset_user_password", handlers.ResetUserPassword, admin)
+	authGroup.POST("/remove_user", handlers.RemoveUser, admin)
+	authGroup.POST("/update_user_2fa", handlers.UpdateUserTOTPRequired, admin)
+	authGroup.POST("/reset_user_2fa", handlers.ResetUserTOTP, admin)
+	authGroup.GET("/login_attempts", handlers.LoginAttemptsGet, admin)
+	authGroup.GET("/audit", handlers.AuditGet, admin)
+	authGroup.GET("/audit/export.json", handlers.AuditExport, admin)
+
+	// --- JSON API ---
+	e.HTTPErrorHandler = handlers.APIErrorHandler(e.DefaultHTTPErrorHandler)
+	api := e.Group("/api/v1")
+	api.Use(handlers.APIAuthMiddleware)
+
+	api.GET("/urls", handlers.APIListURLs)
+	api.POST("/urls", handlers.APICreateURL, editor)
+	api.GET("/urls/:id", handlers.APIGetURL)
+	api.PATCH("/urls/:id", handlers.APIUpdateURL, editor)
+	api.DELETE("/urls/:id", handlers.APIDeleteURL, editor)
+	api.POST("/urls/:id/check", handlers.APICheckURL, editor)
+
+	api.GET("/groups", handlers.APIListGroups)
+	api.POST("/groups", handlers.APICreateGroup, editor)
+	api.GET("/groups/:id", handlers.APIGetGroup)
+	api.PATCH("/groups/:id", handlers.APIUpdateGroup, editor)
+	api.DELETE("/groups/:id", handlers.APIDeleteGroup, editor)
+
+	api.GET("/changes", handlers.APIListChanges)
+	api.POST("/changes/mark_read", handlers.APIMarkChangesRead)
+	api.GET("/changes/:id", handlers.APIGetChange)
+	api.PATCH("/changes/:id", handlers.APIUpdateChange)
+	api.DELETE("/changes/:id", handlers.APIDeleteChange, admin)
+
+	api.GET("/endpoints", handlers.APIListEndpoints)
 }
 
 func getExecutableDir() string {
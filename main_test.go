@@ -0,0 +1,320 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/handlers"
+	"go-js-watcher/models"
+	"go-js-watcher/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newTestServer serves every route against a fresh in-memory database.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	db, err := database.OpenInMemory()
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = previous })
+
+	handlers.SetSessionStore([]byte("main-test-session-key"), false, http.SameSiteLaxMode)
+	e := echo.New()
+	e.Renderer = loadTemplates("templates")
+	registerRoutes(e)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testClient is a browser session: it keeps cookies and doesn't follow redirects.
+type testClient struct {
+	t         *testing.T
+	server    *httptest.Server
+	client    *http.Client
+	csrfToken string
+}
+
+var csrfField = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// newTestUser creates a user with the given role and password "password".
+func newTestUser(t *testing.T, username, role string) *models.User {
+	t.Helper()
+	hash, err := services.HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: username, PasswordHash: hash, Role: role}
+	if err := database.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// newTestClient opens the login page, which sets the CSRF cookie, and logs in
+// as username unless it is empty.
+func newTestClient(t *testing.T, server *httptest.Server, username string) *testClient {
+	t.Helper()
+	jar, _ := cookiejar.New(nil)
+	tc := &testClient{t: t, server: server, client: &http.Client{
+		Jar:           jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}}
+	resp := tc.get("/login")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	match := csrfField.FindSubmatch(body)
+	if match == nil {
+		t.Fatalf("no CSRF token on the login page:\n%s", body)
+	}
+	tc.csrfToken = string(match[1])
+
+	if username != "" {
+		resp := tc.post("/login", url.Values{"username": {username}, "password": {"password"}})
+		resp.Body.Close()
+		if location := resp.Header.Get("Location"); location != "/dashboard" {
+			t.Fatalf("logging in as %s redirected to %q", username, location)
+		}
+	}
+	return tc
+}
+
+func (tc *testClient) get(path string) *http.Response {
+	tc.t.Helper()
+	resp, err := tc.client.Get(tc.server.URL + path)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	return resp
+}
+
+// post submits a form with the session's CSRF token, unless form has its own.
+func (tc *testClient) post(path string, form url.Values) *http.Response {
+	tc.t.Helper()
+	values := url.Values{"csrf_token": {tc.csrfToken}}
+	for key, value := range form {
+		values[key] = value
+	}
+	resp, err := tc.client.PostForm(tc.server.URL+path, values)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	return resp
+}
+
+// flashes renders a page, which shows and clears the flash messages waiting
+// in the session.
+func (tc *testClient) flashes() string {
+	tc.t.Helper()
+	resp := tc.get("/archive")
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestPurgeNeedsAdmin(t *testing.T) {
+	server := newTestServer(t)
+	for _, role := range models.Roles {
+		newTestUser(t, role, role)
+	}
+
+	for _, role := range models.Roles {
+		t.Run(role, func(t *testing.T) {
+			urlEntry := models.WatchedUrl{URL: "https://example.com/" + role + ".js"}
+			group := models.URLGroup{Name: role, SourceURL: "https://example.com/" + role}
+			database.DB.Create(&urlEntry)
+			database.DB.Create(&group)
+			services.ArchiveURL(database.DB, &urlEntry)
+			services.ArchiveGroup(database.DB, &group)
+
+			tc := newTestClient(t, server, role)
+			tc.post("/purge_url", url.Values{"id": {strconv.Itoa(int(urlEntry.ID))}}).Body.Close()
+			tc.post("/purge_group", url.Values{"group_id": {strconv.Itoa(int(group.ID))}}).Body.Close()
+
+			var urls, groups int64
+			database.DB.Unscoped().Model(&models.WatchedUrl{}).Where("id = ?", urlEntry.ID).Count(&urls)
+			database.DB.Unscoped().Model(&models.URLGroup{}).Where("id = ?", group.ID).Count(&groups)
+			admin := role == models.RoleAdmin
+			if (urls == 0) != admin {
+				t.Errorf("%s purged the URL: %v", role, urls == 0)
+			}
+			if (groups == 0) != admin {
+				t.Errorf("%s purged the group: %v", role, groups == 0)
+			}
+		})
+	}
+}
+
+func TestRoleGating(t *testing.T) {
+	server := newTestServer(t)
+	for _, role := range models.Roles {
+		newTestUser(t, role, role)
+	}
+
+	tests := []struct {
+		path string
+		form url.Values
+		role string // The least privileged role allowed
+	}{
+		{"/add_url", url.Values{"url": {"https://93.184.216.34/app.js"}, "interval": {"300"}}, models.RoleEditor},
+		{"/restore_url", url.Values{"id": {"999"}}, models.RoleEditor},
+		{"/feeds", url.Values{"name": {"feed"}}, models.RoleEditor},
+		{"/users", url.Values{"username": {"new"}, "role": {models.RoleViewer}}, models.RoleAdmin},
+		{"/remove_user", url.Values{"user_id": {"999"}}, models.RoleAdmin},
+	}
+	for _, role := range models.Roles {
+		tc := newTestClient(t, server, role)
+		user := models.User{Role: role}
+		for _, tt := range tests {
+			resp := tc.post(tt.path, tt.form)
+			resp.Body.Close()
+			refused := strings.Contains(tc.flashes(), "It requires the "+tt.role+" role.")
+			if refused == user.HasRole(tt.role) {
+				t.Errorf("%s posting to %s: refused %v", role, tt.path, refused)
+			}
+		}
+	}
+}
+
+func TestLoginRequired(t *testing.T) {
+	server := newTestServer(t)
+	tc := newTestClient(t, server, "")
+	for _, path := range []string{"/dashboard", "/archive", "/users", "/audit"} {
+		resp := tc.get(path)
+		resp.Body.Close()
+		if location := resp.Header.Get("Location"); resp.StatusCode != http.StatusFound || location != "/login" {
+			t.Errorf("GET %s without a session: %d to %q, want a redirect to /login", path, resp.StatusCode, location)
+		}
+	}
+}
+
+func TestCSRFProtection(t *testing.T) {
+	server := newTestServer(t)
+	newTestUser(t, "editor", models.RoleEditor)
+	tc := newTestClient(t, server, "editor")
+
+	const refused = "Your form was missing a valid security token"
+	for _, token := range []string{"", "not-the-token"} {
+		resp := tc.post("/add_url", url.Values{"url": {"https://93.184.216.34/app.js"}, "interval": {"300"}, "csrf_token": {token}})
+		resp.Body.Close()
+		if !strings.Contains(tc.flashes(), refused) {
+			t.Errorf("token %q: not refused", token)
+		}
+	}
+	var count int64
+	database.DB.Model(&models.WatchedUrl{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d URLs added without a valid token", count)
+	}
+
+	resp := tc.post("/add_url", url.Values{"url": {"https://93.184.216.34/app.js"}, "interval": {"300"}})
+	resp.Body.Close()
+	database.DB.Model(&models.WatchedUrl{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d URLs added with the session's token, want 1", count)
+	}
+}
+
+func TestCSRFProtectsLogin(t *testing.T) {
+	server := newTestServer(t)
+	newTestUser(t, "viewer", models.RoleViewer)
+	tc := newTestClient(t, server, "")
+
+	resp := tc.post("/login", url.Values{"username": {"viewer"}, "password": {"password"}, "csrf_token": {"forged"}})
+	resp.Body.Close()
+	if location := resp.Header.Get("Location"); location != "/login" {
+		t.Fatalf("login with a forged token redirected to %q, want /login", location)
+	}
+	resp = tc.get("/dashboard")
+	resp.Body.Close()
+	if location := resp.Header.Get("Location"); location != "/login" {
+		t.Errorf("a login with a forged token opened a session")
+	}
+}
+
+func TestCSRFSkipsAPI(t *testing.T) {
+	server := newTestServer(t)
+	user := newTestUser(t, "editor", models.RoleEditor)
+	token, hash, err := services.GenerateAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.DB.Create(&models.APIToken{Name: "test", TokenHash: hash, UserID: user.ID})
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/v1/urls", strings.NewReader(`{"url": "https://93.184.216.34/app.js"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("API request without a CSRF token: status %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+}
+
+// apiRequest sends an API request with token and returns the response status.
+func apiRequest(t *testing.T, server *httptest.Server, token, method, path, body string) int {
+	t.Helper()
+	req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAPIRoles(t *testing.T) {
+	server := newTestServer(t)
+	tokens := map[string]string{}
+	for _, role := range models.Roles {
+		user := newTestUser(t, role, role)
+		token, hash, err := services.GenerateAPIToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		database.DB.Create(&models.APIToken{Name: role, TokenHash: hash, UserID: user.ID})
+		tokens[role] = token
+	}
+	urlEntry := models.WatchedUrl{URL: "https://example.com/app.js"}
+	database.DB.Create(&urlEntry)
+	urlPath := "/api/v1/urls/" + strconv.Itoa(int(urlEntry.ID))
+
+	for _, role := range models.Roles {
+		user := models.User{Role: role}
+		if status := apiRequest(t, server, tokens[role], http.MethodGet, urlPath, ""); status != http.StatusOK {
+			t.Errorf("%s reading a URL: status %d", role, status)
+		}
+		status := apiRequest(t, server, tokens[role], http.MethodPatch, urlPath, `{"beautify": true}`)
+		if (status == http.StatusOK) != user.CanEdit() {
+			t.Errorf("%s updating a URL: status %d", role, status)
+		}
+
+		change := models.ChangeEvent{URLID: urlEntry.ID, DetectedAt: time.Now().UTC()}
+		database.DB.Create(&change)
+		status = apiRequest(t, server, tokens[role], http.MethodDelete, "/api/v1/changes/"+strconv.Itoa(int(change.ID)), "")
+		if (status == http.StatusNoContent) != user.IsAdmin() {
+			t.Errorf("%s deleting a change: status %d", role, status)
+		}
+	}
+	if status := apiRequest(t, server, "", http.MethodGet, urlPath, ""); status != http.StatusUnauthorized {
+		t.Errorf("request without a token: status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,23 +9,88 @@ import (
 
 // WatchedUrl represents a URL being watched in the database.
 type WatchedUrl struct {
-	gorm.Model             // Provides ID, CreatedAt, UpdatedAt, DeletedAt
-	URL             string `gorm:"unique;not null"`
-	IntervalSeconds int    `gorm:"not null;default:300"`
-	LastContent     string
-	LastChecked     *time.Time    // Use pointer to allow nil for initial state
-	Status          string        `gorm:"default:'Pending'"`
-	IsActive        bool          `gorm:"default:true"`
-	Changes         []ChangeEvent `gorm:"foreignKey:URLID;constraint:OnDelete:CASCADE;"` // One-to-many relationship
-	GroupID         *uint         // Pointer to allow null, for URLs that don't belong to a group
+	gorm.Model                 // Provides ID, CreatedAt, UpdatedAt, DeletedAt
+	URL                 string `gorm:"unique;not null"`
+	IntervalSeconds     int    `gorm:"not null;default:300"`
+	LastContent         string
+	LastChecked         *time.Time // Use pointer to allow nil for initial state
+	ETag                string     // Validators from the last 200 response, sent back on the next check
+	LastModified        string
+	NextCheckAt         *time.Time `gorm:"index"` // When the scheduler should check this URL next; nil means due now
+	Status              string     `gorm:"default:'Pending'"`
+	IsActive            bool       `gorm:"default:true"`
+	IsDown              bool       `gorm:"default:false"` // Set on the first failed check, cleared on recovery
+	DownSince           *time.Time
+	ConsecutiveFailures int            `gorm:"default:0"`
+	DowntimeEscalated   bool           `gorm:"default:false"`                                 // The escalation alert of the current downtime was sent
+	Beautify            bool           `gorm:"default:false"`                                 // Pretty-print JS/CSS/JSON before diffing
+	AllowPrivateTarget  bool           `gorm:"default:false"`                                 // Admin override of the fetch target policy's blocked networks
+	Changes             []ChangeEvent  `gorm:"foreignKey:URLID;constraint:OnDelete:CASCADE;"` // One-to-many relationship
+	Snapshots           []Snapshot     `gorm:"foreignKey:URLID;constraint:OnDelete:CASCADE;"` // Every distinct version of the content
+	IgnoreRules         []IgnoreRule   `gorm:"foreignKey:URLID;constraint:OnDelete:CASCADE;"`
+	RequestConfig       *RequestConfig `gorm:"foreignKey:URLID;constraint:OnDelete:CASCADE;"`
+	GroupID             *uint          // Pointer to allow null, for URLs that don't belong to a group
+	Chunks              string         // Newline-separated chunk URLs found in the content by chunk discovery
+	ChunksFoundAt       *time.Time
+	SourceMapURL        string       // Source map of the last content, from the SourceMap header or a sourceMappingURL comment
+	SourceMapStatus     string       // Outcome of the last attempt to load the source map
+	SourceFiles         []SourceFile `gorm:"foreignKey:URLID;constraint:OnDelete:CASCADE;"` // Original files from the source map
+	Endpoints           []Endpoint   `gorm:"foreignKey:URLID;constraint:OnDelete:CASCADE;"` // Endpoints referenced by the last content
+	EndpointsAnalyzedAt *time.Time   // nil until the first analysis, which sets the baseline
+	ArchivedWithGroup   bool         `gorm:"default:false"` // Archived by archiving its group; restored with it
 }
 
+// ChunkURLs splits the chunk URLs found in the content.
+func (u *WatchedUrl) ChunkURLs() []string { return splitLines(u.Chunks) }
+
 // URLGroup represents a collection of URLs extracted from a single source URL.
 type URLGroup struct {
 	gorm.Model
-	Name      string       `gorm:"not null"`                                        // e.g., "Scripts from example.com"
-	SourceURL string       `gorm:"unique;not null"`                                 // The URL used for extraction
-	URLs      []WatchedUrl `gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE;"` // Add CASCADE constraint
+	Name          string         `gorm:"not null"`                                        // e.g., "Scripts from example.com"
+	SourceURL     string         `gorm:"unique;not null"`                                 // The URL used for extraction
+	URLs          []WatchedUrl   `gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE;"` // Add CASCADE constraint
+	IgnoreRules   []IgnoreRule   `gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE;"`
+	RequestConfig *RequestConfig `gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE;"`
+
+	// Re-extraction: SourceURL is extracted again on a schedule and scripts that
+	// appear or disappear are recorded as InventoryChanges.
+	ReextractHours      int    `gorm:"default:0"` // Hours between re-extractions; 0 turns them off
+	ExtractTool         string // Tool used to re-extract; empty means the built-in extractor
+	AutoWatchNewScripts bool   `gorm:"default:false"` // Watch scripts that appear on re-extraction
+	ScriptInterval      int    `gorm:"default:0"`     // Check interval for auto-watched scripts; 0 copies the group's newest URL
+	ScriptBeautify      bool   `gorm:"default:false"` // Beautify setting for auto-watched scripts
+	AllowPrivateTarget  bool   `gorm:"default:false"` // Admin override of the fetch target policy for the source and new scripts
+	Inventory           string // Newline-separated script URLs found by the last extraction
+	LastExtractedAt     *time.Time
+	NextExtractAt       *time.Time `gorm:"index"` // nil means due now, if re-extraction is on
+	ExtractStatus       string
+	AutoWatchChunks     bool `gorm:"default:false"` // Watch the lazy-loaded chunks found in the group's bundles, following hash changes
+}
+
+// InventoryChange records scripts that appeared on or disappeared from a
+// group's source page between two extractions.
+type InventoryChange struct {
+	gorm.Model
+	GroupID    uint      `gorm:"not null;index"`
+	DetectedAt time.Time `gorm:"not null"`
+	Added      string    // Newline-separated script URLs
+	Removed    string    // Newline-separated script URLs
+	Watched    string    // Added scripts that were watched automatically
+}
+
+// AddedURLs, RemovedURLs and WatchedURLs split the newline-separated lists.
+func (c *InventoryChange) AddedURLs() []string   { return splitLines(c.Added) }
+func (c *InventoryChange) RemovedURLs() []string { return splitLines(c.Removed) }
+func (c *InventoryChange) WatchedURLs() []string { return splitLines(c.Watched) }
+
+// InventoryURLs splits the group's last extracted inventory.
+func (g *URLGroup) InventoryURLs() []string { return splitLines(g.Inventory) }
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
 }
 
 // ChangeEvent represents a detected change for a WatchedUrl.
@@ -32,6 +98,212 @@ type ChangeEvent struct {
 	gorm.Model           // Provides ID, CreatedAt, UpdatedAt, DeletedAt
 	DiffText   string    `gorm:"not null"`
 	DetectedAt time.Time `gorm:"not null"`
-	URLID      uint      `gorm:"not null"`      // Foreign key to WatchedUrl
-	IsRead     bool      `gorm:"default:false"` // Tracks if the change has been "read"
+	URLID      uint      `gorm:"not null"` // Foreign key to WatchedUrl
+	IsRead     bool      `gorm:"-"`        // Whether the current user has read it; filled in per request from ChangeRead
+
+	SnapshotID         *uint // The version the content changed to
+	PreviousSnapshotID *uint // The version the content changed from
+
+	SourceFiles []SourceFileChange `gorm:"foreignKey:ChangeEventID;constraint:OnDelete:CASCADE;"` // Original files that changed, if the URL has a source map
+
+	EndpointsAdded   string // Newline-separated endpoints the new version references and the previous didn't
+	EndpointsRemoved string // Newline-separated endpoints only the previous version referenced
+}
+
+// AddedEndpoints and RemovedEndpoints split the newline-separated lists.
+func (e *ChangeEvent) AddedEndpoints() []string   { return splitLines(e.EndpointsAdded) }
+func (e *ChangeEvent) RemovedEndpoints() []string { return splitLines(e.EndpointsRemoved) }
+
+// Endpoint is something a WatchedUrl's content calls or links to: a full URL,
+// a path, a GraphQL operation, or the target of a fetch, axios or XHR call.
+// The set is updated with every new version of the content.
+type Endpoint struct {
+	ID          uint      `gorm:"primarykey"`
+	URLID       uint      `gorm:"not null;uniqueIndex:idx_endpoint_url_value"`
+	Kind        string    `gorm:"not null;index"`                              // "url", "path", "graphql" or "call"
+	Value       string    `gorm:"not null;uniqueIndex:idx_endpoint_url_value"` // e.g. "/api/v1/payments", "mutation CreatePayment", "axios.post /api/v1/refunds"
+	FirstSeenAt time.Time `gorm:"not null"`
+}
+
+// SourceFile is the latest version of an original source file reconstructed
+// from a WatchedUrl's source map. Changes are diffed against it.
+type SourceFile struct {
+	ID      uint   `gorm:"primarykey"`
+	URLID   uint   `gorm:"not null;uniqueIndex:idx_source_file_url_path"`
+	Path    string `gorm:"not null;uniqueIndex:idx_source_file_url_path"` // e.g. "src/api/payments.ts"
+	Content string `gorm:"not null"`
+}
+
+// SourceFileChange is one original source file that was added, removed or
+// modified in a ChangeEvent.
+type SourceFileChange struct {
+	ID            uint   `gorm:"primarykey"`
+	ChangeEventID uint   `gorm:"not null;index"`
+	Path          string `gorm:"not null"`
+	Status        string `gorm:"not null"` // "added", "removed" or "modified"
+	DiffText      string `gorm:"not null"`
+}
+
+// ChangeRead records that a user has read a change event. Read state is kept
+// per user so one teammate opening a diff doesn't hide it from everyone else.
+type ChangeRead struct {
+	ID            uint      `gorm:"primarykey"`
+	UserID        uint      `gorm:"not null;uniqueIndex:idx_change_read_user_change"`
+	ChangeEventID uint      `gorm:"not null;uniqueIndex:idx_change_read_user_change;index"`
+	ReadAt        time.Time `gorm:"not null"`
+}
+
+// Snapshot is one distinct version of a WatchedUrl's content.
+// Snapshots are deduplicated per URL by content hash, so a URL that flips back
+// to an earlier version reuses the existing row.
+type Snapshot struct {
+	gorm.Model
+	URLID       uint      `gorm:"not null;uniqueIndex:idx_snapshot_url_hash"`
+	ContentHash string    `gorm:"not null;uniqueIndex:idx_snapshot_url_hash"` // Hex-encoded SHA-256 of Content
+	Size        int       `gorm:"not null"`
+	Content     string    `gorm:"not null"`
+	Headers     string    // JSON-encoded response headers from the fetch that first saw this version
+	FetchedAt   time.Time `gorm:"not null"` // When this version was first fetched
+}
+
+// IgnoreRule masks volatile content (build timestamps, nonces, cache busters...)
+// before a URL's content is compared and diffed. A rule belongs either to a
+// single WatchedUrl or to a whole URLGroup.
+type IgnoreRule struct {
+	gorm.Model
+	URLID       *uint  `gorm:"index"`    // Set for rules that apply to a single URL
+	GroupID     *uint  `gorm:"index"`    // Set for rules that apply to every URL in a group
+	Kind        string `gorm:"not null"` // "regex" replaces matches, "line" drops matching lines
+	Pattern     string `gorm:"not null"`
+	Replacement string // Only used by "regex" rules
+}
+
+// RequestConfig holds custom request settings for fetching a WatchedUrl, or for
+// every URL of a URLGroup. URL settings take precedence over group settings.
+// Headers, Cookies and AuthSecret are stored encrypted.
+type RequestConfig struct {
+	gorm.Model
+	URLID        *uint `gorm:"uniqueIndex"`
+	GroupID      *uint `gorm:"uniqueIndex"`
+	UserAgent    string
+	Headers      string // Encrypted, one "Name: value" per line
+	Cookies      string // Encrypted, one "name=value" per line
+	AuthType     string // "", "basic" or "bearer"
+	AuthUsername string // Only used by basic auth
+	AuthSecret   string // Encrypted password (basic) or token (bearer)
+}
+
+// APIToken authenticates requests to the JSON API. Only the SHA-256 hash of the
+// token is stored; the token itself is shown once, when it is created.
+type APIToken struct {
+	gorm.Model
+	Name       string `gorm:"not null"`
+	TokenHash  string `gorm:"uniqueIndex;not null"`
+	Prefix     string // First characters of the token, shown to tell tokens apart
+	UserID     uint   `gorm:"index"` // Requests made with the token act as this user
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time // Revoked tokens are kept for reference but no longer authenticate
+}
+
+// Feed is an Atom/RSS feed of detected changes, readable without a session by
+// anyone who knows its secret token. A feed covers either every change, one
+// URLGroup or one WatchedUrl.
+type Feed struct {
+	gorm.Model
+	Scope     string `gorm:"not null"` // "all", "group" or "url"
+	GroupID   *uint  `gorm:"index"`
+	URLID     *uint  `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex;not null"` // SHA-256 of the token, used to look the feed up
+	Token     string `gorm:"not null"`             // Encrypted token, kept so the feed URL can be shown again
+}
+
+// User roles, from least to most privileged.
+const (
+	RoleViewer = "viewer" // Can look at URLs, diffs and history
+	RoleEditor = "editor" // Can also add, change and remove URLs, groups and feeds
+	RoleAdmin  = "admin"  // Can also manage users
+)
+
+// Roles lists the valid roles in order of privilege.
+var Roles = []string{RoleViewer, RoleEditor, RoleAdmin}
+
+// User is an account that can log in to the dashboard.
+type User struct {
+	gorm.Model
+	Username     string `gorm:"uniqueIndex;not null"`
+	PasswordHash string `gorm:"not null"` // bcrypt hash
+	Role         string `gorm:"not null;default:'viewer'"`
+
+	TOTPSecret   string // Encrypted base32 TOTP secret; set while enrolling and while enabled
+	TOTPEnabled  bool   `gorm:"default:false"`
+	TOTPRequired bool   `gorm:"default:false"` // Set by an admin to make this user enroll on their next login
+	TOTPLastStep int64  // Last accepted TOTP time step, so a code can't be used twice
+
+	SecondFactorFailures int        `gorm:"default:0"` // Recent wrong codes at the second login step
+	SecondFactorFailedAt *time.Time // When the last of them was entered
+}
+
+// Login attempt results.
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+	LoginBlocked = "blocked" // Rejected without checking the password because of earlier failures
+)
+
+// LoginAttempt is one password or two-factor login attempt. Recent failures
+// per username and per IP slow down and lock out further attempts.
+type LoginAttempt struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Username  string    `gorm:"index"` // As typed, so attempts on unknown usernames are logged too
+	IP        string    `gorm:"index"`
+	UserAgent string
+	Result    string `gorm:"not null"`
+	Reason    string
+}
+
+// AuditEvent records who did what. Rows are only ever inserted; the app has
+// no way to edit or delete them.
+type AuditEvent struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time `gorm:"index"`
+	UserID     uint      `gorm:"index"`
+	Username   string    // Copied at the time, so entries outlive renamed or removed users
+	Action     string    `gorm:"index;not null"` // e.g. "url.delete", see services.AuditActions
+	TargetType string    `gorm:"index"`          // "url", "group", "user", ...
+	TargetID   uint
+	Target     string // Human-readable target, e.g. the watched URL
+	Details    string
+	IP         string
+	Source     string // "web" or "api"
+}
+
+// RecoveryCode is a single-use code that replaces a TOTP code when the user's
+// authenticator device is lost.
+type RecoveryCode struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"not null;index"`
+	CodeHash  string `gorm:"not null"` // Hex-encoded SHA-256 of the normalized code
+	UsedAt    *time.Time
+}
+
+// HasRole reports whether the user's role is at least as privileged as role.
+func (u *User) HasRole(role string) bool {
+	return roleRank(u.Role) >= roleRank(role)
+}
+
+// CanEdit reports whether the user may change watched URLs, groups and feeds.
+func (u *User) CanEdit() bool { return u.HasRole(RoleEditor) }
+
+// IsAdmin reports whether the user may manage other users.
+func (u *User) IsAdmin() bool { return u.HasRole(RoleAdmin) }
+
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
 }
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"time"
+
+	"go-js-watcher/models"
+
+	"gorm.io/gorm"
+)
+
+// Archiving uses the DeletedAt column of gorm.Model: archived URLs and groups
+// are hidden from every normal query and no longer checked, but keep their
+// change history until they are purged.
+
+// OfLiveURLs limits a change event query to changes of URLs that are not archived.
+func OfLiveURLs(db *gorm.DB) func(*gorm.DB) *gorm.DB {
+	return func(query *gorm.DB) *gorm.DB {
+		return query.Where("change_events.url_id IN (?)", db.Model(&models.WatchedUrl{}).Select("id"))
+	}
+}
+
+// archiveTime is the DeletedAt stamp of every archive path, so archived rows
+// sort and compare the same whichever way they were archived.
+func archiveTime() time.Time { return time.Now().UTC() }
+
+// ArchiveURL archives a single URL.
+func ArchiveURL(db *gorm.DB, urlEntry *models.WatchedUrl) error {
+	return db.Model(&models.WatchedUrl{}).Where("id = ?", urlEntry.ID).
+		Updates(map[string]interface{}{"deleted_at": archiveTime(), "archived_with_group": false}).Error
+}
+
+// RestoreURL brings an archived URL back. Checks resume on the next scheduler tick.
+func RestoreURL(db *gorm.DB, urlID uint) error {
+	return db.Unscoped().Model(&models.WatchedUrl{}).Where("id = ?", urlID).
+		Updates(map[string]interface{}{"deleted_at": nil, "archived_with_group": false}).Error
+}
+
+// ArchiveGroup archives a group together with its URLs. The URLs are flagged
+// as archived with the group, so restoring the group brings back exactly
+// these URLs and not ones that were archived on their own before.
+func ArchiveGroup(db *gorm.DB, group *models.URLGroup) error {
+	now := archiveTime()
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.WatchedUrl{}).Where("group_id = ?", group.ID).
+			Updates(map[string]interface{}{"deleted_at": now, "archived_with_group": true})
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Model(group).Update("deleted_at", now).Error
+	})
+}
+
+// RestoreGroup brings back an archived group and the URLs archived with it.
+func RestoreGroup(db *gorm.DB, group *models.URLGroup) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&models.WatchedUrl{}).
+			Where("group_id = ? AND archived_with_group = ?", group.ID, true).
+			Updates(map[string]interface{}{"deleted_at": nil, "archived_with_group": false})
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Unscoped().Model(group).Update("deleted_at", nil).Error
+	})
+}
+
+// PurgeURLs permanently deletes URLs with their changes, read state,
+// snapshots, source files, endpoints, ignore rules, request settings and feeds.
+func PurgeURLs(db *gorm.DB, urlIDs []uint) error {
+	if len(urlIDs) == 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		changeIDs := tx.Unscoped().Model(&models.ChangeEvent{}).Select("id").Where("url_id IN ?", urlIDs)
+		for _, model := range []interface{}{&models.ChangeRead{}, &models.SourceFileChange{}} {
+			if result := tx.Where("change_event_id IN (?)", changeIDs).Delete(model); result.Error != nil {
+				return result.Error
+			}
+		}
+		for _, model := range []interface{}{&models.ChangeEvent{}, &models.Snapshot{}, &models.SourceFile{}, &models.Endpoint{}, &models.IgnoreRule{}, &models.RequestConfig{}, &models.Feed{}} {
+			if result := tx.Unscoped().Where("url_id IN ?", urlIDs).Delete(model); result.Error != nil {
+				return result.Error
+			}
+		}
+		if result := tx.Unscoped().Where("id IN ?", urlIDs).Delete(&models.WatchedUrl{}); result.Error != nil {
+			return result.Error
+		}
+		return nil
+	})
+}
+
+// PurgeGroup permanently deletes a group and every URL in it, archived or not,
+// along with everything PurgeURLs removes, the group's own settings and its
+// inventory changes.
+func PurgeGroup(db *gorm.DB, group *models.URLGroup) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var urlIDs []uint
+		if result := tx.Unscoped().Model(&models.WatchedUrl{}).Where("group_id = ?", group.ID).Pluck("id", &urlIDs); result.Error != nil {
+			return result.Error
+		}
+		if err := PurgeURLs(tx, urlIDs); err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&models.IgnoreRule{}, &models.RequestConfig{}, &models.Feed{}, &models.InventoryChange{}} {
+			if result := tx.Unscoped().Where("group_id = ?", group.ID).Delete(model); result.Error != nil {
+				return result.Error
+			}
+		}
+		return tx.Unscoped().Delete(group).Error
+	})
+}
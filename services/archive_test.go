@@ -0,0 +1,63 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go-js-watcher/models"
+)
+
+func TestRestoreGroupLeavesSeparatelyArchivedURLs(t *testing.T) {
+	// With local time ahead of UTC, a URL archived before its group used to
+	// carry a later-looking timestamp and came back with the group.
+	local := time.Local
+	time.Local = time.FixedZone("UTC+5", 5*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	db := newTestDB(t)
+	group := models.URLGroup{Name: "Scripts", SourceURL: "https://example.com/"}
+	if err := db.Create(&group).Error; err != nil {
+		t.Fatal(err)
+	}
+	alone := models.WatchedUrl{URL: "https://example.com/alone.js", GroupID: &group.ID}
+	withGroup := models.WatchedUrl{URL: "https://example.com/with-group.js", GroupID: &group.ID}
+	for _, urlEntry := range []*models.WatchedUrl{&alone, &withGroup} {
+		if err := db.Create(urlEntry).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ArchiveURL(db, &alone); err != nil {
+		t.Fatal(err)
+	}
+	if err := ArchiveGroup(db, &group); err != nil {
+		t.Fatal(err)
+	}
+	var archived models.URLGroup
+	if err := db.Unscoped().First(&archived, group.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreGroup(db, &archived); err != nil {
+		t.Fatal(err)
+	}
+
+	var live []string
+	db.Model(&models.WatchedUrl{}).Order("url").Pluck("url", &live)
+	if len(live) != 1 || live[0] != withGroup.URL {
+		t.Errorf("live URLs after restoring the group = %v, want only %s", live, withGroup.URL)
+	}
+	var count int64
+	db.Model(&models.URLGroup{}).Where("id = ?", group.ID).Count(&count)
+	if count != 1 {
+		t.Error("group is still archived")
+	}
+
+	// Restoring the URL on its own afterwards works and clears the flag.
+	if err := RestoreURL(db, alone.ID); err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&models.WatchedUrl{}).Where("archived_with_group = ?", true).Count(&count)
+	if count != 0 {
+		t.Errorf("%d URLs still flagged as archived with their group", count)
+	}
+}
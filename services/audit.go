@@ -0,0 +1,80 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"go-js-watcher/models"
+
+	"gorm.io/gorm"
+)
+
+// Audit actions.
+const (
+	AuditLogin  = "login"
+	AuditLogout = "logout"
+
+	AuditURLAdd     = "url.add"
+	AuditURLEdit    = "url.edit"
+	AuditURLToggle  = "url.toggle"
+	AuditURLArchive = "url.archive"
+	AuditURLRestore = "url.restore"
+	AuditURLPurge   = "url.purge"
+	AuditURLCheck   = "url.check"
+	AuditURLChunks  = "url.watch_chunks"
+
+	AuditGroupAdd     = "group.add"
+	AuditGroupEdit    = "group.edit"
+	AuditGroupArchive = "group.archive"
+	AuditGroupRestore = "group.restore"
+	AuditGroupPurge   = "group.purge"
+	AuditGroupExtract = "group.reextract"
+
+	AuditChangeDelete = "change.delete"
+
+	AuditIgnoreRuleAdd    = "ignore_rule.add"
+	AuditIgnoreRuleDelete = "ignore_rule.delete"
+	AuditRequestSettings  = "request_settings.update"
+
+	AuditFeedCreate = "feed.create"
+	AuditFeedRotate = "feed.rotate"
+	AuditFeedDelete = "feed.delete"
+
+	AuditAPITokenCreate = "api_token.create"
+	AuditAPITokenRevoke = "api_token.revoke"
+
+	AuditUserCreate        = "user.create"
+	AuditUserRole          = "user.role"
+	AuditUserPasswordReset = "user.password_reset"
+	AuditUserDelete        = "user.delete"
+	AuditUser2FARequire    = "user.2fa_require"
+	AuditUser2FAReset      = "user.2fa_reset"
+
+	AuditPasswordChange = "account.password"
+	Audit2FAEnable      = "account.2fa_enable"
+	Audit2FADisable     = "account.2fa_disable"
+	AuditRecoveryCodes  = "account.recovery_codes"
+)
+
+// AuditActions lists every action, for filtering the audit page.
+var AuditActions = []string{
+	AuditLogin, AuditLogout,
+	AuditURLAdd, AuditURLEdit, AuditURLToggle, AuditURLArchive, AuditURLRestore, AuditURLPurge, AuditURLCheck, AuditURLChunks,
+	AuditGroupAdd, AuditGroupEdit, AuditGroupArchive, AuditGroupRestore, AuditGroupPurge, AuditGroupExtract,
+	AuditChangeDelete,
+	AuditIgnoreRuleAdd, AuditIgnoreRuleDelete, AuditRequestSettings,
+	AuditFeedCreate, AuditFeedRotate, AuditFeedDelete,
+	AuditAPITokenCreate, AuditAPITokenRevoke,
+	AuditUserCreate, AuditUserRole, AuditUserPasswordReset, AuditUserDelete, AuditUser2FARequire, AuditUser2FAReset,
+	AuditPasswordChange, Audit2FAEnable, Audit2FADisable, AuditRecoveryCodes,
+}
+
+// RecordAudit appends an entry to the audit log. A failure is logged but
+// doesn't undo the action that was audited.
+func RecordAudit(db *gorm.DB, event models.AuditEvent) {
+	event.ID = 0
+	event.CreatedAt = time.Now().UTC()
+	if result := db.Create(&event); result.Error != nil {
+		log.Printf("Error recording audit event %s by %q: %v", event.Action, event.Username, result.Error)
+	}
+}
@@ -0,0 +1,379 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Content kinds understood by Beautify.
+const (
+	kindJS   = "js"
+	kindCSS  = "css"
+	kindJSON = "json"
+)
+
+// Beautify pretty-prints JavaScript, CSS or JSON so that diffs of minified
+// files show the statements that changed instead of one giant line. The kind of
+// content is taken from the Content-Type header, falling back to the URL's file
+// extension. Unknown content is returned unchanged.
+func Beautify(content, contentType, rawURL string) string {
+	switch detectContentKind(contentType, rawURL) {
+	case kindJSON:
+		var out bytes.Buffer
+		if err := json.Indent(&out, []byte(content), "", "  "); err == nil {
+			return out.String()
+		}
+		// Not valid JSON after all (e.g. JSONP); JS formatting still helps.
+		return beautifyJS(content)
+	case kindJS:
+		return beautifyJS(content)
+	case kindCSS:
+		return beautifyCSS(content)
+	}
+	return content
+}
+
+// ContentTypeFromHeaders extracts the Content-Type from a snapshot's JSON-encoded headers.
+func ContentTypeFromHeaders(headersJSON string) string {
+	if headersJSON == "" {
+		return ""
+	}
+	var headers map[string][]string
+	if err := json.Unmarshal([]byte(headersJSON), &headers); err != nil {
+		return ""
+	}
+	if values := headers["Content-Type"]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func detectContentKind(contentType, rawURL string) string {
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.Contains(contentType, "json"):
+		return kindJSON
+	case strings.Contains(contentType, "javascript"), strings.Contains(contentType, "ecmascript"):
+		return kindJS
+	case strings.Contains(contentType, "text/css"):
+		return kindCSS
+	}
+
+	if parsed, err := url.Parse(rawURL); err == nil {
+		switch strings.ToLower(path.Ext(parsed.Path)) {
+		case ".js", ".mjs", ".cjs", ".jsx":
+			return kindJS
+		case ".css":
+			return kindCSS
+		case ".json", ".map":
+			return kindJSON
+		}
+	}
+	return ""
+}
+
+// jsFormatter is a small, forgiving JavaScript pretty-printer. It does not parse
+// the language; it only tracks enough state (strings, comments, regex literals,
+// template literals and bracket depth) to break lines after statements and
+// braces without touching anything inside literals.
+type jsFormatter struct {
+	src        []rune
+	out        strings.Builder
+	indent     int
+	parenDepth int
+	lastSig    rune   // last significant (non-space) rune written
+	lastWord   string // last identifier or keyword written, for regex detection
+	lineStart  bool
+	space      bool // a space is pending before the next token on this line
+}
+
+func beautifyJS(src string) string {
+	f := &jsFormatter{src: []rune(src), lineStart: true}
+	f.format()
+	return strings.TrimRight(f.out.String(), "\n") + "\n"
+}
+
+func (f *jsFormatter) newline() {
+	f.space = false
+	if f.lineStart {
+		return
+	}
+	f.out.WriteByte('\n')
+	f.lineStart = true
+}
+
+func (f *jsFormatter) write(s string) {
+	if f.lineStart {
+		f.out.WriteString(strings.Repeat("  ", f.indent))
+		f.lineStart = false
+	} else if f.space {
+		f.out.WriteByte(' ')
+	}
+	f.space = false
+	f.out.WriteString(s)
+}
+
+func (f *jsFormatter) peekSignificant(i int) rune {
+	for ; i < len(f.src); i++ {
+		if !isJSSpace(f.src[i]) {
+			return f.src[i]
+		}
+	}
+	return 0
+}
+
+// regexAllowed reports whether a '/' at the current position starts a regular
+// expression literal rather than being a division operator.
+func (f *jsFormatter) regexAllowed() bool {
+	switch f.lastWord {
+	case "return", "typeof", "instanceof", "in", "of", "new", "delete", "void", "throw", "case", "do", "else", "yield", "await":
+		return true
+	}
+	if f.lastSig == 0 {
+		return true
+	}
+	return strings.ContainsRune("(,=:[!&|?{};+-*%<>~^", f.lastSig)
+}
+
+func (f *jsFormatter) format() {
+	src := f.src
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case ch == '\n' || ch == '\r':
+			// Keep the author's line breaks; they may matter for ASI.
+			f.newline()
+		case isJSSpace(ch):
+			f.space = !f.lineStart
+			for i+1 < len(src) && isJSSpace(src[i+1]) && src[i+1] != '\n' && src[i+1] != '\r' {
+				i++
+			}
+		case ch == '/' && i+1 < len(src) && src[i+1] == '/':
+			end := i
+			for end < len(src) && src[end] != '\n' {
+				end++
+			}
+			f.write(string(src[i:end]))
+			f.newline()
+			i = end
+		case ch == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := i + 2
+			for end+1 < len(src) && !(src[end] == '*' && src[end+1] == '/') {
+				end++
+			}
+			end = min(end+2, len(src))
+			f.write(string(src[i:end]))
+			i = end - 1
+		case ch == '"' || ch == '\'':
+			end := scanQuoted(src, i)
+			f.write(string(src[i:end]))
+			f.lastSig, f.lastWord = ch, ""
+			i = end - 1
+		case ch == '`':
+			end := scanTemplate(src, i)
+			f.write(string(src[i:end]))
+			f.lastSig, f.lastWord = ch, ""
+			i = end - 1
+		case ch == '/' && f.regexAllowed():
+			end := scanRegex(src, i)
+			f.write(string(src[i:end]))
+			f.lastSig, f.lastWord = 'r', ""
+			i = end - 1
+		case ch == '{':
+			f.write("{")
+			f.lastSig, f.lastWord = ch, ""
+			if f.peekSignificant(i+1) == '}' {
+				continue
+			}
+			f.indent++
+			f.newline()
+		case ch == '}':
+			if f.lastSig != '{' {
+				f.indent = max(f.indent-1, 0)
+				f.newline()
+			}
+			f.write("}")
+			f.lastSig, f.lastWord = ch, ""
+			if next := f.peekSignificant(i + 1); next != 0 && !strings.ContainsRune(";,)].(", next) {
+				f.newline()
+			}
+		case ch == ';':
+			f.write(";")
+			f.lastSig, f.lastWord = ch, ""
+			if f.parenDepth == 0 {
+				f.newline()
+			}
+		case ch == '(':
+			f.parenDepth++
+			f.write("(")
+			f.lastSig, f.lastWord = ch, ""
+		case ch == ')':
+			f.parenDepth = max(f.parenDepth-1, 0)
+			f.write(")")
+			f.lastSig, f.lastWord = ch, ""
+		case isJSIdentRune(ch):
+			end := i
+			for end < len(src) && isJSIdentRune(src[end]) {
+				end++
+			}
+			word := string(src[i:end])
+			f.write(word)
+			f.lastSig, f.lastWord = 'a', word
+			i = end - 1
+		default:
+			f.write(string(ch))
+			f.lastSig, f.lastWord = ch, ""
+		}
+	}
+}
+
+func isJSSpace(ch rune) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f' || ch == '\v'
+}
+
+func isJSIdentRune(ch rune) bool {
+	return ch == '_' || ch == '$' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch > 127
+}
+
+// scanQuoted returns the index just past the string literal starting at i.
+func scanQuoted(src []rune, i int) int {
+	quote := src[i]
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case quote, '\n':
+			return j + 1
+		}
+	}
+	return len(src)
+}
+
+// scanTemplate returns the index just past the template literal starting at i,
+// skipping over any ${...} substitutions.
+func scanTemplate(src []rune, i int) int {
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '`':
+			return j + 1
+		case '$':
+			if j+1 < len(src) && src[j+1] == '{' {
+				depth := 0
+				for j++; j < len(src); j++ {
+					if src[j] == '{' {
+						depth++
+					} else if src[j] == '}' {
+						depth--
+						if depth == 0 {
+							break
+						}
+					} else if src[j] == '"' || src[j] == '\'' {
+						j = scanQuoted(src, j) - 1
+					} else if src[j] == '`' {
+						j = scanTemplate(src, j) - 1
+					}
+				}
+			}
+		}
+	}
+	return len(src)
+}
+
+// scanRegex returns the index just past the regular expression literal
+// (including flags) starting at i.
+func scanRegex(src []rune, i int) int {
+	inClass := false
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '\n':
+			// Not a regex after all; treat the slash as an operator.
+			return i + 1
+		case '/':
+			if !inClass {
+				j++
+				for j < len(src) && isJSIdentRune(src[j]) {
+					j++
+				}
+				return j
+			}
+		}
+	}
+	return i + 1
+}
+
+// beautifyCSS puts each declaration and rule on its own line.
+func beautifyCSS(src string) string {
+	var out strings.Builder
+	indent := 0
+	lineStart := true
+	space := false
+	runes := []rune(src)
+
+	write := func(s string) {
+		if lineStart {
+			out.WriteString(strings.Repeat("  ", indent))
+			lineStart = false
+		} else if space {
+			out.WriteByte(' ')
+		}
+		space = false
+		out.WriteString(s)
+	}
+	newline := func() {
+		space = false
+		if !lineStart {
+			out.WriteByte('\n')
+			lineStart = true
+		}
+	}
+
+	for i := 0; i < len(runes); i++ {
+		ch := runes[i]
+		switch {
+		case ch == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			end := i + 2
+			for end+1 < len(runes) && !(runes[end] == '*' && runes[end+1] == '/') {
+				end++
+			}
+			end = min(end+2, len(runes))
+			write(string(runes[i:end]))
+			newline()
+			i = end - 1
+		case ch == '"' || ch == '\'':
+			end := scanQuoted(runes, i)
+			write(string(runes[i:end]))
+			i = end - 1
+		case isJSSpace(ch):
+			space = !lineStart
+			for i+1 < len(runes) && isJSSpace(runes[i+1]) {
+				i++
+			}
+		case ch == '{':
+			write("{")
+			indent++
+			newline()
+		case ch == '}':
+			indent = max(indent-1, 0)
+			newline()
+			write("}")
+			newline()
+		case ch == ';':
+			write(";")
+			newline()
+		default:
+			write(string(ch))
+		}
+	}
+	return strings.TrimRight(out.String(), "\n") + "\n"
+}
@@ -0,0 +1,70 @@
+package services
+
+import "testing"
+
+func TestBeautify(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		url         string
+		want        string
+	}{
+		{
+			name:        "js blocks",
+			content:     `function a(b){if(b){return 1}else{return 2}}`,
+			contentType: "application/javascript",
+			want:        "function a(b){\n  if(b){\n    return 1\n  }\n  else{\n    return 2\n  }\n}\n",
+		},
+		{
+			name:    "js strings and regex literals are left alone",
+			content: `var s="a{b;c}";var r=/{;}/g;x=1;`,
+			url:     "https://example.com/app.min.js",
+			want:    "var s=\"a{b;c}\";\nvar r=/{;}/g;\nx=1;\n",
+		},
+		{
+			name:    "js template literals and for loops",
+			content: "const t=`${a}{;}`;for(i=0;i<2;i++){f()}",
+			url:     "https://example.com/a.mjs",
+			want:    "const t=`${a}{;}`;\nfor(i=0;i<2;i++){\n  f()\n}\n",
+		},
+		{
+			name:    "js comments",
+			content: "// c\nx=1;/* k */y=2",
+			url:     "https://example.com/a.js",
+			want:    "// c\nx=1;\n/* k */y=2\n",
+		},
+		{
+			name:        "css",
+			content:     `a{color:red;background:blue}b{margin:0}`,
+			contentType: "text/css; charset=utf-8",
+			want:        "a{\n  color:red;\n  background:blue\n}\nb{\n  margin:0\n}\n",
+		},
+		{
+			name:        "json",
+			content:     `{"a":1,"b":[1,2]}`,
+			contentType: "application/json",
+			want:        "{\n  \"a\": 1,\n  \"b\": [\n    1,\n    2\n  ]\n}",
+		},
+		{
+			name:    "jsonp falls back to js",
+			content: `cb({"a":1})`,
+			url:     "https://example.com/data.json",
+			want:    "cb({\n  \"a\":1\n})\n",
+		},
+		{
+			name:        "unknown content is unchanged",
+			content:     "plain text",
+			contentType: "text/plain",
+			url:         "https://example.com/readme",
+			want:        "plain text",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Beautify(tt.content, tt.contentType, tt.url); got != tt.want {
+				t.Errorf("Beautify() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,627 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go-js-watcher/models"
+
+	"gorm.io/gorm"
+)
+
+// maxChunksPerBundle caps how many chunk URLs are kept for one bundle.
+const maxChunksPerBundle = 1000
+
+var (
+	// The start of webpack's chunk filename function: __webpack_require__.u in
+	// webpack 5 (usually minified to something like r.u), jsonpScriptSrc in webpack 4.
+	webpackChunkFuncPattern = regexp.MustCompile(`(?:[\w$]+\.u\s*=\s*(?:function\s*\(\s*([\w$]+)\s*\)\s*\{\s*return\s*|\(?\s*([\w$]+)\s*\)?\s*=>\s*)|function\s+jsonpScriptSrc\s*\(\s*([\w$]+)\s*\)\s*\{\s*return\s*)`)
+	// webpack's public path, when it is a literal: __webpack_require__.p = "/_next/".
+	webpackPublicPathPattern = regexp.MustCompile(`[\w$]+\.p\s*=\s*["']([^"']*)["']`)
+	// Vite's preload base, e.g. function(e){return"/"+e}.
+	viteAssetsURLPattern = regexp.MustCompile(`function\s*\(\s*([\w$]+)\s*\)\s*\{\s*return\s*["']([^"']*)["']\s*\+\s*([\w$]+)\s*\}`)
+	// Relative ES module imports and re-exports between chunks.
+	bundleImportPattern        = regexp.MustCompile(`\b(?:import|export)\s*(?:[\w*{}\s,$]*?\s*from\s*)?["'](\.{1,2}/[^"'\s]+)["']`)
+	bundleDynamicImportPattern = regexp.MustCompile("\\bimport\\s*\\(\\s*[\"'`](\\.{1,2}/[^\"'`\\s]+)[\"'`]\\s*\\)")
+	jsStringPattern            = regexp.MustCompile(`["']([^"'\s]+\.m?js)["']`)
+
+	// Content hashes in chunk file names: hex hashes (webpack, Next.js) and
+	// 8-character base64url hashes (Vite, Rollup).
+	hexHashPattern  = regexp.MustCompile(`^(.*?)[.-][0-9a-f]{8,32}((?:\.chunk)?\.m?js)$`)
+	viteHashPattern = regexp.MustCompile(`^(.*)-([A-Za-z0-9_-]{8})(\.m?js)$`)
+)
+
+// FindChunks reconstructs the URLs of the chunks a bundle can load lazily:
+// webpack chunk filename functions with their chunk-id-to-hash maps, Vite's
+// __vite__mapDeps preload lists, relative ES module imports, and JSON build
+// manifests (Vite's manifest.json, asset-manifest.json). bundleURL is where the
+// content came from; pageURL is the page that loads it, if known, for public
+// paths that are relative to the page.
+func FindChunks(content, bundleURL, pageURL string) []string {
+	bundle, err := url.Parse(bundleURL)
+	if err != nil {
+		return nil
+	}
+	page := bundle
+	if pageURL != "" {
+		if parsed, err := url.Parse(pageURL); err == nil {
+			page = parsed
+		}
+	}
+
+	var refs []*url.URL
+	add := func(base *url.URL, ref string) {
+		if resolved, err := base.Parse(ref); err == nil {
+			refs = append(refs, resolved)
+		}
+	}
+
+	if trimmed := strings.TrimSpace(content); strings.HasPrefix(trimmed, "{") {
+		var manifest interface{}
+		if json.Unmarshal([]byte(trimmed), &manifest) == nil {
+			base := manifestBase(bundle)
+			for _, file := range manifestScripts(manifest) {
+				add(base, file)
+			}
+			return uniqueChunkURLs(refs, bundle)
+		}
+	}
+
+	if files := webpackChunkFiles(content); len(files) > 0 {
+		publicPath, base := "", bundle.ResolveReference(&url.URL{Path: "./"})
+		if match := webpackPublicPathPattern.FindStringSubmatch(content); match != nil && match[1] != "auto" {
+			publicPath = match[1]
+			if !strings.HasPrefix(publicPath, "/") && !strings.Contains(publicPath, "://") {
+				base = page // A relative public path is relative to the page
+			}
+		}
+		for _, file := range files {
+			add(base, publicPath+file)
+		}
+	}
+
+	if i := strings.Index(content, "__vite__mapDeps"); i >= 0 {
+		assetsBase := "/"
+		if match := viteAssetsURLPattern.FindStringSubmatch(content); match != nil && match[1] == match[3] {
+			assetsBase = match[2]
+		}
+		if deps := bracketedList(content[i:], 300); deps != "" {
+			for _, match := range jsStringPattern.FindAllStringSubmatch(deps, -1) {
+				add(bundle, assetsBase+match[1])
+			}
+		}
+	}
+
+	for _, pattern := range []*regexp.Regexp{bundleImportPattern, bundleDynamicImportPattern} {
+		for _, match := range pattern.FindAllStringSubmatch(content, -1) {
+			add(bundle, match[1])
+		}
+	}
+	return uniqueChunkURLs(refs, bundle)
+}
+
+// uniqueChunkURLs drops duplicates, non-HTTP URLs and the bundle itself, and
+// returns the rest sorted, so the list only changes when the chunks do, capped
+// at maxChunksPerBundle.
+func uniqueChunkURLs(refs []*url.URL, bundle *url.URL) []string {
+	seen := map[string]bool{bundle.String(): true}
+	var chunks []string
+	for _, ref := range refs {
+		if ref.Scheme != "http" && ref.Scheme != "https" {
+			continue
+		}
+		ref.Fragment = ""
+		if chunk := ref.String(); !seen[chunk] {
+			seen[chunk] = true
+			chunks = append(chunks, chunk)
+		}
+	}
+	sort.Strings(chunks)
+	if len(chunks) > maxChunksPerBundle {
+		chunks = chunks[:maxChunksPerBundle]
+	}
+	return chunks
+}
+
+// manifestBase is the directory manifest entries are relative to: the build's
+// output directory, which holds Vite's .vite/manifest.json.
+func manifestBase(manifestURL *url.URL) *url.URL {
+	if i := strings.Index(manifestURL.Path, "/.vite/"); i >= 0 {
+		return manifestURL.ResolveReference(&url.URL{Path: manifestURL.Path[:i+1]})
+	}
+	return manifestURL.ResolveReference(&url.URL{Path: "./"})
+}
+
+// manifestScripts collects the script file names among a JSON manifest's
+// values. In a Vite manifest only the "file" entries are files; its other
+// values name entries of the manifest itself.
+func manifestScripts(manifest interface{}) []string {
+	var files, others []string
+	var walk func(key string, value interface{})
+	walk = func(key string, value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for childKey, child := range v {
+				walk(childKey, child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(key, child)
+			}
+		case string:
+			if (strings.HasSuffix(v, ".js") || strings.HasSuffix(v, ".mjs")) && !strings.ContainsAny(v, " \t\n") && !strings.HasPrefix(v, "data:") {
+				if key == "file" {
+					files = append(files, v)
+				} else {
+					others = append(others, v)
+				}
+			}
+		}
+	}
+	walk("", manifest)
+	if len(files) > 0 {
+		return files
+	}
+	return others
+}
+
+// bracketedList returns the first [...] list that starts within limit bytes of
+// the beginning of s.
+func bracketedList(s string, limit int) string {
+	start := strings.Index(s, "[")
+	if start < 0 || start > limit {
+		return ""
+	}
+	if end := strings.Index(s[start:], "]"); end >= 0 {
+		return s[start : start+end+1]
+	}
+	return ""
+}
+
+// webpackChunkFiles evaluates every webpack chunk filename function in a
+// bundle for each chunk ID it knows a hash (or name) for.
+func webpackChunkFiles(content string) []string {
+	var files []string
+	for _, loc := range webpackChunkFuncPattern.FindAllStringSubmatchIndex(content, -1) {
+		param := ""
+		for g := 1; g <= 3; g++ {
+			if loc[2*g] >= 0 {
+				param = content[loc[2*g]:loc[2*g+1]]
+			}
+		}
+		p := &chunkExprParser{src: content, pos: loc[1], param: param}
+		terms, ok := p.parseConcat()
+		if !ok || !indexedLookups(terms) {
+			continue
+		}
+		files = append(files, evaluateChunkTerms(terms)...)
+	}
+	return files
+}
+
+// chunkTerm is one operand of the string concatenation in a chunk filename
+// function: a literal, the chunk ID, the public path, or a lookup of the
+// chunk ID in an object literal, optionally falling back to the ID.
+type chunkTerm struct {
+	literal    string
+	isID       bool
+	publicPath bool
+	lookup     map[string]string
+	indexed    bool
+	fallback   bool
+}
+
+// indexedLookups reports whether the terms have lookups, all indexed by the chunk ID.
+func indexedLookups(terms []chunkTerm) bool {
+	found := false
+	for _, term := range terms {
+		if term.lookup != nil {
+			if !term.indexed {
+				return false
+			}
+			found = true
+		}
+	}
+	return found
+}
+
+// evaluateChunkTerms computes the file name of every chunk whose ID appears in
+// the hash maps (or, without those, in the name maps).
+func evaluateChunkTerms(terms []chunkTerm) []string {
+	var ids []string
+	for _, fallbacks := range []bool{false, true} {
+		for _, term := range terms {
+			if term.lookup != nil && term.fallback == fallbacks {
+				for id := range term.lookup {
+					ids = append(ids, id)
+				}
+			}
+		}
+		if len(ids) > 0 {
+			break
+		}
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+nextID:
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		var b strings.Builder
+		for _, term := range terms {
+			switch {
+			case term.isID:
+				b.WriteString(id)
+			case term.publicPath:
+				// The public path is added by FindChunks.
+			case term.lookup != nil:
+				value, ok := term.lookup[id]
+				if !ok && !term.fallback {
+					continue nextID
+				} else if !ok {
+					value = id
+				}
+				b.WriteString(value)
+			default:
+				b.WriteString(term.literal)
+			}
+		}
+		files = append(files, b.String())
+	}
+	return files
+}
+
+// chunkExprParser parses the small subset of (minified) JavaScript webpack
+// emits for chunk filename functions, e.g.
+//
+//	"static/chunks/"+(({12:"about"})[e]||e)+"."+{12:"3f2a9c1b",34:"9d8e7f6a"}[e]+".js"
+type chunkExprParser struct {
+	src   string
+	pos   int
+	param string
+}
+
+func (p *chunkExprParser) skipSpace() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *chunkExprParser) consume(token string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+// parseConcat parses operands joined by +. A chunk filename function is only
+// useful with at least one lookup.
+func (p *chunkExprParser) parseConcat() ([]chunkTerm, bool) {
+	var terms []chunkTerm
+	for {
+		operand, ok := p.parseOperand()
+		if !ok {
+			return nil, false
+		}
+		terms = append(terms, operand...)
+		if !p.consume("+") {
+			return terms, true
+		}
+	}
+}
+
+// parseOperand parses a primary expression and an optional ||id fallback
+// after a lookup.
+func (p *chunkExprParser) parseOperand() ([]chunkTerm, bool) {
+	terms, ok := p.parsePrimary()
+	if !ok {
+		return nil, false
+	}
+	if len(terms) == 1 && terms[0].indexed && !terms[0].fallback && p.consume("||") {
+		id, ok := p.parsePrimary()
+		if !ok || len(id) != 1 || !id[0].isID {
+			return nil, false
+		}
+		terms[0].fallback = true
+	}
+	return terms, true
+}
+
+func (p *chunkExprParser) parsePrimary() ([]chunkTerm, bool) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, false
+	}
+	switch c := p.src[p.pos]; {
+	case c == '"' || c == '\'' || c == '`':
+		s, ok := p.parseString()
+		return []chunkTerm{{literal: s}}, ok
+	case c == '{':
+		lookup, ok := p.parseObject()
+		if !ok {
+			return nil, false
+		}
+		term := chunkTerm{lookup: lookup}
+		if p.peek('[') {
+			return p.parseIndex(term)
+		}
+		return []chunkTerm{term}, true // Indexed after the closing parenthesis: ({...})[e]
+	case c == '(':
+		p.pos++
+		terms, ok := p.parseConcat()
+		if !ok || !p.consume(")") {
+			return nil, false
+		}
+		if len(terms) == 1 && terms[0].lookup != nil && !terms[0].indexed {
+			return p.parseIndex(terms[0])
+		}
+		return terms, true
+	default:
+		ident := p.parseIdent()
+		switch {
+		case ident == "":
+			return nil, false
+		case ident == p.param:
+			return []chunkTerm{{isID: true}}, true
+		case strings.HasSuffix(ident, ".p"):
+			return []chunkTerm{{publicPath: true}}, true
+		}
+		return nil, false
+	}
+}
+
+func (p *chunkExprParser) peek(c byte) bool {
+	p.skipSpace()
+	return p.pos < len(p.src) && p.src[p.pos] == c
+}
+
+// parseIndex parses the [id] after an object literal.
+func (p *chunkExprParser) parseIndex(term chunkTerm) ([]chunkTerm, bool) {
+	if !p.consume("[") || p.parseIdent() != p.param || !p.consume("]") {
+		return nil, false
+	}
+	term.indexed = true
+	return []chunkTerm{term}, true
+}
+
+// parseIdent parses an identifier or a member expression such as r.p.
+func (p *chunkExprParser) parseIdent() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '_' || c == '$' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *chunkExprParser) parseString() (string, bool) {
+	quote := p.src[p.pos]
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return b.String(), true
+		case c == '\\' && p.pos+1 < len(p.src):
+			b.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+		case c == '\n' || (quote == '`' && c == '$'):
+			return "", false // No multi-line strings or template interpolation
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", false
+}
+
+// parseObject parses an object literal with number, identifier or string keys
+// and string values.
+func (p *chunkExprParser) parseObject() (map[string]string, bool) {
+	p.pos++ // {
+	values := make(map[string]string)
+	for {
+		if p.consume("}") {
+			return values, true
+		}
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, false
+		}
+		var key string
+		if c := p.src[p.pos]; c == '"' || c == '\'' {
+			var ok bool
+			if key, ok = p.parseString(); !ok {
+				return nil, false
+			}
+		} else if key = p.parseIdent(); key == "" {
+			return nil, false
+		}
+		if !p.consume(":") {
+			return nil, false
+		}
+		p.skipSpace()
+		if p.pos >= len(p.src) || (p.src[p.pos] != '"' && p.src[p.pos] != '\'') {
+			return nil, false
+		}
+		value, ok := p.parseString()
+		if !ok {
+			return nil, false
+		}
+		values[key] = value
+		if !p.consume(",") && !p.peek('}') {
+			return nil, false
+		}
+	}
+}
+
+// ChunkKey identifies a chunk across deploys: its URL with the content hash
+// taken out of the file name, so "app-3f2a9c1b.js" and "app-9d8e7f6a.js" match.
+func ChunkKey(chunkURL string) string {
+	parsed, err := url.Parse(chunkURL)
+	if err != nil {
+		return chunkURL
+	}
+	dir, name := path.Split(parsed.Path)
+	if match := hexHashPattern.FindStringSubmatch(name); match != nil {
+		name = match[1] + match[2]
+	} else if match := viteHashPattern.FindStringSubmatch(name); match != nil && strings.ContainsAny(match[2], "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ_") {
+		name = match[1] + match[3]
+	}
+	parsed.Path = dir + name
+	parsed.RawQuery = ""
+	parsed.Fragment = ""
+	return parsed.String()
+}
+
+// discoverChunks looks for chunks in new content of a watched URL and stores
+// them on the entry, which the caller saves. In a group with AutoWatchChunks
+// the chunks are watched too: a watched chunk whose hash changed follows its
+// new URL, so its history continues, and new chunks are added to the group.
+func discoverChunks(db *gorm.DB, urlEntry *models.WatchedUrl, content string) {
+	var group *models.URLGroup
+	if urlEntry.GroupID != nil {
+		var g models.URLGroup
+		if db.First(&g, *urlEntry.GroupID).Error == nil {
+			group = &g
+		}
+	}
+	pageURL := ""
+	if group != nil {
+		pageURL = group.SourceURL
+	}
+
+	previous := urlEntry.ChunkURLs()
+	chunks := FindChunks(content, urlEntry.URL, pageURL)
+	now := time.Now().UTC()
+	urlEntry.Chunks = strings.Join(chunks, "\n")
+	urlEntry.ChunksFoundAt = &now
+	if len(chunks) == 0 || group == nil || !group.AutoWatchChunks {
+		return
+	}
+
+	renamed, added, err := watchChunks(db, *group, urlEntry, previous, chunks)
+	if err != nil {
+		log.Printf("Error watching chunks of %s: %v", urlEntry.URL, err)
+	}
+	if renamed > 0 || len(added) > 0 {
+		log.Printf("Chunks of %s: %d moved to new hashes, %d newly watched", urlEntry.URL, renamed, len(added))
+	}
+}
+
+// RefreshChunks looks for chunks in the last content of a watched URL again,
+// as discovery does after each change, and returns how many it found.
+func RefreshChunks(db *gorm.DB, urlID uint) (int, error) {
+	var urlEntry models.WatchedUrl
+	if result := db.First(&urlEntry, urlID); result.Error != nil {
+		return 0, result.Error
+	}
+	discoverChunks(db, &urlEntry, urlEntry.LastContent)
+	result := db.Model(&models.WatchedUrl{}).Where("id = ?", urlEntry.ID).Updates(map[string]interface{}{
+		"chunks":          urlEntry.Chunks,
+		"chunks_found_at": urlEntry.ChunksFoundAt,
+	})
+	return len(urlEntry.ChunkURLs()), result.Error
+}
+
+// WatchChunkURLs watches chunks of a bundle. In a group they are added to it
+// with the group's settings for new scripts; otherwise they copy the bundle's
+// interval and flags. It returns the chunks that were added.
+func WatchChunkURLs(db *gorm.DB, bundle models.WatchedUrl, chunks []string) ([]string, error) {
+	if bundle.GroupID != nil {
+		var group models.URLGroup
+		if result := db.First(&group, *bundle.GroupID); result.Error != nil {
+			return nil, result.Error
+		}
+		return watchNewScripts(db, group, chunks)
+	}
+	return watchScripts(db, models.WatchedUrl{
+		IntervalSeconds:    bundle.IntervalSeconds,
+		Beautify:           bundle.Beautify,
+		AllowPrivateTarget: bundle.AllowPrivateTarget,
+	}, chunks)
+}
+
+// watchChunks makes sure a group watches every chunk found in one of its
+// bundles. A watched chunk the bundle referenced before (previous) but no
+// longer does is moved to the new chunk with the same key; a check of the old
+// address still in flight drops its result instead of saving it over the move
+// (see saveURLEntry). It returns how many watched chunks were moved to a new
+// URL and which chunks were added.
+func watchChunks(db *gorm.DB, group models.URLGroup, bundle *models.WatchedUrl, previous, chunks []string) (int, []string, error) {
+	found := make(map[string]bool, len(chunks))
+	for _, chunk := range chunks {
+		found[chunk] = true
+	}
+	var dropped []string
+	for _, chunk := range previous {
+		if !found[chunk] {
+			dropped = append(dropped, chunk)
+		}
+	}
+	// Watched chunks the bundle no longer references, by key. Only this
+	// bundle's own chunks can move: another bundle's chunk or an unrelated
+	// script whose name happens to have the same key stays where it is.
+	stale := make(map[string]models.WatchedUrl)
+	if len(dropped) > 0 {
+		var droppedURLs []models.WatchedUrl
+		if result := db.Select("id", "url").Where("group_id = ? AND id <> ? AND url IN ?", group.ID, bundle.ID, dropped).Find(&droppedURLs); result.Error != nil {
+			return 0, nil, result.Error
+		}
+		for _, u := range droppedURLs {
+			stale[ChunkKey(u.URL)] = u
+		}
+	}
+
+	renamed := 0
+	var newChunks []string
+	for _, chunk := range chunks {
+		var count int64
+		if result := db.Unscoped().Model(&models.WatchedUrl{}).Where("url = ?", chunk).Count(&count); result.Error != nil {
+			return renamed, nil, result.Error
+		} else if count > 0 {
+			continue
+		}
+		previous, ok := stale[ChunkKey(chunk)]
+		if !ok {
+			newChunks = append(newChunks, chunk)
+			continue
+		}
+		if err := CheckTargetURL(chunk, group.AllowPrivateTarget); err != nil {
+			log.Printf("Not moving %s to %s: %v", previous.URL, chunk, err)
+			continue
+		}
+		delete(stale, ChunkKey(chunk))
+		result := db.Model(&models.WatchedUrl{}).Where("id = ?", previous.ID).Updates(map[string]interface{}{
+			"url":           chunk,
+			"e_tag":         "",
+			"last_modified": "",
+			"next_check_at": nil,
+		})
+		if result.Error != nil {
+			return renamed, nil, result.Error
+		}
+		log.Printf("Chunk %s moved to %s", previous.URL, chunk)
+		renamed++
+	}
+
+	added, err := watchNewScripts(db, group, newChunks)
+	return renamed, added, err
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"go-js-watcher/models"
+)
+
+func TestFindChunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		bundle  string
+		page    string
+		want    []string
+	}{
+		{
+			name:    "webpack 5 with names and hashes",
+			content: `r.p="/_next/";r.u=e=>"static/chunks/"+(({12:"about"})[e]||e)+"."+{12:"3f2a9c1b",34:"9d8e7f6a"}[e]+".js";`,
+			bundle:  "https://example.com/_next/static/chunks/webpack-0a1b2c3d.js",
+			want: []string{
+				"https://example.com/_next/static/chunks/34.9d8e7f6a.js",
+				"https://example.com/_next/static/chunks/about.3f2a9c1b.js",
+			},
+		},
+		{
+			name:    "webpack 5 function form without public path",
+			content: `o.u=function(e){return"js/"+e+"."+{1:"aaaa1111"}[e]+".js"};`,
+			bundle:  "https://example.com/static/runtime.js",
+			want:    []string{"https://example.com/static/js/1.aaaa1111.js"},
+		},
+		{
+			name:    "webpack 4 with absolute public path",
+			content: `__webpack_require__.p="https://cdn.example.net/";function jsonpScriptSrc(chunkId){return __webpack_require__.p+"js/"+({}[chunkId]||chunkId)+"."+{"0":"aaaa1111","1":"bbbb2222"}[chunkId]+".js"}`,
+			bundle:  "https://example.com/app.js",
+			want:    []string{"https://cdn.example.net/js/0.aaaa1111.js", "https://cdn.example.net/js/1.bbbb2222.js"},
+		},
+		{
+			name:    "relative public path is relative to the page",
+			content: `r.p="assets/";r.u=e=>e+"."+{5:"cccc3333"}[e]+".js"`,
+			bundle:  "https://cdn.example.com/x/main.js",
+			page:    "https://example.com/app/",
+			want:    []string{"https://example.com/app/assets/5.cccc3333.js"},
+		},
+		{
+			name:    "auto public path is the bundle's directory",
+			content: `r.p="auto";r.u=e=>e+"."+{5:"cccc3333"}[e]+".js"`,
+			bundle:  "https://cdn.example.com/x/main.js",
+			page:    "https://example.com/app/",
+			want:    []string{"https://cdn.example.com/x/5.cccc3333.js"},
+		},
+		{
+			name:    "vite preload list",
+			content: `const __vite__mapDeps=(i,m=__vite__mapDeps,d=(m.f||(m.f=["assets/About-BdK3x9Qz.js","assets/About-CSS01234.css","assets/Home-a1b2c3d4.js"])))=>i.map(i=>d[i]);`,
+			bundle:  "https://example.com/assets/index-Dx8kq1Zp.js",
+			want:    []string{"https://example.com/assets/About-BdK3x9Qz.js", "https://example.com/assets/Home-a1b2c3d4.js"},
+		},
+		{
+			name:    "vite preload list with a base",
+			content: `const x=function(e){return"/app/"+e};const __vite__mapDeps=(i,m=__vite__mapDeps,d=(m.f||(m.f=["assets/About-BdK3x9Qz.js"])))=>i.map(i=>d[i]);`,
+			bundle:  "https://example.com/app/assets/index-Dx8kq1Zp.js",
+			want:    []string{"https://example.com/app/assets/About-BdK3x9Qz.js"},
+		},
+		{
+			name:    "es module imports",
+			content: `import{a as b}from"./vendor-1a2b3c4d.js";export*from"../shared.js";import"./index-Dx8kq1Zp.js";const m=()=>import("./lazy-Xy12Ab34.js");import x from"react";`,
+			bundle:  "https://example.com/assets/index-Dx8kq1Zp.js",
+			want: []string{
+				"https://example.com/assets/lazy-Xy12Ab34.js",
+				"https://example.com/assets/vendor-1a2b3c4d.js",
+				"https://example.com/shared.js",
+			},
+		},
+		{
+			name:    "vite manifest",
+			content: `{"index.html":{"file":"assets/index-BdK3x9Qz.js","imports":["_shared-a1b2c3d4.js"],"css":["assets/index-0a1b2c3d.css"]},"_shared-a1b2c3d4.js":{"file":"assets/shared-a1b2c3d4.js"}}`,
+			bundle:  "https://example.com/dist/.vite/manifest.json",
+			want:    []string{"https://example.com/dist/assets/index-BdK3x9Qz.js", "https://example.com/dist/assets/shared-a1b2c3d4.js"},
+		},
+		{
+			name:    "asset manifest",
+			content: `{"files":{"main.js":"/static/js/main.1a2b3c4d.js","static/js/787.5e6f7a8b.chunk.js":"/static/js/787.5e6f7a8b.chunk.js","main.css":"/static/css/main.css"},"entrypoints":["static/js/main.1a2b3c4d.js"]}`,
+			bundle:  "https://example.com/asset-manifest.json",
+			want:    []string{"https://example.com/static/js/787.5e6f7a8b.chunk.js", "https://example.com/static/js/main.1a2b3c4d.js"},
+		},
+		{
+			name:    "no chunks",
+			content: `console.log("hello")`,
+			bundle:  "https://example.com/app.js",
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FindChunks(tt.content, tt.bundle, tt.page); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindChunks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkKey(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/_next/static/chunks/about.3f2a9c1b.js", "https://example.com/_next/static/chunks/about.js"},
+		{"https://example.com/static/js/787.5e6f7a8b.chunk.js", "https://example.com/static/js/787.chunk.js"},
+		{"https://example.com/app-3f2a9c1b.js", "https://example.com/app.js"},
+		{"https://example.com/assets/About-BdK3x9Qz.js", "https://example.com/assets/About.js"},
+		{"https://example.com/assets/index-Dx8kq1Zp.mjs", "https://example.com/assets/index.mjs"},
+		{"https://example.com/assets/date-calendar.js", "https://example.com/assets/date-calendar.js"},
+		{"https://example.com/main.js", "https://example.com/main.js"},
+		{"https://example.com/main.js?v=3#top", "https://example.com/main.js"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := ChunkKey(tt.url); got != tt.want {
+				t.Errorf("ChunkKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkExprParser(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "names with fallback and hashes",
+			src:  `"static/chunks/"+(({12:"about"})[e]||e)+"."+{12:"3f2a9c1b",34:"9d8e7f6a"}[e]+".js"`,
+			want: []string{"static/chunks/34.9d8e7f6a.js", "static/chunks/about.3f2a9c1b.js"},
+		},
+		{
+			name: "names only",
+			src:  `"js/"+({1:"vendor",2:"admin"}[e]||e)+".js"`,
+			want: []string{"js/admin.js", "js/vendor.js"},
+		},
+		{
+			name: "quoted keys and single quotes",
+			src:  `'c/'+e+'.'+{'a-b':'0123abcd',"c":'4567ef01'}[e]+'.js'`,
+			want: []string{"c/a-b.0123abcd.js", "c/c.4567ef01.js"},
+		},
+		{
+			name: "public path",
+			src:  `r.p+"x/"+{1:"aa"}[e]+".js"`,
+			want: []string{"x/aa.js"},
+		},
+		{
+			name: "ids missing from a map without fallback are skipped",
+			src:  `{1:"n1",2:"n2"}[e]+"."+{1:"h1"}[e]+".js"`,
+			want: []string{"n1.h1.js"},
+		},
+		{
+			name: "stops at the end of the expression",
+			src:  `"a"+{1:"b"}[e]+".js"};foo()`,
+			want: []string{"ab.js"},
+		},
+		{
+			name: "indexed by another variable",
+			src:  `"x/"+{1:"aa"}[t]+".js"`,
+		},
+		{
+			name: "no lookup",
+			src:  `"x/"+e+".js"`,
+		},
+		{
+			name: "function call",
+			src:  `"x/"+foo(e)+".js"`,
+		},
+		{
+			name: "template interpolation",
+			src:  "`x/${e}.js`",
+		},
+		{
+			name: "non-string values",
+			src:  `"x/"+{1:2}[e]+".js"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &chunkExprParser{src: tt.src, param: "e"}
+			var got []string
+			if terms, ok := p.parseConcat(); ok && indexedLookups(terms) {
+				got = evaluateChunkTerms(terms)
+				sort.Strings(got)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchChunksMovesOnlyTheBundlesChunks(t *testing.T) {
+	db := newTestDB(t)
+	group := models.URLGroup{Name: "app", AutoWatchChunks: true, AllowPrivateTarget: true}
+	if err := db.Create(&group).Error; err != nil {
+		t.Fatal(err)
+	}
+	watch := func(url string) models.WatchedUrl {
+		u := models.WatchedUrl{URL: url, GroupID: &group.ID, IntervalSeconds: 60}
+		if err := db.Create(&u).Error; err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	bundle := watch("https://example.com/assets/index-Dx8kq1Zp.js")
+	own := watch("https://example.com/assets/about-1111aaaa.js")
+	// Another bundle's chunk with the same key as one of the new chunks.
+	other := watch("https://example.com/assets/home-2222bbbb.js")
+
+	previous := []string{own.URL, "https://example.com/assets/home-3333cccc.js"}
+	chunks := []string{"https://example.com/assets/about-4444dddd.js", "https://example.com/assets/home-5555eeee.js"}
+	renamed, added, err := watchChunks(db, group, &bundle, previous, chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed != 1 {
+		t.Errorf("renamed = %d, want 1", renamed)
+	}
+	if want := []string{chunks[1]}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %q, want %q", added, want)
+	}
+
+	for _, tt := range []struct {
+		id   uint
+		want string
+	}{{own.ID, chunks[0]}, {other.ID, other.URL}, {bundle.ID, bundle.URL}} {
+		var u models.WatchedUrl
+		if err := db.First(&u, tt.id).Error; err != nil {
+			t.Fatal(err)
+		}
+		if u.URL != tt.want {
+			t.Errorf("URL %d = %q, want %q", tt.id, u.URL, tt.want)
+		}
+	}
+}
+
+func TestWatchChunksMoveSurvivesInFlightCheck(t *testing.T) {
+	db := newTestDB(t)
+	group := models.URLGroup{Name: "app", AutoWatchChunks: true, AllowPrivateTarget: true}
+	if err := db.Create(&group).Error; err != nil {
+		t.Fatal(err)
+	}
+	bundle := models.WatchedUrl{URL: "https://example.com/assets/index-Dx8kq1Zp.js", GroupID: &group.ID}
+	chunk := models.WatchedUrl{URL: "https://example.com/assets/about-1111aaaa.js", GroupID: &group.ID, ETag: `"old"`}
+	for _, u := range []*models.WatchedUrl{&bundle, &chunk} {
+		if err := db.Create(u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The chunk is being checked at its old address while the bundle moves it.
+	inFlight := chunk
+	moved := "https://example.com/assets/about-4444dddd.js"
+	if _, _, err := watchChunks(db, group, &bundle, []string{chunk.URL}, []string{moved}); err != nil {
+		t.Fatal(err)
+	}
+	inFlight.ETag = `"stale"`
+	inFlight.LastContent = "content of the old chunk"
+	saveURLEntry(db, &inFlight)
+
+	var saved models.WatchedUrl
+	if err := db.First(&saved, chunk.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if saved.URL != moved || saved.ETag != "" || saved.LastContent != "" {
+		t.Errorf("after the in-flight check: url %q, etag %q, content %q", saved.URL, saved.ETag, saved.LastContent)
+	}
+}
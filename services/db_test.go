@@ -0,0 +1,24 @@
+package services
+
+import (
+	"testing"
+
+	"go-js-watcher/database"
+
+	"gorm.io/gorm"
+)
+
+// newTestDB returns a migrated in-memory database for one test.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := database.OpenInMemory()
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-js-watcher/models"
+)
+
+// DefaultDowntimeStatusCodes are the HTTP statuses that count as a URL being down
+// unless DOWNTIME_STATUS_CODES says otherwise.
+const DefaultDowntimeStatusCodes = "404,410,5xx"
+
+var (
+	downtimeStatusCodes = mustParseStatusCodes(DefaultDowntimeStatusCodes)
+
+	// DowntimeEscalateAfter sends one extra alert once a URL has failed this many
+	// consecutive checks. Zero disables escalation.
+	DowntimeEscalateAfter int
+)
+
+// statusCodeRange is an inclusive range of HTTP status codes.
+type statusCodeRange struct{ from, to int }
+
+// SetDowntimeStatusCodes configures which HTTP statuses mark a URL as down. The
+// spec is a comma-separated list of codes ("404"), ranges ("500-599") and
+// classes ("5xx").
+func SetDowntimeStatusCodes(spec string) error {
+	codes, err := parseStatusCodes(spec)
+	if err != nil {
+		return err
+	}
+	downtimeStatusCodes = codes
+	return nil
+}
+
+func mustParseStatusCodes(spec string) []statusCodeRange {
+	codes, err := parseStatusCodes(spec)
+	if err != nil {
+		panic(err)
+	}
+	return codes
+}
+
+func parseStatusCodes(spec string) ([]statusCodeRange, error) {
+	var codes []statusCodeRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		if len(part) == 3 && strings.HasSuffix(part, "xx") {
+			class, err := strconv.Atoi(part[:1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid status class %q", part)
+			}
+			codes = append(codes, statusCodeRange{class * 100, class*100 + 99})
+			continue
+		}
+		fromStr, toStr, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(fromStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status code %q", part)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(toStr); err != nil {
+				return nil, fmt.Errorf("invalid status code range %q", part)
+			}
+		}
+		codes = append(codes, statusCodeRange{from, to})
+	}
+	return codes, nil
+}
+
+// isDownStatus reports whether an HTTP status marks a URL as down.
+func isDownStatus(code int) bool {
+	for _, r := range downtimeStatusCodes {
+		if code >= r.from && code <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
+// recordFailure counts a failed check. The first failure moves the URL to the
+// down state and sends a single alert; further failures stay quiet except for
+// one escalation alert once DowntimeEscalateAfter consecutive failures are
+// reached. The escalation needs a failure after the first, so a setting of 1
+// escalates on the second.
+// The caller is responsible for saving urlEntry.
+func recordFailure(urlEntry *models.WatchedUrl, reason string) {
+	now := time.Now().UTC()
+	urlEntry.ConsecutiveFailures++
+
+	if !urlEntry.IsDown {
+		urlEntry.IsDown = true
+		urlEntry.DownSince = &now
+		log.Printf("URL %s is down: %s", urlEntry.URL, reason)
+		notify(Notification{Event: EventDown, URL: urlEntry.URL, Reason: reason, ConsecutiveFailures: urlEntry.ConsecutiveFailures, Time: now})
+		return
+	}
+
+	if DowntimeEscalateAfter > 0 && urlEntry.ConsecutiveFailures >= DowntimeEscalateAfter && !urlEntry.DowntimeEscalated {
+		urlEntry.DowntimeEscalated = true
+		log.Printf("URL %s still down after %d consecutive failures", urlEntry.URL, urlEntry.ConsecutiveFailures)
+		notify(Notification{
+			Event:               EventEscalation,
+			URL:                 urlEntry.URL,
+			Reason:              reason,
+			ConsecutiveFailures: urlEntry.ConsecutiveFailures,
+			DowntimeSeconds:     int64(downtimeDuration(urlEntry, now).Seconds()),
+			Time:                now,
+		})
+	}
+}
+
+// recordRecovery resets the failure count after a successful check and sends a
+// single recovery alert if the URL was down. The caller is responsible for saving urlEntry.
+func recordRecovery(urlEntry *models.WatchedUrl) {
+	urlEntry.ConsecutiveFailures = 0
+	if !urlEntry.IsDown {
+		return
+	}
+
+	now := time.Now().UTC()
+	downFor := downtimeDuration(urlEntry, now)
+	urlEntry.IsDown = false
+	urlEntry.DownSince = nil
+	urlEntry.DowntimeEscalated = false
+
+	log.Printf("URL %s recovered after %s", urlEntry.URL, downFor)
+	notify(Notification{Event: EventRecovery, URL: urlEntry.URL, DowntimeSeconds: int64(downFor.Seconds()), Time: now})
+}
+
+func downtimeDuration(urlEntry *models.WatchedUrl, now time.Time) time.Duration {
+	if urlEntry.DownSince == nil {
+		return 0
+	}
+	return now.Sub(*urlEntry.DownSince).Round(time.Second)
+}
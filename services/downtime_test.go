@@ -0,0 +1,56 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"go-js-watcher/models"
+)
+
+// recordingNotifier keeps the events of the notifications it receives.
+type recordingNotifier struct{ events []string }
+
+func (r *recordingNotifier) Name() string { return "recording" }
+
+func (r *recordingNotifier) Notify(n Notification) error {
+	r.events = append(r.events, n.Event)
+	return nil
+}
+
+func TestDowntimeEscalation(t *testing.T) {
+	defer func(saved []Notifier, after int) { notifiers, DowntimeEscalateAfter = saved, after }(notifiers, DowntimeEscalateAfter)
+
+	tests := []struct {
+		name          string
+		escalateAfter int
+		checks        string // f for a failed check, s for a successful one
+		want          []string
+	}{
+		{"off", 0, "fffff", []string{EventDown}},
+		{"after 1 escalates on the second failure", 1, "fff", []string{EventDown, EventEscalation}},
+		{"after 3", 3, "fffff", []string{EventDown, EventEscalation}},
+		{"not reached", 3, "ffs", []string{EventDown, EventRecovery}},
+		{"escalates again after a recovery", 2, "fffsff", []string{EventDown, EventEscalation, EventRecovery, EventDown, EventEscalation}},
+		{"up stays quiet", 2, "sss", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &recordingNotifier{}
+			notifiers = []Notifier{recorder}
+			DowntimeEscalateAfter = tt.escalateAfter
+
+			entry := models.WatchedUrl{URL: "https://example.com/app.js"}
+			for _, check := range strings.Split(tt.checks, "") {
+				if check == "f" {
+					recordFailure(&entry, "connection refused")
+				} else {
+					recordRecovery(&entry)
+				}
+			}
+			if !reflect.DeepEqual(recorder.events, tt.want) {
+				t.Errorf("notifications = %q, want %q", recorder.events, tt.want)
+			}
+		})
+	}
+}
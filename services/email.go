@@ -0,0 +1,198 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxEmailDiffSize caps how much rendered diff is embedded in a change email.
+// Larger diffs are left out and the email points to the dashboard instead.
+const maxEmailDiffSize = 512 * 1024
+
+// EmailNotifier sends notifications as HTML emails over SMTP.
+type EmailNotifier struct {
+	Host     string
+	Port     int
+	Username string // Leave empty to send without authentication
+	Password string
+	From     string
+	To       []string
+	StartTLS bool   // Require STARTTLS before authenticating and sending
+	BaseURL  string // APP_BASE_URL, used to link downtime emails to the dashboard
+}
+
+func (e *EmailNotifier) Name() string { return "Email" }
+
+func (e *EmailNotifier) Notify(n Notification) error {
+	message, err := e.buildMessage(n)
+	if err != nil {
+		return err
+	}
+	return e.send(message)
+}
+
+// send delivers a complete message to every recipient in a single SMTP session.
+func (e *EmailNotifier) send(message []byte) error {
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	conn, err := net.DialTimeout("tcp", addr, 15*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	client, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if e.StartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server %s does not support STARTTLS", addr)
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: e.Host}); err != nil {
+			return err
+		}
+	}
+	if e.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %v", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// emailBody is the data passed to emailTemplate.
+type emailBody struct {
+	Title    string
+	Details  string
+	URL      string
+	Link     string
+	Diff     template.HTML
+	DiffNote string
+}
+
+var emailTemplate = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: Arial, sans-serif; color: #333;">
+<h2 style="font-size: 18px;">{{.Title}}</h2>
+{{if .URL}}<p><a href="{{.URL}}">{{.URL}}</a></p>{{end}}
+{{if .Details}}<p style="white-space: pre-line;">{{.Details}}</p>{{end}}
+{{if .Link}}<p><a href="{{.Link}}" style="display: inline-block; padding: 8px 14px; background: #007bff; color: #fff; text-decoration: none; border-radius: 4px;">View on the dashboard</a></p>{{end}}
+{{if .Diff}}<pre style="white-space: pre-wrap; word-wrap: break-word; font-family: monospace; font-size: 13px; background: #f8f9fa; border: 1px solid #ddd; padding: 10px;">{{.Diff}}</pre>{{end}}
+{{if .DiffNote}}<p><em>{{.DiffNote}}</em></p>{{end}}
+</body>
+</html>
+`))
+
+// buildMessage renders the notification as a multipart/alternative email with
+// a plain-text part and an HTML part that embeds the diff of a change.
+func (e *EmailNotifier) buildMessage(n Notification) ([]byte, error) {
+	body := emailBody{Title: n.Title(), URL: n.URL, Link: n.Link}
+	if n.Event != EventChange {
+		body.Details = n.Details()
+		if body.Link == "" && e.BaseURL != "" {
+			body.Link = strings.TrimRight(e.BaseURL, "/") + "/dashboard"
+		}
+	} else {
+		body.Details = strings.Join(n.ChangeLines(), "\n")
+	}
+	if n.DiffHTML != "" {
+		if len(n.DiffHTML) > maxEmailDiffSize {
+			body.DiffNote = "The diff is too large to include in this email."
+		} else {
+			// DiffText is produced by RenderDiff, which escapes the compared content.
+			body.Diff = template.HTML(n.DiffHTML)
+		}
+	}
+
+	var htmlPart bytes.Buffer
+	if err := emailTemplate.Execute(&htmlPart, body); err != nil {
+		return nil, err
+	}
+	textPart := body.Title + "\n\n" + n.Details() + "\n"
+	// Change and inventory details already end with the link.
+	if body.Link != "" && n.Event != EventChange && n.Event != EventInventory {
+		textPart += "\n" + body.Link + "\n"
+	}
+
+	var msg bytes.Buffer
+	mw := multipart.NewWriter(&msg)
+	headers := []string{
+		"From: " + e.From,
+		"To: " + strings.Join(e.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", "[JS Watcher] "+body.Title),
+		"Date: " + n.Time.Format(time.RFC1123Z),
+		"Message-ID: " + e.messageID(),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + mw.Boundary(),
+	}
+	msg.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", textPart},
+		{"text/html; charset=utf-8", htmlPart.String()},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+func (e *EmailNotifier) messageID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	domain := e.Host
+	if _, addrDomain, ok := strings.Cut(e.From, "@"); ok {
+		domain = strings.Trim(addrDomain, "> ")
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(buf), domain)
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEmail parses a message built by EmailNotifier and returns its decoded
+// subject and its parts by content type.
+func readEmail(t *testing.T, message []byte) (string, map[string]string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(string(message)))
+	if err != nil {
+		t.Fatalf("reading message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type %q: %v", msg.Header.Get("Content-Type"), err)
+	}
+	parts := map[string]string{}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+	return subject, parts
+}
+
+func TestEmailChangeMessage(t *testing.T) {
+	e := &EmailNotifier{From: "watcher@example.com", To: []string{"a@example.com", "b@example.com"}}
+	message, err := e.buildMessage(Notification{
+		Event:    EventChange,
+		URL:      "https://example.com/app.js",
+		Link:     "https://watcher.example.com/diff/7",
+		DiffHTML: `<ins style="background:#e6ffe6;">added &lt;code&gt;</ins>`,
+		Time:     time.Date(2026, 10, 5, 10, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, parts := readEmail(t, message)
+	if !strings.HasPrefix(subject, "[JS Watcher] ") {
+		t.Errorf("subject = %q", subject)
+	}
+	html := parts["text/html"]
+	if !strings.Contains(html, `<ins style="background:#e6ffe6;">added &lt;code&gt;</ins>`) {
+		t.Errorf("the HTML part doesn't embed the diff:\n%s", html)
+	}
+	if !strings.Contains(html, `href="https://watcher.example.com/diff/7"`) {
+		t.Errorf("the HTML part doesn't link to the diff:\n%s", html)
+	}
+	if text := parts["text/plain"]; !strings.Contains(text, "https://example.com/app.js") || strings.Contains(text, "<ins") {
+		t.Errorf("text part = %q", text)
+	}
+	if !strings.Contains(string(message), "To: a@example.com, b@example.com\r\n") {
+		t.Error("not addressed to every recipient")
+	}
+}
+
+func TestEmailLargeDiffIsLeftOut(t *testing.T) {
+	e := &EmailNotifier{From: "watcher@example.com", To: []string{"a@example.com"}}
+	message, err := e.buildMessage(Notification{
+		Event:    EventChange,
+		URL:      "https://example.com/app.js",
+		DiffHTML: "<ins>" + strings.Repeat("x", maxEmailDiffSize) + "</ins>",
+		Time:     time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, parts := readEmail(t, message)
+	if html := parts["text/html"]; strings.Contains(html, "<ins>") || !strings.Contains(html, "too large") {
+		t.Error("a diff over the size limit was embedded")
+	}
+}
+
+func TestEmailDowntimeLinksToDashboard(t *testing.T) {
+	e := &EmailNotifier{From: "watcher@example.com", To: []string{"a@example.com"}, BaseURL: "https://watcher.example.com/"}
+	message, err := e.buildMessage(Notification{Event: EventDown, URL: "https://example.com/app.js", Reason: "HTTP Error 503", Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, parts := readEmail(t, message)
+	for contentType, body := range parts {
+		if !strings.Contains(body, "https://watcher.example.com/dashboard") {
+			t.Errorf("%s part doesn't link to the dashboard:\n%s", contentType, body)
+		}
+	}
+}
+
+func TestEmailSend(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// A minimal SMTP server that accepts one message.
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		var commands []string
+		reply("220 test ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			commands = append(commands, line)
+			switch verb := strings.ToUpper(strings.Fields(line + " x")[0]); verb {
+			case "EHLO", "HELO":
+				reply("250 test")
+			case "DATA":
+				reply("354 go ahead")
+				for {
+					data, err := r.ReadString('\n')
+					if err != nil || data == ".\r\n" {
+						break
+					}
+				}
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				received <- commands
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	e := &EmailNotifier{Host: host, Port: portNumber, From: "watcher@example.com", To: []string{"a@example.com", "b@example.com"}}
+	if err := e.Notify(Notification{Event: EventChange, URL: "https://example.com/app.js", Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := strings.Join(<-received, "\n")
+	for _, want := range []string{"MAIL FROM:<watcher@example.com>", "RCPT TO:<a@example.com>", "RCPT TO:<b@example.com>", "DATA"} {
+		if !strings.Contains(commands, want) {
+			t.Errorf("SMTP session is missing %q:\n%s", want, commands)
+		}
+	}
+}
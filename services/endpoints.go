@@ -0,0 +1,251 @@
+package services
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go-js-watcher/models"
+
+	"gorm.io/gorm"
+)
+
+// Endpoint kinds.
+const (
+	EndpointURL     = "url"     // A full http(s) URL
+	EndpointPath    = "path"    // A quoted absolute path such as "/api/v1/users"
+	EndpointGraphQL = "graphql" // A named GraphQL operation such as "query GetUser"
+	EndpointCall    = "call"    // The target of a fetch, axios or XHR call
+)
+
+// EndpointKinds lists every endpoint kind, for filtering the inventory.
+var EndpointKinds = []string{EndpointURL, EndpointPath, EndpointGraphQL, EndpointCall}
+
+const (
+	// maxEndpointsPerURL caps how many endpoints are kept for one URL.
+	maxEndpointsPerURL = 5000
+	// maxEndpointLength skips long matches, which are data rather than endpoints.
+	maxEndpointLength = 500
+)
+
+// quotedArg matches the first argument of a call when it is a string literal.
+const quotedArg = `\(\s*(?:"([^"\n]*)"|'([^'\n]*)'|` + "`([^`]*)`)"
+
+var (
+	fullURLPattern    = regexp.MustCompile("https?://[A-Za-z0-9.-]+(?::[0-9]+)?(?:/[^\\s\"'`<>()\\\\{}|^]*)?")
+	quotedPathPattern = regexp.MustCompile("[\"'`](/[A-Za-z0-9_.~%:@!$&+=,;/{}-]*(?:\\?[A-Za-z0-9_.~%:@!$&+=,;/?{}-]*)?)[\"'`]")
+	graphQLPattern    = regexp.MustCompile(`\b(query|mutation|subscription)\s+([A-Za-z_][A-Za-z0-9_]*)\s*[({]`)
+	fetchPattern      = regexp.MustCompile(`\bfetch` + quotedArg)
+	axiosPattern      = regexp.MustCompile(`\baxios(?:\.(get|post|put|patch|delete|head|options|request))?` + quotedArg)
+	xhrOpenPattern    = regexp.MustCompile(`\.open\(\s*["'](GET|POST|PUT|PATCH|DELETE|HEAD|OPTIONS)["']\s*,\s*(?:"([^"\n]*)"|'([^'\n]*)'|` + "`([^`]*)`)")
+
+	// URLs that appear in nearly every bundle without being endpoints.
+	endpointNoise = []string{"http://www.w3.org/", "https://www.w3.org/", "https://reactjs.org/docs/error-decoder", "https://react.dev/errors/", "http://fb.me/", "https://fb.me/"}
+	// Static assets are paths, but not endpoints.
+	staticAssetExtensions = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".ico": true, ".css": true, ".woff": true, ".woff2": true, ".ttf": true, ".eot": true, ".map": true}
+)
+
+// FoundEndpoint is an endpoint found by ExtractEndpoints.
+type FoundEndpoint struct {
+	Kind  string
+	Value string
+}
+
+// ExtractEndpoints finds the endpoints a script references: full URLs, quoted
+// absolute paths, named GraphQL operations, and the string targets of fetch,
+// axios and XMLHttpRequest.open calls. They are returned once each, sorted by
+// value.
+func ExtractEndpoints(content string) []FoundEndpoint {
+	found := make(map[string]string)
+	add := func(kind, value string) {
+		value = strings.TrimSpace(value)
+		if value == "" || len(value) > maxEndpointLength || len(found) >= maxEndpointsPerURL {
+			return
+		}
+		if _, seen := found[value]; !seen {
+			found[value] = kind
+		}
+	}
+
+	for _, match := range fetchPattern.FindAllStringSubmatch(content, -1) {
+		add(EndpointCall, "fetch "+firstGroup(match[1:]))
+	}
+	for _, match := range axiosPattern.FindAllStringSubmatch(content, -1) {
+		call := "axios"
+		if match[1] != "" {
+			call += "." + match[1]
+		}
+		add(EndpointCall, call+" "+firstGroup(match[2:]))
+	}
+	for _, match := range xhrOpenPattern.FindAllStringSubmatch(content, -1) {
+		add(EndpointCall, "xhr "+match[1]+" "+firstGroup(match[2:]))
+	}
+	for _, match := range graphQLPattern.FindAllStringSubmatch(content, -1) {
+		add(EndpointGraphQL, match[1]+" "+match[2])
+	}
+	for _, match := range fullURLPattern.FindAllString(content, -1) {
+		if value := strings.TrimRight(match, ".,;:"); !isEndpointNoise(value) {
+			add(EndpointURL, value)
+		}
+	}
+	for _, match := range quotedPathPattern.FindAllStringSubmatch(content, -1) {
+		if isEndpointPath(match[1]) {
+			add(EndpointPath, match[1])
+		}
+	}
+
+	endpoints := make([]FoundEndpoint, 0, len(found))
+	for value, kind := range found {
+		endpoints = append(endpoints, FoundEndpoint{Kind: kind, Value: value})
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Value < endpoints[j].Value })
+	return endpoints
+}
+
+func firstGroup(groups []string) string {
+	for _, group := range groups {
+		if group != "" {
+			return group
+		}
+	}
+	return ""
+}
+
+func isEndpointNoise(value string) bool {
+	for _, prefix := range endpointNoise {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEndpointPath filters quoted strings that start with a slash down to
+// likely paths: no protocol-relative URLs, no static assets, and at least two
+// letters, which rules out most regular expressions and number formats.
+func isEndpointPath(value string) bool {
+	if strings.HasPrefix(value, "//") || len(value) < 3 {
+		return false
+	}
+	p := value
+	if i := strings.Index(p, "?"); i >= 0 {
+		p = p[:i]
+	}
+	if staticAssetExtensions[strings.ToLower(path.Ext(p))] {
+		return false
+	}
+	letters := 0
+	for _, r := range p {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			letters++
+		}
+	}
+	return letters >= 2
+}
+
+// recordEndpoints updates the stored endpoints of a URL to those in content,
+// keeping when each was first seen. It returns the endpoints that were added
+// and removed, except on the URL's first analysis, which only sets the
+// baseline. The entry's EndpointsAnalyzedAt is set; the caller saves it.
+func recordEndpoints(db *gorm.DB, urlEntry *models.WatchedUrl, content string, now time.Time) (added, removed []string, err error) {
+	var stored []models.Endpoint
+	if result := db.Select("id", "value").Where("url_id = ?", urlEntry.ID).Find(&stored); result.Error != nil {
+		return nil, nil, result.Error
+	}
+	current := ExtractEndpoints(content)
+
+	inCurrent := make(map[string]bool, len(current))
+	for _, endpoint := range current {
+		inCurrent[endpoint.Value] = true
+	}
+	inStored := make(map[string]bool, len(stored))
+	var removedIDs []uint
+	for _, endpoint := range stored {
+		inStored[endpoint.Value] = true
+		if !inCurrent[endpoint.Value] {
+			removedIDs = append(removedIDs, endpoint.ID)
+			removed = append(removed, endpoint.Value)
+		}
+	}
+	var newRows []models.Endpoint
+	for _, endpoint := range current {
+		if !inStored[endpoint.Value] {
+			newRows = append(newRows, models.Endpoint{URLID: urlEntry.ID, Kind: endpoint.Kind, Value: endpoint.Value, FirstSeenAt: now})
+			added = append(added, endpoint.Value)
+		}
+	}
+	sort.Strings(removed)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if len(removedIDs) > 0 {
+			if result := tx.Where("id IN ?", removedIDs).Delete(&models.Endpoint{}); result.Error != nil {
+				return result.Error
+			}
+		}
+		if len(newRows) > 0 {
+			return tx.CreateInBatches(newRows, 100).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	baseline := urlEntry.EndpointsAnalyzedAt == nil
+	urlEntry.EndpointsAnalyzedAt = &now
+	if baseline {
+		return nil, nil, nil
+	}
+	return added, removed, nil
+}
+
+// EndpointFilter narrows down the endpoint inventory.
+type EndpointFilter struct {
+	Search  string // Part of the value, case-insensitive
+	Kind    string
+	GroupID *uint // Only URLs in this group
+	NoGroup bool  // Only URLs without a group
+	URLID   uint
+}
+
+// EndpointRow is an endpoint in the inventory, with the URL it was found in.
+type EndpointRow struct {
+	Kind        string
+	Value       string
+	FirstSeenAt time.Time
+	URLID       uint
+	URL         string
+	GroupID     *uint
+	GroupName   string
+}
+
+// EndpointRowColumns selects an EndpointRow from EndpointInventory.
+var EndpointRowColumns = []string{
+	"endpoints.kind", "endpoints.value", "endpoints.first_seen_at", "endpoints.url_id",
+	"watched_urls.url AS url", "watched_urls.group_id AS group_id", "url_groups.name AS group_name",
+}
+
+// EndpointInventory queries the endpoints of every live URL matching the
+// filter. Select EndpointRowColumns to scan the result into EndpointRows.
+func EndpointInventory(db *gorm.DB, filter EndpointFilter) *gorm.DB {
+	query := db.Table("endpoints").
+		Joins("JOIN watched_urls ON watched_urls.id = endpoints.url_id AND watched_urls.deleted_at IS NULL").
+		Joins("LEFT JOIN url_groups ON url_groups.id = watched_urls.group_id")
+	if filter.Search != "" {
+		query = query.Where("LOWER(endpoints.value) LIKE ?", "%"+strings.ToLower(filter.Search)+"%")
+	}
+	if filter.Kind != "" {
+		query = query.Where("endpoints.kind = ?", filter.Kind)
+	}
+	if filter.GroupID != nil {
+		query = query.Where("watched_urls.group_id = ?", *filter.GroupID)
+	} else if filter.NoGroup {
+		query = query.Where("watched_urls.group_id IS NULL")
+	}
+	if filter.URLID != 0 {
+		query = query.Where("endpoints.url_id = ?", filter.URLID)
+	}
+	return query
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go-js-watcher/models"
+)
+
+func TestExtractEndpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []FoundEndpoint
+	}{
+		{
+			name:    "fetch",
+			content: `fetch("/api/v1/users");fetch('/api/v1/teams',{method:"POST"});fetch(` + "`/api/v1/items/${id}`" + `)`,
+			want: []FoundEndpoint{
+				{EndpointPath, "/api/v1/items/${id}"},
+				{EndpointPath, "/api/v1/teams"},
+				{EndpointPath, "/api/v1/users"},
+				{EndpointCall, "fetch /api/v1/items/${id}"},
+				{EndpointCall, "fetch /api/v1/teams"},
+				{EndpointCall, "fetch /api/v1/users"},
+			},
+		},
+		{
+			name:    "axios",
+			content: `axios.post("/api/login",d);axios("/api/me")`,
+			want: []FoundEndpoint{
+				{EndpointPath, "/api/login"},
+				{EndpointPath, "/api/me"},
+				{EndpointCall, "axios /api/me"},
+				{EndpointCall, "axios.post /api/login"},
+			},
+		},
+		{
+			name:    "xhr",
+			content: `x.open("DELETE", u);x.open('GET','/legacy/report')`,
+			want: []FoundEndpoint{
+				{EndpointPath, "/legacy/report"},
+				{EndpointCall, "xhr GET /legacy/report"},
+			},
+		},
+		{
+			name:    "graphql",
+			content: "const q=gql`query GetUser($id: ID!) { user(id: $id) { name } }`;const m=`mutation UpdateUser{ok}`;",
+			want: []FoundEndpoint{
+				{EndpointGraphQL, "mutation UpdateUser"},
+				{EndpointGraphQL, "query GetUser"},
+			},
+		},
+		{
+			name:    "full urls",
+			content: `const a="https://api.example.com/v2/orders?limit=10";// see http://internal.example.com:8080/health.` + ` x="https://www.w3.org/2000/svg"`,
+			want: []FoundEndpoint{
+				{EndpointURL, "http://internal.example.com:8080/health"},
+				{EndpointURL, "https://api.example.com/v2/orders?limit=10"},
+			},
+		},
+		{
+			name:    "paths skip assets, regexes and protocol-relative urls",
+			content: `a="/static/logo.svg";b="/";c="/\d+/";d="//cdn.example.com/x";e="/admin/users?page=1";f="/app.css?v=2"`,
+			want: []FoundEndpoint{
+				{EndpointPath, "/admin/users?page=1"},
+			},
+		},
+		{
+			name:    "duplicates",
+			content: `x="https://api.example.com/a";y="https://api.example.com/a";`,
+			want: []FoundEndpoint{
+				{EndpointURL, "https://api.example.com/a"},
+			},
+		},
+		{
+			name:    "nothing",
+			content: `var a=1+2;`,
+			want:    []FoundEndpoint{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractEndpoints(tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractEndpoints() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsEndpointPath(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"/api/v1/users", true},
+		{"/api/users/{id}", true},
+		{"/search?q=x", true},
+		{"/v2", false},
+		{"/me", true},
+		{"/", false},
+		{"/a", false},
+		{"/1/2/3", false},
+		{"//cdn.example.com/lib.js", false},
+		{"/img/logo.PNG", false},
+		{"/fonts/a.woff2", false},
+		{"/main.css?v=1", false},
+		{"/app.js.map", false},
+		{"/app.js", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := isEndpointPath(tt.value); got != tt.want {
+				t.Errorf("isEndpointPath(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordEndpoints(t *testing.T) {
+	db := newTestDB(t)
+	entry := models.WatchedUrl{URL: "https://example.com/app.js", IntervalSeconds: 60}
+	if err := db.Create(&entry).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		content     string
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		// The first analysis is the baseline.
+		{`fetch("/api/a")`, nil, nil},
+		{`fetch("/api/a");fetch("/api/b")`, []string{"/api/b", "fetch /api/b"}, nil},
+		{`fetch("/api/b")`, nil, []string{"/api/a", "fetch /api/a"}},
+	}
+	for i, step := range steps {
+		added, removed, err := recordEndpoints(db, &entry, step.content, time.Now().UTC())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(added, step.wantAdded) || !reflect.DeepEqual(removed, step.wantRemoved) {
+			t.Errorf("step %d: added %q, removed %q, want %q, %q", i, added, removed, step.wantAdded, step.wantRemoved)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Extraction tools. The built-in extractor runs in-process and fetches through
+// FetchClient; getJS and jsxtract are optional external fallbacks.
+const (
+	ExtractToolBuiltin  = "builtin"
+	ExtractToolGetJS    = "getJS"
+	ExtractToolJSXtract = "jsxtract"
+)
+
+const (
+	// maxExtractPageSize caps how much of a page the built-in extractor reads.
+	maxExtractPageSize = 10 << 20
+	// extractToolTimeout bounds a run of an external extraction tool.
+	extractToolTimeout = 2 * time.Minute
+)
+
+var (
+	// import("./chunk.js") and static imports in inline module scripts.
+	dynamicImportPattern = regexp.MustCompile("\\bimport\\s*\\(\\s*[\"'`]([^\"'`\\s]+)[\"'`]\\s*\\)")
+	staticImportPattern  = regexp.MustCompile("\\bimport\\s+(?:[\\w*{}\\s,$]+?\\s+from\\s+)?[\"']([^\"'\\s]+)[\"']")
+	// importScripts("a.js", "b.js") takes any number of URLs.
+	importScriptsPattern = regexp.MustCompile(`\bimportScripts\s*\(([^)]*)\)`)
+	quotedStringPattern  = regexp.MustCompile("[\"'`]([^\"'`\\s]+)[\"'`]")
+)
+
+// AvailableExtractTools lists the extraction tools that can be used: the
+// built-in extractor, then the external tools found in PATH.
+func AvailableExtractTools() []string {
+	tools := []string{ExtractToolBuiltin}
+	for _, tool := range []string{ExtractToolGetJS, ExtractToolJSXtract} {
+		if _, err := exec.LookPath(tool); err == nil {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
+
+// ExternalToolsAllowed reports whether getJS and jsxtract may extract from a
+// target. They make their own connections, which the target policy can only
+// check once up front; a DNS answer that changes or a redirect gets past
+// that. So they only run when no networks are blocked, or when an admin
+// allowed the target to reach them anyway.
+func ExternalToolsAllowed(allowPrivate bool) bool {
+	return allowPrivate || len(BlockedNetworks) == 0
+}
+
+// ExtractScripts returns the JavaScript URLs referenced by a page, using the
+// given tool. pageURL must already have passed CheckTargetURL. The external
+// tools are refused unless ExternalToolsAllowed.
+func ExtractScripts(pageURL, tool string, allowPrivate bool) ([]string, error) {
+	if (tool == ExtractToolGetJS || tool == ExtractToolJSXtract) && !ExternalToolsAllowed(allowPrivate) {
+		return nil, fmt.Errorf("%s makes its own connections, which the fetch target policy can't check; use the built-in extractor, or have an admin allow private and internal addresses for this target", tool)
+	}
+	switch tool {
+	case ExtractToolBuiltin, "":
+		return extractBuiltin(pageURL, allowPrivate)
+	case ExtractToolGetJS:
+		return runExtractTool(tool, "-url", pageURL)
+	case ExtractToolJSXtract:
+		return runExtractTool(tool, pageURL)
+	default:
+		return nil, fmt.Errorf("unknown extraction tool %q", tool)
+	}
+}
+
+func extractBuiltin(pageURL string, allowPrivate bool) ([]string, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8")
+
+	resp, err := FetchClient(allowPrivate).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching the page returned %s", resp.Status)
+	}
+	// Relative URLs resolve against the final URL after redirects.
+	return ParseScriptURLs(resp.Request.URL, io.LimitReader(resp.Body, maxExtractPageSize))
+}
+
+// ParseScriptURLs finds the scripts an HTML document loads: <script src>,
+// <link rel="modulepreload"> and <link rel="preload" as="script">, plus
+// import() calls, static imports and importScripts() calls in inline scripts.
+// URLs are resolved against pageURL or the document's <base href>, and
+// returned once each in the order they appear. Bare module specifiers
+// ("react") and non-HTTP URLs are skipped.
+func ParseScriptURLs(pageURL *url.URL, body io.Reader) ([]string, error) {
+	var refs []string
+	var baseHref string
+	inScript := false
+
+	tokenizer := html.NewTokenizer(body)
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return nil, err
+			}
+			return resolveScriptRefs(pageURL, baseHref, refs), nil
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "script":
+				if src := attr(token, "src"); src != "" {
+					refs = append(refs, src)
+				} else if tokenType == html.StartTagToken {
+					inScript = true
+				}
+			case "link":
+				rel := strings.Fields(strings.ToLower(attr(token, "rel")))
+				if containsString(rel, "modulepreload") ||
+					(containsString(rel, "preload") && strings.EqualFold(attr(token, "as"), "script")) {
+					if href := attr(token, "href"); href != "" {
+						refs = append(refs, href)
+					}
+				}
+			case "base":
+				if baseHref == "" {
+					baseHref = attr(token, "href")
+				}
+			}
+
+		case html.TextToken:
+			if inScript {
+				refs = append(refs, inlineScriptRefs(string(tokenizer.Text()))...)
+			}
+
+		case html.EndTagToken:
+			inScript = false
+		}
+	}
+}
+
+// inlineScriptRefs finds the URLs an inline script imports or loads.
+func inlineScriptRefs(script string) []string {
+	var refs []string
+	for _, pattern := range []*regexp.Regexp{dynamicImportPattern, staticImportPattern} {
+		for _, match := range pattern.FindAllStringSubmatch(script, -1) {
+			if !isBareSpecifier(match[1]) {
+				refs = append(refs, match[1])
+			}
+		}
+	}
+	for _, match := range importScriptsPattern.FindAllStringSubmatch(script, -1) {
+		for _, arg := range quotedStringPattern.FindAllStringSubmatch(match[1], -1) {
+			refs = append(refs, arg[1])
+		}
+	}
+	return refs
+}
+
+// resolveScriptRefs turns references into absolute, de-duplicated HTTP(S) URLs.
+func resolveScriptRefs(pageURL *url.URL, baseHref string, refs []string) []string {
+	base := pageURL
+	if baseHref != "" {
+		if parsed, err := pageURL.Parse(strings.TrimSpace(baseHref)); err == nil {
+			base = parsed
+		}
+	}
+
+	seen := make(map[string]bool)
+	var scripts []string
+	for _, ref := range refs {
+		resolved, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil || (resolved.Scheme != "http" && resolved.Scheme != "https") {
+			continue
+		}
+		resolved.Fragment = ""
+		if script := resolved.String(); !seen[script] {
+			seen[script] = true
+			scripts = append(scripts, script)
+		}
+	}
+	return scripts
+}
+
+// isBareSpecifier reports whether an import specifier names a package rather
+// than a URL. Only an import map could resolve it.
+func isBareSpecifier(ref string) bool {
+	if strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, "./") || strings.HasPrefix(ref, "../") {
+		return false
+	}
+	parsed, err := url.Parse(ref)
+	return err != nil || parsed.Scheme == ""
+}
+
+func attr(token html.Token, name string) string {
+	for _, a := range token.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func containsString(values []string, want string) bool {
+	for _, value := range values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+// runExtractTool runs an external extraction tool and returns the URLs it
+// prints, one per line.
+func runExtractTool(tool string, args ...string) ([]string, error) {
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not installed or not in PATH", tool)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), extractToolTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, path, args...).Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s timed out after %v", tool, extractToolTimeout)
+	}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("%s failed: %v: %s", tool, err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("%s failed: %v", tool, err)
+	}
+
+	seen := make(map[string]bool)
+	var scripts []string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !seen[line] {
+			seen[line] = true
+			scripts = append(scripts, line)
+		}
+	}
+	return scripts, nil
+}
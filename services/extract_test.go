@@ -0,0 +1,114 @@
+package services
+
+import (
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseScriptURLs(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		html string
+		want []string
+	}{
+		{
+			name: "script src resolves against the page",
+			page: "https://example.com/app/index.html",
+			html: `<script src="main.js"></script><script src="/vendor.js"></script><script src="https://cdn.example.net/lib.js"></script>`,
+			want: []string{"https://example.com/app/main.js", "https://example.com/vendor.js", "https://cdn.example.net/lib.js"},
+		},
+		{
+			name: "protocol-relative src",
+			page: "https://example.com/",
+			html: `<script src="//cdn.example.net/a.js"></script>`,
+			want: []string{"https://cdn.example.net/a.js"},
+		},
+		{
+			name: "preload links",
+			page: "https://example.com/",
+			html: `<link rel="modulepreload" href="/m.js"><link rel="preload" as="script" href="/p.js"><link rel="Preload" as="SCRIPT" href="/q.js"><link rel="preload" as="style" href="/s.css"><link rel="stylesheet" href="/t.css">`,
+			want: []string{"https://example.com/m.js", "https://example.com/p.js", "https://example.com/q.js"},
+		},
+		{
+			name: "base href",
+			page: "https://example.com/a/b.html",
+			html: `<head><base href="https://static.example.com/assets/"><base href="/ignored/"></head><script src="app.js"></script>`,
+			want: []string{"https://static.example.com/assets/app.js"},
+		},
+		{
+			name: "inline imports",
+			page: "https://example.com/",
+			html: "<script type=\"module\">import \"/side-effect.js\"; import { a, b } from './named.js'; import * as ns from \"../ns.js\"; const m = import(`/lazy.js`);</script>",
+			want: []string{"https://example.com/lazy.js", "https://example.com/side-effect.js", "https://example.com/named.js", "https://example.com/ns.js"},
+		},
+		{
+			name: "importScripts",
+			page: "https://example.com/w/",
+			html: `<script>importScripts("a.js", 'https://cdn.example.net/b.js')</script>`,
+			want: []string{"https://example.com/w/a.js", "https://cdn.example.net/b.js"},
+		},
+		{
+			name: "bare specifiers are skipped",
+			page: "https://example.com/",
+			html: `<script type="module">import React from "react"; import("lodash/debounce"); import "/own.js";</script>`,
+			want: []string{"https://example.com/own.js"},
+		},
+		{
+			name: "non-http urls are skipped",
+			page: "https://example.com/",
+			html: `<script src="data:text/javascript,alert(1)"></script><script src="javascript:void(0)"></script><script src="ftp://example.com/a.js"></script><script src="/ok.js"></script>`,
+			want: []string{"https://example.com/ok.js"},
+		},
+		{
+			name: "duplicates are dropped and fragments ignored",
+			page: "https://example.com/",
+			html: `<script src="/a.js"></script><link rel="modulepreload" href="/a.js#x"><script src="/b.js"></script><script src="a.js"></script>`,
+			want: []string{"https://example.com/a.js", "https://example.com/b.js"},
+		},
+		{
+			name: "text outside scripts is not parsed",
+			page: "https://example.com/",
+			html: `<p>import("/not-a-script.js")</p><script src="/a.js"></script>`,
+			want: []string{"https://example.com/a.js"},
+		},
+		{
+			name: "no scripts",
+			page: "https://example.com/",
+			html: `<html><body>hi</body></html>`,
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := url.Parse(tt.page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseScriptURLs(page, strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseScriptURLs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractScriptsRefusesExternalTools(t *testing.T) {
+	defer func(networks []*net.IPNet) { BlockedNetworks = networks }(BlockedNetworks)
+	BlockedNetworks = mustParseNetworks(DefaultBlockedNetworks)
+
+	for _, tool := range []string{ExtractToolGetJS, ExtractToolJSXtract} {
+		t.Run(tool, func(t *testing.T) {
+			_, err := ExtractScripts("https://example.com/", tool, false)
+			if err == nil || !strings.Contains(err.Error(), "fetch target policy") {
+				t.Errorf("ExtractScripts() error = %v, want it refused by the target policy", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"go-js-watcher/models"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of ignore rules.
+const (
+	IgnoreRuleRegex = "regex" // Replace every match of the pattern with the replacement
+	IgnoreRuleLine  = "line"  // Drop every line that matches the pattern
+)
+
+// ValidateIgnoreRule checks that a rule has a known kind and a pattern that compiles.
+func ValidateIgnoreRule(rule models.IgnoreRule) error {
+	if rule.Kind != IgnoreRuleRegex && rule.Kind != IgnoreRuleLine {
+		return fmt.Errorf("unknown rule type %q", rule.Kind)
+	}
+	if rule.Pattern == "" {
+		return fmt.Errorf("pattern is required")
+	}
+	if _, err := regexp.Compile(rule.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	return nil
+}
+
+// LoadIgnoreRules returns the rules that apply to a URL: its own rules followed
+// by the rules of its group.
+func LoadIgnoreRules(db *gorm.DB, urlEntry models.WatchedUrl) ([]models.IgnoreRule, error) {
+	query := db.Where("url_id = ?", urlEntry.ID)
+	if urlEntry.GroupID != nil {
+		query = query.Or("group_id = ?", *urlEntry.GroupID)
+	}
+
+	var rules []models.IgnoreRule
+	if result := query.Order("group_id IS NOT NULL, id").Find(&rules); result.Error != nil {
+		return nil, result.Error
+	}
+	return rules, nil
+}
+
+// ApplyIgnoreRules masks content with the given rules, in order. Rules with a
+// pattern that no longer compiles are skipped.
+func ApplyIgnoreRules(content string, rules []models.IgnoreRule) string {
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			log.Printf("Skipping ignore rule %d with invalid pattern %q: %v", rule.ID, rule.Pattern, err)
+			continue
+		}
+
+		switch rule.Kind {
+		case IgnoreRuleRegex:
+			content = re.ReplaceAllString(content, rule.Replacement)
+		case IgnoreRuleLine:
+			lines := strings.Split(content, "\n")
+			kept := lines[:0]
+			for _, line := range lines {
+				if !re.MatchString(line) {
+					kept = append(kept, line)
+				}
+			}
+			content = strings.Join(kept, "\n")
+		}
+	}
+	return content
+}
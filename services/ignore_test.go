@@ -0,0 +1,92 @@
+package services
+
+import (
+	"testing"
+
+	"go-js-watcher/models"
+)
+
+func TestApplyIgnoreRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		rules   []models.IgnoreRule
+		want    string
+	}{
+		{
+			name:    "no rules",
+			content: "a\nb",
+			want:    "a\nb",
+		},
+		{
+			name:    "regex replaces every match",
+			content: `build="1700000000";v=2;build="1700000001"`,
+			rules:   []models.IgnoreRule{{Kind: IgnoreRuleRegex, Pattern: `build="\d+"`, Replacement: `build=""`}},
+			want:    `build="";v=2;build=""`,
+		},
+		{
+			name:    "regex replacement expands groups",
+			content: "nonce-abc123 nonce-def456",
+			rules:   []models.IgnoreRule{{Kind: IgnoreRuleRegex, Pattern: `(nonce)-\w+`, Replacement: "$1-X"}},
+			want:    "nonce-X nonce-X",
+		},
+		{
+			name:    "line drops matching lines",
+			content: "keep\n// generated at 12:00\nalso keep",
+			rules:   []models.IgnoreRule{{Kind: IgnoreRuleLine, Pattern: `generated at`}},
+			want:    "keep\nalso keep",
+		},
+		{
+			name:    "rules apply in order",
+			content: "ts=1\nx=1",
+			rules: []models.IgnoreRule{
+				{Kind: IgnoreRuleRegex, Pattern: `ts=\d+`, Replacement: "DROP"},
+				{Kind: IgnoreRuleLine, Pattern: `^DROP$`},
+			},
+			want: "x=1",
+		},
+		{
+			name:    "invalid pattern is skipped",
+			content: "a(b",
+			rules: []models.IgnoreRule{
+				{Kind: IgnoreRuleRegex, Pattern: `(`, Replacement: ""},
+				{Kind: IgnoreRuleRegex, Pattern: `b`, Replacement: "c"},
+			},
+			want: "a(c",
+		},
+		{
+			name:    "unknown kind does nothing",
+			content: "a",
+			rules:   []models.IgnoreRule{{Kind: "other", Pattern: `a`}},
+			want:    "a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ApplyIgnoreRules(tt.content, tt.rules); got != tt.want {
+				t.Errorf("ApplyIgnoreRules() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateIgnoreRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    models.IgnoreRule
+		wantErr bool
+	}{
+		{"regex", models.IgnoreRule{Kind: IgnoreRuleRegex, Pattern: `\d+`}, false},
+		{"line", models.IgnoreRule{Kind: IgnoreRuleLine, Pattern: `^//`}, false},
+		{"unknown kind", models.IgnoreRule{Kind: "other", Pattern: `x`}, true},
+		{"empty pattern", models.IgnoreRule{Kind: IgnoreRuleRegex}, true},
+		{"invalid pattern", models.IgnoreRule{Kind: IgnoreRuleLine, Pattern: `[`}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateIgnoreRule(tt.rule); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateIgnoreRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
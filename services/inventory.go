@@ -0,0 +1,206 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go-js-watcher/models"
+
+	"gorm.io/gorm"
+)
+
+// MaxReextractHours bounds URLGroup.ReextractHours to 30 days.
+const MaxReextractHours = 24 * 30
+
+// defaultScriptInterval is used for auto-watched scripts of a group that has
+// neither a ScriptInterval nor any URLs to copy one from.
+const defaultScriptInterval = 300
+
+// ErrExtractionRunning is returned when a group is already being re-extracted.
+var ErrExtractionRunning = errors.New("this group is already being re-extracted")
+
+// ReextractGroup extracts the scripts of a group's source page again and
+// compares them with the previous extraction. Added and removed scripts are
+// recorded as an InventoryChange and notified, and added scripts are watched
+// if the group has AutoWatchNewScripts. The returned change is nil when the
+// inventory is unchanged. baseURL is used for the link in notifications.
+func ReextractGroup(db *gorm.DB, groupID uint, baseURL string) (*models.InventoryChange, error) {
+	var group models.URLGroup
+	if result := db.First(&group, groupID); result.Error != nil {
+		return nil, result.Error
+	}
+	now := time.Now().UTC()
+
+	scripts, err := extractGroupScripts(group)
+	if err != nil {
+		status := fmt.Sprintf("Extraction failed: %v", err)
+		updateExtractStatus(db, group.ID, map[string]interface{}{"last_extracted_at": now, "extract_status": status})
+		log.Printf("Re-extraction of group %d (%s) failed: %v", group.ID, group.SourceURL, err)
+		return nil, err
+	}
+
+	previous, err := previousInventory(db, group)
+	if err != nil {
+		log.Printf("Error loading the inventory of group %d: %v", group.ID, err)
+		return nil, err
+	}
+	added, removed := diffInventory(previous, scripts)
+
+	var watched []string
+	if group.AutoWatchNewScripts && len(added) > 0 {
+		watched, err = watchNewScripts(db, group, added)
+		if err != nil {
+			log.Printf("Error watching new scripts of group %d: %v", group.ID, err)
+		}
+	}
+
+	status := fmt.Sprintf("Found %d scripts, no changes", len(scripts))
+	if len(added) > 0 || len(removed) > 0 {
+		status = fmt.Sprintf("Found %d scripts: %d added, %d removed", len(scripts), len(added), len(removed))
+	}
+	updateExtractStatus(db, group.ID, map[string]interface{}{
+		"inventory":         strings.Join(scripts, "\n"),
+		"last_extracted_at": now,
+		"extract_status":    status,
+	})
+	log.Printf("Re-extracted group %d (%s): %s", group.ID, group.SourceURL, status)
+
+	if len(added) == 0 && len(removed) == 0 {
+		return nil, nil
+	}
+	change := models.InventoryChange{
+		GroupID:    group.ID,
+		DetectedAt: now,
+		Added:      strings.Join(added, "\n"),
+		Removed:    strings.Join(removed, "\n"),
+		Watched:    strings.Join(watched, "\n"),
+	}
+	if result := db.Create(&change); result.Error != nil {
+		log.Printf("Error recording inventory change of group %d: %v", group.ID, result.Error)
+		return nil, result.Error
+	}
+
+	link := ""
+	if baseURL != "" {
+		link = fmt.Sprintf("%s/group/%d", baseURL, group.ID)
+	}
+	notify(Notification{
+		Event:          EventInventory,
+		URL:            group.SourceURL,
+		Link:           link,
+		AddedScripts:   added,
+		RemovedScripts: removed,
+		WatchedScripts: watched,
+		Time:           now,
+	})
+	return &change, nil
+}
+
+// extractGroupScripts runs the group's extraction tool against its source URL.
+func extractGroupScripts(group models.URLGroup) ([]string, error) {
+	if err := CheckTargetURL(group.SourceURL, group.AllowPrivateTarget); err != nil {
+		return nil, err
+	}
+	return ExtractScripts(group.SourceURL, group.ExtractTool, group.AllowPrivateTarget)
+}
+
+// previousInventory returns the scripts found by the group's last extraction.
+// Groups extracted before inventories were kept compare against the URLs
+// they watch instead.
+func previousInventory(db *gorm.DB, group models.URLGroup) ([]string, error) {
+	if group.Inventory != "" || group.LastExtractedAt != nil {
+		return group.InventoryURLs(), nil
+	}
+	var urls []string
+	result := db.Model(&models.WatchedUrl{}).Where("group_id = ?", group.ID).Order("id").Pluck("url", &urls)
+	return urls, result.Error
+}
+
+// diffInventory lists the scripts only in current (added) and only in
+// previous (removed), each in its original order.
+func diffInventory(previous, current []string) (added, removed []string) {
+	inPrevious := make(map[string]bool, len(previous))
+	for _, script := range previous {
+		inPrevious[script] = true
+	}
+	inCurrent := make(map[string]bool, len(current))
+	for _, script := range current {
+		inCurrent[script] = true
+		if !inPrevious[script] {
+			added = append(added, script)
+		}
+	}
+	for _, script := range previous {
+		if !inCurrent[script] {
+			removed = append(removed, script)
+		}
+	}
+	return added, removed
+}
+
+// watchNewScripts adds scripts to the group with its settings for new
+// scripts. Scripts that are already watched or archived anywhere, or that
+// the fetch target policy blocks, are skipped.
+func watchNewScripts(db *gorm.DB, group models.URLGroup, scripts []string) ([]string, error) {
+	interval := group.ScriptInterval
+	if interval <= 0 {
+		var newest models.WatchedUrl
+		if db.Select("interval_seconds").Where("group_id = ?", group.ID).Order("id DESC").First(&newest).Error == nil {
+			interval = newest.IntervalSeconds
+		}
+	}
+	if interval <= 0 {
+		interval = defaultScriptInterval
+	}
+	groupID := group.ID
+	return watchScripts(db, models.WatchedUrl{
+		IntervalSeconds:    interval,
+		GroupID:            &groupID,
+		Beautify:           group.ScriptBeautify,
+		AllowPrivateTarget: group.AllowPrivateTarget,
+	}, scripts)
+}
+
+// watchScripts watches each script with the interval, group and flags of
+// settings, skipping scripts that are already watched or archived anywhere or
+// that the fetch target policy blocks. It returns the scripts it added.
+func watchScripts(db *gorm.DB, settings models.WatchedUrl, scripts []string) ([]string, error) {
+	var watched []string
+	for _, script := range scripts {
+		if err := CheckTargetURL(script, settings.AllowPrivateTarget); err != nil {
+			log.Printf("Not watching new script %s: %v", script, err)
+			continue
+		}
+		var count int64
+		if result := db.Unscoped().Model(&models.WatchedUrl{}).Where("url = ?", script).Count(&count); result.Error != nil {
+			return watched, result.Error
+		} else if count > 0 {
+			continue
+		}
+		newURL := models.WatchedUrl{
+			URL:                script,
+			IntervalSeconds:    settings.IntervalSeconds,
+			Status:             "Scheduled for first check",
+			GroupID:            settings.GroupID,
+			Beautify:           settings.Beautify,
+			AllowPrivateTarget: settings.AllowPrivateTarget,
+		}
+		if result := db.Create(&newURL); result.Error != nil {
+			return watched, result.Error
+		}
+		TriggerCheck(newURL.ID)
+		watched = append(watched, script)
+	}
+	return watched, nil
+}
+
+// updateExtractStatus stores the outcome of an extraction. It updates the
+// columns directly so a group archived in the meantime stays archived.
+func updateExtractStatus(db *gorm.DB, groupID uint, columns map[string]interface{}) {
+	if result := db.Model(&models.URLGroup{}).Where("id = ?", groupID).Updates(columns); result.Error != nil {
+		log.Printf("Error saving extraction status of group %d: %v", groupID, result.Error)
+	}
+}
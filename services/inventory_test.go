@@ -0,0 +1,75 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go-js-watcher/models"
+)
+
+func TestDiffInventory(t *testing.T) {
+	added, removed := diffInventory([]string{"a.js", "b.js", "c.js"}, []string{"c.js", "d.js", "a.js", "e.js"})
+	if want := []string{"d.js", "e.js"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %q, want %q", added, want)
+	}
+	if want := []string{"b.js"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed = %q, want %q", removed, want)
+	}
+}
+
+func TestReextractGroup(t *testing.T) {
+	defer func(saved []Notifier) { notifiers = saved }(notifiers)
+	recorder := &recordingNotifier{}
+	notifiers = []Notifier{recorder}
+
+	scripts := []string{"/static/app.js", "/static/new.js"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, script := range scripts {
+			fmt.Fprintf(w, `<script src="%s"></script>`, script)
+		}
+	}))
+	defer server.Close()
+
+	db := newTestDB(t)
+	group := models.URLGroup{
+		Name:                "app",
+		SourceURL:           server.URL + "/",
+		Inventory:           server.URL + "/static/app.js\n" + server.URL + "/static/old.js",
+		AutoWatchNewScripts: true,
+		ScriptInterval:      120,
+		AllowPrivateTarget:  true, // The test server listens on loopback
+	}
+	if err := db.Create(&group).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	change, err := ReextractGroup(db, group.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change == nil {
+		t.Fatal("no inventory change recorded")
+	}
+	newScript := server.URL + "/static/new.js"
+	if change.Added != newScript || change.Removed != server.URL+"/static/old.js" || change.Watched != newScript {
+		t.Errorf("change = added %q, removed %q, watched %q", change.Added, change.Removed, change.Watched)
+	}
+	if want := []string{EventInventory}; !reflect.DeepEqual(recorder.events, want) {
+		t.Errorf("notified %q, want %q", recorder.events, want)
+	}
+	var watched models.WatchedUrl
+	if err := db.Where("url = ?", newScript).First(&watched).Error; err != nil {
+		t.Fatalf("new script not watched: %v", err)
+	}
+	if watched.GroupID == nil || *watched.GroupID != group.ID || watched.IntervalSeconds != 120 || !watched.AllowPrivateTarget {
+		t.Errorf("new script watched with group %v, interval %d, private %v", watched.GroupID, watched.IntervalSeconds, watched.AllowPrivateTarget)
+	}
+
+	// The new inventory is the baseline of the next re-extraction.
+	if change, err := ReextractGroup(db, group.ID, ""); err != nil || change != nil {
+		t.Errorf("second re-extraction = %+v, %v, want no change", change, err)
+	}
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go-js-watcher/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// LoginMaxFailures is how many failed logins for one username or from one
+	// IP lock further attempts out for LoginLockout. Before that, each failure
+	// doubles the wait before the next attempt, starting at one second.
+	LoginMaxFailures int64 = 5
+	// LoginLockout is how long a lockout lasts, and how far back failures count.
+	LoginLockout = 15 * time.Minute
+	// LoginAlertAfter sends a notification when a username or IP reaches this
+	// many recent failures. 0 disables the alert.
+	LoginAlertAfter int64 = 0
+)
+
+const (
+	// loginAttemptRetention is how long the login log is kept.
+	loginAttemptRetention = 30 * 24 * time.Hour
+	// loginCheckingReason marks an attempt reserved by ReserveLoginAttempt
+	// whose password is still being checked.
+	loginCheckingReason = "checking credentials"
+)
+
+// loginReservations makes checking LoginWait and reserving an attempt one
+// step, so parallel attempts can't all pass the check before any of them is
+// counted.
+var loginReservations sync.Mutex
+
+// LoginWait returns how long the given username and IP must wait before
+// another login attempt is allowed. Zero means the attempt may go ahead.
+func LoginWait(db *gorm.DB, username, ip string) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range []struct{ column, value string }{{"username", username}, {"ip", ip}} {
+		failures, last, err := recentLoginFailures(db, key.column, key.value)
+		if err != nil {
+			return 0, err
+		}
+		if failures == 0 {
+			continue
+		}
+		delay := LoginLockout
+		if failures < LoginMaxFailures && failures < 32 {
+			if backoff := time.Second << (failures - 1); backoff < delay {
+				delay = backoff
+			}
+		}
+		if remaining := time.Until(last.Add(delay)); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+// ReserveLoginAttempt counts an attempt as a failure before its credentials
+// are checked, so that parallel attempts already see it, and returns it for
+// FinishLoginAttempt or ReleaseLoginAttempt. If recent failures require the
+// attempt to wait, nothing is reserved and the wait is returned instead.
+func ReserveLoginAttempt(db *gorm.DB, attempt models.LoginAttempt) (*models.LoginAttempt, time.Duration, error) {
+	loginReservations.Lock()
+	defer loginReservations.Unlock()
+
+	wait, err := LoginWait(db, attempt.Username, attempt.IP)
+	if err != nil || wait > 0 {
+		return nil, wait, err
+	}
+	attempt.CreatedAt = time.Now().UTC()
+	attempt.Result = models.LoginFailure
+	attempt.Reason = loginCheckingReason
+	if result := db.Create(&attempt); result.Error != nil {
+		return nil, 0, result.Error
+	}
+	return &attempt, 0, nil
+}
+
+// FinishLoginAttempt records the outcome of a reserved attempt, like
+// RecordLoginAttempt does for a new one.
+func FinishLoginAttempt(db *gorm.DB, attempt *models.LoginAttempt, result, reason string) {
+	attempt.Result = result
+	attempt.Reason = reason
+	if res := db.Model(attempt).Updates(map[string]interface{}{"result": result, "reason": reason}); res.Error != nil {
+		log.Printf("Error recording login attempt for %q from %s: %v", attempt.Username, attempt.IP, res.Error)
+		return
+	}
+	afterLoginAttempt(db, *attempt)
+}
+
+// ReleaseLoginAttempt drops a reserved attempt that neither failed nor
+// completed the login, such as a correct password still waiting for its
+// two-factor code.
+func ReleaseLoginAttempt(db *gorm.DB, attempt *models.LoginAttempt) {
+	if result := db.Delete(attempt); result.Error != nil {
+		log.Printf("Error releasing login attempt for %q from %s: %v", attempt.Username, attempt.IP, result.Error)
+	}
+}
+
+// recentLoginFailures counts failures for one username or IP within the
+// lockout window, and returns the newest one. A successful login clears the
+// failures of its username. For an IP it only clears the failures of the
+// username that logged in, so that logging in to one account doesn't reset
+// the IP's count against others.
+func recentLoginFailures(db *gorm.DB, column, value string) (int64, time.Time, error) {
+	since := time.Now().UTC().Add(-LoginLockout)
+	query := db.Model(&models.LoginAttempt{})
+
+	if column == "ip" {
+		query = query.Where("NOT EXISTS (SELECT 1 FROM login_attempts AS success WHERE success.ip = login_attempts.ip AND success.username = login_attempts.username AND success.result = ? AND success.created_at > login_attempts.created_at)", models.LoginSuccess)
+	} else {
+		var lastSuccess models.LoginAttempt
+		result := db.Where(column+" = ? AND result = ? AND created_at > ?", value, models.LoginSuccess, since).
+			Order("created_at DESC").Limit(1).Find(&lastSuccess)
+		if result.Error != nil {
+			return 0, time.Time{}, result.Error
+		}
+		if result.RowsAffected > 0 {
+			since = lastSuccess.CreatedAt
+		}
+	}
+
+	query = query.Where(column+" = ? AND result = ? AND created_at > ?", value, models.LoginFailure, since)
+	var count int64
+	if result := query.Count(&count); result.Error != nil || count == 0 {
+		return 0, time.Time{}, result.Error
+	}
+	var last models.LoginAttempt
+	if result := query.Order("created_at DESC").Limit(1).Find(&last); result.Error != nil {
+		return 0, time.Time{}, result.Error
+	}
+	return count, last.CreatedAt, nil
+}
+
+// RecordLoginAttempt adds an attempt to the login log. Failures may trigger a
+// notification, and entries older than the retention period are pruned.
+func RecordLoginAttempt(db *gorm.DB, attempt models.LoginAttempt) {
+	attempt.CreatedAt = time.Now().UTC()
+	if result := db.Create(&attempt); result.Error != nil {
+		log.Printf("Error recording login attempt for %q from %s: %v", attempt.Username, attempt.IP, result.Error)
+		return
+	}
+	afterLoginAttempt(db, attempt)
+}
+
+// afterLoginAttempt prunes the login log and logs a failure, notifying when
+// the username or IP crosses LoginAlertAfter.
+func afterLoginAttempt(db *gorm.DB, attempt models.LoginAttempt) {
+	if result := db.Where("created_at < ?", attempt.CreatedAt.Add(-loginAttemptRetention)).Delete(&models.LoginAttempt{}); result.Error != nil {
+		log.Printf("Error pruning login log: %v", result.Error)
+	}
+
+	if attempt.Result != models.LoginFailure {
+		return
+	}
+	log.Printf("Failed login for %q from %s: %s", attempt.Username, attempt.IP, attempt.Reason)
+	if LoginAlertAfter <= 0 {
+		return
+	}
+	for _, key := range []struct{ column, value string }{{"username", attempt.Username}, {"ip", attempt.IP}} {
+		failures, _, err := recentLoginFailures(db, key.column, key.value)
+		if err != nil {
+			log.Printf("Error counting login failures: %v", err)
+			return
+		}
+		// Alert once when the threshold is crossed, not on every failure after it.
+		if failures == LoginAlertAfter {
+			reason := fmt.Sprintf("%d failed logins for this %s in the last %s. Last attempt: %s.", failures, key.column, LoginLockout, attempt.Reason)
+			if failures >= LoginMaxFailures {
+				reason += fmt.Sprintf(" Further attempts are locked out for %s.", LoginLockout)
+			}
+			go notify(Notification{
+				Event:          EventLoginFailures,
+				Username:       attempt.Username,
+				IP:             attempt.IP,
+				FailedAttempts: failures,
+				Reason:         reason,
+				Time:           attempt.CreatedAt,
+			})
+			return
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go-js-watcher/models"
+)
+
+func TestLoginWait(t *testing.T) {
+	type attempt struct {
+		username, ip, result string
+		age                  time.Duration
+	}
+	failures := func(n int, username, ip string) []attempt {
+		attempts := make([]attempt, n)
+		for i := range attempts {
+			attempts[i] = attempt{username, ip, models.LoginFailure, time.Duration(n-i) * time.Millisecond}
+		}
+		return attempts
+	}
+
+	tests := []struct {
+		name     string
+		attempts []attempt
+		username string
+		ip       string
+		want     time.Duration
+	}{
+		{
+			name:     "no attempts",
+			username: "alice", ip: "192.0.2.1",
+			want: 0,
+		},
+		{
+			name:     "one failure waits a second",
+			attempts: failures(1, "alice", "192.0.2.1"),
+			username: "alice", ip: "192.0.2.1",
+			want: time.Second,
+		},
+		{
+			name:     "backoff doubles",
+			attempts: failures(3, "alice", "192.0.2.1"),
+			username: "alice", ip: "192.0.2.1",
+			want: 4 * time.Second,
+		},
+		{
+			name:     "backoff is over",
+			attempts: []attempt{{"alice", "192.0.2.1", models.LoginFailure, 3 * time.Second}},
+			username: "alice", ip: "192.0.2.1",
+			want: 0,
+		},
+		{
+			name:     "lockout after max failures",
+			attempts: failures(int(LoginMaxFailures), "alice", "192.0.2.1"),
+			username: "alice", ip: "192.0.2.1",
+			want: LoginLockout,
+		},
+		{
+			name: "failures older than the lockout expire",
+			attempts: []attempt{
+				{"alice", "192.0.2.1", models.LoginFailure, LoginLockout + time.Minute},
+				{"alice", "192.0.2.1", models.LoginFailure, LoginLockout + time.Minute},
+			},
+			username: "alice", ip: "192.0.2.1",
+			want: 0,
+		},
+		{
+			name:     "username failures count from any IP",
+			attempts: failures(int(LoginMaxFailures), "alice", "198.51.100.1"),
+			username: "alice", ip: "192.0.2.1",
+			want: LoginLockout,
+		},
+		{
+			name:     "IP failures count for any username",
+			attempts: failures(int(LoginMaxFailures), "bob", "192.0.2.1"),
+			username: "alice", ip: "192.0.2.1",
+			want: LoginLockout,
+		},
+		{
+			name: "success clears the username",
+			attempts: append(failures(int(LoginMaxFailures), "alice", "198.51.100.1"),
+				attempt{"alice", "198.51.100.1", models.LoginSuccess, 0}),
+			username: "alice", ip: "192.0.2.1",
+			want: 0,
+		},
+		{
+			name: "success clears the IP's failures for the same username",
+			attempts: append(failures(int(LoginMaxFailures), "alice", "192.0.2.1"),
+				attempt{"alice", "192.0.2.1", models.LoginSuccess, 0}),
+			username: "alice", ip: "192.0.2.1",
+			want: 0,
+		},
+		{
+			name: "success for another username doesn't clear the IP",
+			attempts: append(failures(int(LoginMaxFailures), "bob", "192.0.2.1"),
+				attempt{"alice", "192.0.2.1", models.LoginSuccess, 0}),
+			username: "bob", ip: "192.0.2.1",
+			want: LoginLockout,
+		},
+		{
+			name: "success for another username doesn't clear the IP against a third",
+			attempts: append(failures(int(LoginMaxFailures), "bob", "192.0.2.1"),
+				attempt{"alice", "192.0.2.1", models.LoginSuccess, 0}),
+			username: "carol", ip: "192.0.2.1",
+			want: LoginLockout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			now := time.Now().UTC()
+			for _, a := range tt.attempts {
+				row := models.LoginAttempt{CreatedAt: now.Add(-a.age), Username: a.username, IP: a.ip, Result: a.result}
+				if err := db.Create(&row).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := LoginWait(db, tt.username, tt.ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == 0 && got != 0 || tt.want != 0 && (got <= tt.want-time.Second || got > tt.want) {
+				t.Errorf("LoginWait() = %v, want about %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReserveLoginAttempt(t *testing.T) {
+	db := newTestDB(t)
+	login := models.LoginAttempt{Username: "alice", IP: "192.0.2.1"}
+
+	attempt, wait, err := ReserveLoginAttempt(db, login)
+	if err != nil || attempt == nil || wait != 0 {
+		t.Fatalf("first attempt = %v, %v, %v, want a reservation", attempt, wait, err)
+	}
+	// The reservation already counts, so a parallel attempt has to wait.
+	if again, wait, err := ReserveLoginAttempt(db, login); err != nil || again != nil || wait <= 0 {
+		t.Fatalf("parallel attempt = %v, %v, %v, want a wait", again, wait, err)
+	}
+
+	// A released attempt no longer counts.
+	ReleaseLoginAttempt(db, attempt)
+	attempt, _, err = ReserveLoginAttempt(db, login)
+	if err != nil || attempt == nil {
+		t.Fatalf("attempt after release = %v, %v, want a reservation", attempt, err)
+	}
+
+	// Nor does one that succeeded.
+	FinishLoginAttempt(db, attempt, models.LoginSuccess, "")
+	if attempt, _, err := ReserveLoginAttempt(db, login); err != nil || attempt == nil {
+		t.Fatalf("attempt after success = %v, %v, want a reservation", attempt, err)
+	}
+}
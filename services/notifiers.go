@@ -0,0 +1,168 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	// Telegram Bot API package
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// TelegramNotifier sends notifications to a Telegram chat through a bot.
+type TelegramNotifier struct {
+	BotToken string
+	ChatID   string
+}
+
+func (t *TelegramNotifier) Name() string { return "Telegram" }
+
+func (t *TelegramNotifier) Notify(n Notification) error {
+	bot, err := tgbotapi.NewBotAPI(t.BotToken)
+	if err != nil {
+		return fmt.Errorf("failed to create Telegram bot API: %v", err)
+	}
+
+	// Telegram chat ID must be an int64. Parse it.
+	parsedChatID, err := strconv.ParseInt(t.ChatID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid Telegram Chat ID '%s': %v", t.ChatID, err)
+	}
+
+	msg := tgbotapi.NewMessage(parsedChatID, telegramText(n))
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.DisableWebPagePreview = false
+
+	_, err = bot.Send(msg)
+	return err
+}
+
+// telegramText renders a notification as a Telegram HTML message of at most
+// telegramMaxLength characters.
+func telegramText(n Notification) string {
+	var messageText string
+	switch n.Event {
+	case EventChange:
+		header := "Change detected in: " + n.URL
+		footer := "View details on the dashboard."
+		if n.Link != "" {
+			footer = "View details on the dashboard:\n\n" + n.Link
+		}
+		messageText = fmt.Sprintf("<b>Change detected in:</b> %s\n\n", html.EscapeString(n.URL))
+		budget := telegramMaxLength - utf8.RuneCountInString(header+footer) - len("\n\n\n\n")
+		if lines := fitLines(n.ChangeLines(), budget); len(lines) > 0 {
+			messageText += html.EscapeString(strings.Join(lines, "\n")) + "\n\n"
+		}
+		messageText += html.EscapeString(footer)
+	case EventDown:
+		messageText = fmt.Sprintf("<b>Downtime Alert:</b> URL %s appears to be down.\n\nReason: %s",
+			html.EscapeString(n.URL), html.EscapeString(n.Reason))
+	case EventEscalation:
+		messageText = fmt.Sprintf("<b>Still Down:</b> URL %s has failed %d consecutive checks (down for %s).\n\nLast error: %s",
+			html.EscapeString(n.URL), n.ConsecutiveFailures, n.Downtime(), html.EscapeString(n.Reason))
+	case EventRecovery:
+		messageText = fmt.Sprintf("<b>Recovered:</b> URL %s is back up after %s of downtime.", html.EscapeString(n.URL), n.Downtime())
+	default:
+		title := n.Title()
+		details := fitLines(strings.Split(n.Details(), "\n"), telegramMaxLength-utf8.RuneCountInString(title)-len("\n\n"))
+		messageText = html.EscapeString(title + "\n\n" + strings.Join(details, "\n"))
+	}
+	return messageText
+}
+
+// telegramMaxLength is the longest message Telegram accepts, counted in
+// characters of the text after HTML parsing, so tags don't count and an
+// escaped character counts once.
+const telegramMaxLength = 4096
+
+// fitLines keeps the lines that fit in budget characters, including the
+// newlines between them, and replaces the rest with a line counting them.
+// Lines are kept or dropped whole, so no character is ever cut in half.
+func fitLines(lines []string, budget int) []string {
+	used := 0
+	for i, line := range lines {
+		if used+utf8.RuneCountInString(line)+1 <= budget {
+			used += utf8.RuneCountInString(line) + 1
+			continue
+		}
+		// Make room for the line counting the rest.
+		for ; i > 0; i-- {
+			more := fmt.Sprintf("…and %d more", len(lines)-i)
+			if used+utf8.RuneCountInString(more) <= budget {
+				break
+			}
+			used -= utf8.RuneCountInString(lines[i-1]) + 1
+		}
+		return append(lines[:i:i], fmt.Sprintf("…and %d more", len(lines)-i))
+	}
+	return lines
+}
+
+// SlackNotifier posts notifications to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+}
+
+func (s *SlackNotifier) Name() string { return "Slack" }
+
+func (s *SlackNotifier) Notify(n Notification) error {
+	text := fmt.Sprintf("*%s*\n%s", slackEscape(n.Title()), slackEscape(n.Details()))
+	return postJSON(s.WebhookURL, map[string]string{"text": text}, nil)
+}
+
+// slackEscape escapes the characters Slack treats as control characters in message text.
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+
+// DiscordNotifier posts notifications to a Discord webhook.
+type DiscordNotifier struct {
+	WebhookURL string
+}
+
+func (d *DiscordNotifier) Name() string { return "Discord" }
+
+func (d *DiscordNotifier) Notify(n Notification) error {
+	content := fmt.Sprintf("**%s**\n%s", n.Title(), n.Details())
+	// Discord rejects messages longer than 2000 characters.
+	if runes := []rune(content); len(runes) > 2000 {
+		content = string(runes[:1997]) + "..."
+	}
+	return postJSON(d.WebhookURL, map[string]interface{}{
+		"content":          content,
+		"allowed_mentions": map[string]interface{}{"parse": []string{}},
+	}, nil)
+}
+
+// WebhookNotifier posts the Notification as JSON to any URL. When a secret is
+// set, the body is signed with HMAC-SHA256 and the signature is sent in the
+// X-Signature-256 header as "sha256=<hex>".
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+}
+
+func (w *WebhookNotifier) Name() string { return "Webhook" }
+
+func (w *WebhookNotifier) Notify(n Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"X-Watcher-Event": n.Event}
+	if w.Secret != "" {
+		headers["X-Signature-256"] = "sha256=" + SignPayload(w.Secret, payload)
+	}
+	return postPayload(w.URL, payload, headers)
+}
+
+// SignPayload returns the hex-encoded HMAC-SHA256 of payload using secret.
+func SignPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
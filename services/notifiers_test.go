@@ -0,0 +1,99 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFitLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		budget int
+		want   []string
+	}{
+		{"all fit", []string{"ab", "cd"}, 6, []string{"ab", "cd"}},
+		{"nothing to fit", nil, 10, nil},
+		{"count replaces the rest", []string{"aaaaaaaa", "bbbbbbbb", "cccccccc"}, 20, []string{"aaaaaaaa", "…and 2 more"}},
+		{"lines make room for the count", []string{"aa", "bb", "cc", "dd", "ee", "ff"}, 15, []string{"aa", "…and 5 more"}},
+		{"characters, not bytes", []string{"éééééééé", "éééééééé", "éééééééé"}, 20, []string{"éééééééé", "…and 2 more"}},
+		{"multi-byte lines are dropped whole", []string{"日本語日本語", "日本語日本語", "x"}, 15, []string{"…and 3 more"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fitLines(tt.lines, tt.budget)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fitLines() = %q, want %q", got, tt.want)
+			}
+			if n := utf8.RuneCountInString(strings.Join(got, "\n")); n > tt.budget {
+				t.Errorf("fitLines() takes %d characters, budget %d", n, tt.budget)
+			}
+			for _, line := range got {
+				if !utf8.ValidString(line) {
+					t.Errorf("fitLines() returned invalid UTF-8 %q", line)
+				}
+			}
+		})
+	}
+}
+
+func TestTelegramTextFits(t *testing.T) {
+	tag := regexp.MustCompile(`<[^>]+>`)
+	many := func(n int, format string) []string {
+		items := make([]string, n)
+		for i := range items {
+			items[i] = fmt.Sprintf(format, i)
+		}
+		return items
+	}
+
+	tests := []struct {
+		name string
+		n    Notification
+		more bool
+	}{
+		{
+			name: "short change",
+			n:    Notification{Event: EventChange, URL: "https://example.com/app.js", AddedEndpoints: []string{"/api/a"}},
+		},
+		{
+			name: "long endpoints",
+			n: Notification{
+				Event:            EventChange,
+				URL:              "https://example.com/app.js",
+				Link:             "https://watcher.example.com/diff/1",
+				AddedEndpoints:   many(20, "/api/"+strings.Repeat("é", 240)+"/%d"),
+				RemovedEndpoints: many(20, "/old/"+strings.Repeat("<&>", 80)+"/%d"),
+				SourceFiles:      many(500, "src/%d.ts (modified)"),
+			},
+			more: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := telegramText(tt.n)
+			if !utf8.ValidString(text) {
+				t.Fatalf("telegramText() is not valid UTF-8")
+			}
+			visible := html.UnescapeString(tag.ReplaceAllString(text, ""))
+			if n := utf8.RuneCountInString(visible); n > telegramMaxLength {
+				t.Errorf("telegramText() is %d characters, more than %d", n, telegramMaxLength)
+			}
+			if more := strings.Contains(visible, "more"); more != tt.more {
+				t.Errorf("telegramText() counts the rest: %v, want %v", more, tt.more)
+			}
+			footer := "View details on the dashboard."
+			if tt.n.Link != "" {
+				footer = "View details on the dashboard:\n\n" + tt.n.Link
+			}
+			if !strings.HasSuffix(visible, footer) {
+				t.Errorf("telegramText() lost its footer")
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Notification events.
+const (
+	EventChange     = "change"     // The content of a URL changed
+	EventDown       = "down"       // A URL went down
+	EventEscalation = "escalation" // A URL is still down after DowntimeEscalateAfter failures
+	EventRecovery   = "recovery"   // A URL came back up
+	EventInventory  = "inventory"  // Scripts appeared on or disappeared from a group's source page
+
+	EventLoginFailures = "login_failures" // Repeated failed logins for a username or from an IP
+)
+
+// Notification describes something worth telling the team about. It is also
+// the JSON body sent by WebhookNotifier.
+type Notification struct {
+	Event               string    `json:"event"`
+	URL                 string    `json:"url"`
+	Link                string    `json:"link,omitempty"`   // Dashboard link, e.g. to the diff of a change
+	DiffHTML            string    `json:"-"`                // Rendered diff of a change (ChangeEvent.DiffText)
+	Reason              string    `json:"reason,omitempty"` // Why a check failed
+	ConsecutiveFailures int       `json:"consecutive_failures,omitempty"`
+	DowntimeSeconds     int64     `json:"downtime_seconds,omitempty"`
+	Username            string    `json:"username,omitempty"` // Login failure alerts only
+	IP                  string    `json:"ip,omitempty"`
+	FailedAttempts      int64     `json:"failed_attempts,omitempty"`
+	AddedScripts        []string  `json:"added_scripts,omitempty"` // Inventory changes only
+	RemovedScripts      []string  `json:"removed_scripts,omitempty"`
+	WatchedScripts      []string  `json:"watched_scripts,omitempty"` // Added scripts that are now watched
+	SourceFiles         []string  `json:"source_files,omitempty"`    // Changes only: original files that changed, as "path (status)"
+	AddedEndpoints      []string  `json:"added_endpoints,omitempty"` // Changes only
+	RemovedEndpoints    []string  `json:"removed_endpoints,omitempty"`
+	Time                time.Time `json:"time"`
+}
+
+// Title is a short one-line summary of the notification.
+func (n Notification) Title() string {
+	switch n.Event {
+	case EventChange:
+		var summary []string
+		switch len(n.SourceFiles) {
+		case 0:
+		case 1:
+			summary = append(summary, n.SourceFiles[0])
+		default:
+			summary = append(summary, fmt.Sprintf("%d source files", len(n.SourceFiles)))
+		}
+		switch len(n.AddedEndpoints) {
+		case 0:
+		case 1:
+			summary = append(summary, "1 new endpoint")
+		default:
+			summary = append(summary, fmt.Sprintf("%d new endpoints", len(n.AddedEndpoints)))
+		}
+		if len(summary) == 0 {
+			return "Change detected in: " + n.URL
+		}
+		return fmt.Sprintf("Change detected in: %s (%s)", n.URL, strings.Join(summary, ", "))
+	case EventDown:
+		return "Downtime Alert: " + n.URL + " appears to be down"
+	case EventEscalation:
+		return fmt.Sprintf("Still Down: %s has failed %d consecutive checks", n.URL, n.ConsecutiveFailures)
+	case EventRecovery:
+		return "Recovered: " + n.URL + " is back up"
+	case EventInventory:
+		return fmt.Sprintf("Script Inventory Changed: %d added, %d removed on %s", len(n.AddedScripts), len(n.RemovedScripts), n.URL)
+	case EventLoginFailures:
+		return fmt.Sprintf("Failed Logins: %d failed attempts for %q from %s", n.FailedAttempts, n.Username, n.IP)
+	}
+	return n.Event + ": " + n.URL
+}
+
+// Details is the plain-text body that goes with the title.
+func (n Notification) Details() string {
+	switch n.Event {
+	case EventChange:
+		lines := n.ChangeLines()
+		if n.Link != "" {
+			lines = append(lines, "View details on the dashboard: "+n.Link)
+		} else {
+			lines = append(lines, "View details on the dashboard.")
+		}
+		return strings.Join(lines, "\n")
+	case EventDown:
+		return "Reason: " + n.Reason
+	case EventEscalation:
+		return fmt.Sprintf("Down for %s. Last error: %s", n.Downtime(), n.Reason)
+	case EventRecovery:
+		return fmt.Sprintf("Back up after %s of downtime.", n.Downtime())
+	case EventLoginFailures:
+		return n.Reason
+	case EventInventory:
+		var lines []string
+		for _, script := range n.AddedScripts {
+			lines = append(lines, "+ "+script)
+		}
+		for _, script := range n.RemovedScripts {
+			lines = append(lines, "- "+script)
+		}
+		if len(n.WatchedScripts) > 0 {
+			lines = append(lines, fmt.Sprintf("Now watching %d of the added scripts.", len(n.WatchedScripts)))
+		}
+		if n.Link != "" {
+			lines = append(lines, "View the inventory on the dashboard: "+n.Link)
+		}
+		return strings.Join(lines, "\n")
+	}
+	return ""
+}
+
+// maxListedChangeItems caps each list in ChangeLines, so that a change to
+// hundreds of files still fits in a chat message.
+const maxListedChangeItems = 20
+
+// ChangeLines breaks a change down for its details: the original files that
+// changed, then the endpoints that were added ("+") and removed ("-"). It is
+// empty when there is neither.
+func (n Notification) ChangeLines() []string {
+	var lines []string
+	if len(n.SourceFiles) > 0 {
+		lines = append(lines, "Changed source files:")
+		lines = appendListed(lines, "  ", n.SourceFiles)
+	}
+	if len(n.AddedEndpoints) > 0 || len(n.RemovedEndpoints) > 0 {
+		lines = append(lines, "Endpoints:")
+		lines = appendListed(lines, "  + ", n.AddedEndpoints)
+		lines = appendListed(lines, "  - ", n.RemovedEndpoints)
+	}
+	return lines
+}
+
+// appendListed adds up to maxListedChangeItems items with the given prefix,
+// and a line counting the rest.
+func appendListed(lines []string, prefix string, items []string) []string {
+	for i, item := range items {
+		if i == maxListedChangeItems {
+			return append(lines, fmt.Sprintf("  …and %d more", len(items)-i))
+		}
+		lines = append(lines, prefix+item)
+	}
+	return lines
+}
+
+// Downtime returns how long the URL has been (or was) down.
+func (n Notification) Downtime() time.Duration {
+	return time.Duration(n.DowntimeSeconds) * time.Second
+}
+
+// Notifier delivers notifications to one backend (Telegram, Slack, ...).
+type Notifier interface {
+	Name() string
+	Notify(n Notification) error
+}
+
+var notifiers []Notifier
+
+// RegisterNotifier adds a backend that receives every notification.
+func RegisterNotifier(n Notifier) {
+	notifiers = append(notifiers, n)
+	log.Printf("Notifications enabled: %s", n.Name())
+}
+
+// notify sends a notification to every registered backend. A failing backend
+// is logged and does not stop delivery to the others.
+func notify(n Notification) {
+	if n.Time.IsZero() {
+		n.Time = time.Now().UTC()
+	}
+	subject := n.URL
+	if subject == "" {
+		subject = n.Event
+	}
+	if len(notifiers) == 0 {
+		log.Printf("No notifiers configured. Skipping notification for %s", subject)
+		return
+	}
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(n); err != nil {
+			log.Printf("Failed to send %s notification for %s: %v", notifier.Name(), subject, err)
+		} else {
+			log.Printf("%s notification sent for %s", notifier.Name(), subject)
+		}
+	}
+}
+
+var notifyHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// postJSON posts a JSON body and treats any non-2xx response as an error.
+func postJSON(url string, body interface{}, headers map[string]string) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return postPayload(url, payload, headers)
+}
+
+func postPayload(url string, payload []byte, headers map[string]string) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", DefaultUserAgent)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := notifyHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return nil
+}
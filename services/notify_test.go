@@ -0,0 +1,62 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestChangeLines(t *testing.T) {
+	files := make([]string, maxListedChangeItems+3)
+	for i := range files {
+		files[i] = fmt.Sprintf("src/%02d.ts (modified)", i)
+	}
+
+	tests := []struct {
+		name string
+		n    Notification
+		want []string
+	}{
+		{
+			name: "nothing",
+			n:    Notification{Event: EventChange},
+			want: nil,
+		},
+		{
+			name: "source files and endpoints",
+			n: Notification{
+				SourceFiles:      []string{"src/a.ts (added)"},
+				AddedEndpoints:   []string{"/api/new"},
+				RemovedEndpoints: []string{"/api/old"},
+			},
+			want: []string{"Changed source files:", "  src/a.ts (added)", "Endpoints:", "  + /api/new", "  - /api/old"},
+		},
+		{
+			name: "only removed endpoints",
+			n:    Notification{RemovedEndpoints: []string{"/api/old"}},
+			want: []string{"Endpoints:", "  - /api/old"},
+		},
+		{
+			name: "long lists are capped",
+			n:    Notification{SourceFiles: files, AddedEndpoints: files[:maxListedChangeItems]},
+			want: func() []string {
+				lines := []string{"Changed source files:"}
+				for _, file := range files[:maxListedChangeItems] {
+					lines = append(lines, "  "+file)
+				}
+				lines = append(lines, "  …and 3 more", "Endpoints:")
+				for _, file := range files[:maxListedChangeItems] {
+					lines = append(lines, "  + "+file)
+				}
+				return lines
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.n.ChangeLines(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ChangeLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
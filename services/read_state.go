@@ -0,0 +1,93 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"go-js-watcher/models"
+
+	"gorm.io/gorm"
+)
+
+// UnreadBy limits a change_events query to changes the user has not read.
+func UnreadBy(userID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("NOT EXISTS (SELECT 1 FROM change_reads WHERE change_reads.change_event_id = change_events.id AND change_reads.user_id = ?)", userID)
+	}
+}
+
+// ReadBy limits a change_events query to changes the user has read.
+func ReadBy(userID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("EXISTS (SELECT 1 FROM change_reads WHERE change_reads.change_event_id = change_events.id AND change_reads.user_id = ?)", userID)
+	}
+}
+
+// MarkRead marks every change matched by the changes query as read for the
+// user and returns how many were unread before.
+func MarkRead(db *gorm.DB, userID uint, changes *gorm.DB) (int64, error) {
+	result := db.Exec("INSERT OR IGNORE INTO change_reads (user_id, change_event_id, read_at) SELECT ?, id, ? FROM (?)",
+		userID, time.Now().UTC(), changes.Select("change_events.id"))
+	return result.RowsAffected, result.Error
+}
+
+// MarkUnread marks every change matched by the changes query as unread for the
+// user and returns how many were read before.
+func MarkUnread(db *gorm.DB, userID uint, changes *gorm.DB) (int64, error) {
+	result := db.Where("user_id = ? AND change_event_id IN (?)", userID, changes.Select("change_events.id")).
+		Delete(&models.ChangeRead{})
+	return result.RowsAffected, result.Error
+}
+
+// FillReadState sets IsRead on each change for the given user.
+func FillReadState(db *gorm.DB, userID uint, changes []*models.ChangeEvent) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(changes))
+	for i, ch := range changes {
+		ids[i] = ch.ID
+	}
+	var readIDs []uint
+	if result := db.Model(&models.ChangeRead{}).
+		Where("user_id = ? AND change_event_id IN ?", userID, ids).
+		Pluck("change_event_id", &readIDs); result.Error != nil {
+		return result.Error
+	}
+	read := make(map[uint]bool, len(readIDs))
+	for _, id := range readIDs {
+		read[id] = true
+	}
+	for _, ch := range changes {
+		ch.IsRead = read[ch.ID]
+	}
+	return nil
+}
+
+// MigrateLegacyReadState converts the old global change_events.is_read flag
+// into per-user read state. A change that was read before is marked read for
+// every existing user, since they all shared the single login. The flag is
+// cleared afterwards so this only ever copies it once.
+func MigrateLegacyReadState(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("change_events", "is_read") {
+		return nil
+	}
+	var copied int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec("INSERT OR IGNORE INTO change_reads (user_id, change_event_id, read_at) "+
+			"SELECT users.id, change_events.id, change_events.updated_at FROM users CROSS JOIN change_events "+
+			"WHERE change_events.is_read = ? AND users.deleted_at IS NULL", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		copied = result.RowsAffected
+		return tx.Exec("UPDATE change_events SET is_read = ? WHERE is_read = ?", false, true).Error
+	})
+	if err != nil {
+		return err
+	}
+	if copied > 0 {
+		log.Printf("Migrated %d legacy read flags to per-user read state.", copied)
+	}
+	return nil
+}
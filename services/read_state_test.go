@@ -0,0 +1,126 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go-js-watcher/models"
+
+	"gorm.io/gorm"
+)
+
+// changeIDs returns the IDs of the changes matched by query, in ID order.
+func changeIDs(t *testing.T, query *gorm.DB) []uint {
+	t.Helper()
+	var ids []uint
+	if err := query.Order("id").Pluck("change_events.id", &ids).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func sameIDs(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReadStateIsPerUser(t *testing.T) {
+	db := newTestDB(t)
+	alice := models.User{Username: "alice", PasswordHash: "x"}
+	bob := models.User{Username: "bob", PasswordHash: "x"}
+	db.Create(&alice)
+	db.Create(&bob)
+	changes := []models.ChangeEvent{{URLID: 1}, {URLID: 1}, {URLID: 2}}
+	db.Create(&changes)
+	all := func() *gorm.DB { return db.Model(&models.ChangeEvent{}) }
+
+	marked, err := MarkRead(db, alice.ID, all().Where("url_id = ?", 1))
+	if err != nil || marked != 2 {
+		t.Fatalf("MarkRead = %d, %v; want 2", marked, err)
+	}
+	// Changes already read aren't counted again.
+	if marked, err := MarkRead(db, alice.ID, all()); err != nil || marked != 1 {
+		t.Fatalf("second MarkRead = %d, %v; want 1", marked, err)
+	}
+	if unmarked, err := MarkUnread(db, alice.ID, all().Where("url_id = ?", 2)); err != nil || unmarked != 1 {
+		t.Fatalf("MarkUnread = %d, %v; want 1", unmarked, err)
+	}
+
+	if got, want := changeIDs(t, all().Scopes(ReadBy(alice.ID))), []uint{changes[0].ID, changes[1].ID}; !sameIDs(got, want) {
+		t.Errorf("read by alice = %v, want %v", got, want)
+	}
+	if got, want := changeIDs(t, all().Scopes(UnreadBy(alice.ID))), []uint{changes[2].ID}; !sameIDs(got, want) {
+		t.Errorf("unread by alice = %v, want %v", got, want)
+	}
+	if got := changeIDs(t, all().Scopes(ReadBy(bob.ID))); len(got) != 0 {
+		t.Errorf("read by bob = %v, want none", got)
+	}
+
+	ptrs := []*models.ChangeEvent{&changes[0], &changes[1], &changes[2]}
+	if err := FillReadState(db, alice.ID, ptrs); err != nil {
+		t.Fatal(err)
+	}
+	if !changes[0].IsRead || !changes[1].IsRead || changes[2].IsRead {
+		t.Errorf("alice's read state = %v %v %v, want true true false", changes[0].IsRead, changes[1].IsRead, changes[2].IsRead)
+	}
+	if err := FillReadState(db, bob.ID, ptrs); err != nil {
+		t.Fatal(err)
+	}
+	for i, ch := range changes {
+		if ch.IsRead {
+			t.Errorf("change %d is read for bob", i)
+		}
+	}
+}
+
+func TestMigrateLegacyReadState(t *testing.T) {
+	db := newTestDB(t)
+	if err := MigrateLegacyReadState(db); err != nil {
+		t.Fatalf("without the legacy column: %v", err)
+	}
+
+	if err := db.Exec("ALTER TABLE change_events ADD COLUMN is_read numeric DEFAULT false").Error; err != nil {
+		t.Fatal(err)
+	}
+	alice := models.User{Username: "alice", PasswordHash: "x"}
+	bob := models.User{Username: "bob", PasswordHash: "x"}
+	removed := models.User{Username: "removed", PasswordHash: "x"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&removed)
+	db.Delete(&removed)
+	changes := []models.ChangeEvent{{URLID: 1, DetectedAt: time.Now().UTC()}, {URLID: 1, DetectedAt: time.Now().UTC()}}
+	db.Create(&changes)
+	db.Exec("UPDATE change_events SET is_read = ? WHERE id = ?", true, changes[0].ID)
+
+	if err := MigrateLegacyReadState(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []models.User{alice, bob} {
+		got := changeIDs(t, db.Model(&models.ChangeEvent{}).Scopes(ReadBy(user.ID)))
+		if want := []uint{changes[0].ID}; !sameIDs(got, want) {
+			t.Errorf("read by %s = %v, want %v", user.Username, got, want)
+		}
+	}
+	var reads int64
+	db.Model(&models.ChangeRead{}).Where("user_id = ?", removed.ID).Count(&reads)
+	if reads != 0 {
+		t.Errorf("deleted user got %d read marks", reads)
+	}
+
+	// The flag is cleared, so unreading a change isn't undone by the next start.
+	MarkUnread(db, alice.ID, db.Model(&models.ChangeEvent{}))
+	if err := MigrateLegacyReadState(db); err != nil {
+		t.Fatal(err)
+	}
+	if got := changeIDs(t, db.Model(&models.ChangeEvent{}).Scopes(ReadBy(alice.ID))); len(got) != 0 {
+		t.Errorf("after migrating again, read by alice = %v, want none", got)
+	}
+}
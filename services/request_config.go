@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go-js-watcher/models"
+
+	"gorm.io/gorm"
+)
+
+// DefaultUserAgent is sent when neither the URL nor its group sets one.
+const DefaultUserAgent = "JS-Watcher-Bot/1.0 (Go)"
+
+// Authentication types for RequestConfig.AuthType.
+const (
+	AuthNone   = ""
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+)
+
+// LoadRequestConfigs returns the request settings that apply to a URL, group
+// settings first and URL settings last, so later entries take precedence.
+func LoadRequestConfigs(db *gorm.DB, urlEntry models.WatchedUrl) ([]models.RequestConfig, error) {
+	var configs []models.RequestConfig
+	if urlEntry.GroupID != nil {
+		var groupConfig models.RequestConfig
+		result := db.Where("group_id = ?", *urlEntry.GroupID).First(&groupConfig)
+		if result.Error == nil {
+			configs = append(configs, groupConfig)
+		} else if result.Error != gorm.ErrRecordNotFound {
+			return nil, result.Error
+		}
+	}
+
+	var urlConfig models.RequestConfig
+	result := db.Where("url_id = ?", urlEntry.ID).First(&urlConfig)
+	if result.Error == nil {
+		configs = append(configs, urlConfig)
+	} else if result.Error != gorm.ErrRecordNotFound {
+		return nil, result.Error
+	}
+	return configs, nil
+}
+
+// ApplyRequestConfigs sets the User-Agent, custom headers, cookies and
+// authentication from the given settings on a request.
+func ApplyRequestConfigs(req *http.Request, configs []models.RequestConfig) error {
+	userAgent := DefaultUserAgent
+	var authConfig *models.RequestConfig
+	cookies := make(map[string]string)
+	var cookieOrder []string
+
+	for i := range configs {
+		config := &configs[i]
+		if config.UserAgent != "" {
+			userAgent = config.UserAgent
+		}
+		if config.AuthType != AuthNone {
+			authConfig = config
+		}
+
+		headers, err := DecryptSecret(config.Headers)
+		if err != nil {
+			return fmt.Errorf("custom headers: %v", err)
+		}
+		for name, value := range ParseHeaderLines(headers) {
+			req.Header.Set(name, value)
+		}
+
+		cookieText, err := DecryptSecret(config.Cookies)
+		if err != nil {
+			return fmt.Errorf("cookies: %v", err)
+		}
+		for _, cookie := range ParseCookieLines(cookieText) {
+			if _, seen := cookies[cookie.Name]; !seen {
+				cookieOrder = append(cookieOrder, cookie.Name)
+			}
+			cookies[cookie.Name] = cookie.Value
+		}
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	for _, name := range cookieOrder {
+		req.AddCookie(&http.Cookie{Name: name, Value: cookies[name]})
+	}
+
+	if authConfig != nil {
+		secret, err := DecryptSecret(authConfig.AuthSecret)
+		if err != nil {
+			return fmt.Errorf("credentials: %v", err)
+		}
+		switch authConfig.AuthType {
+		case AuthBasic:
+			req.SetBasicAuth(authConfig.AuthUsername, secret)
+		case AuthBearer:
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+	}
+	return nil
+}
+
+// ParseHeaderLines parses one "Name: value" header per line. Malformed lines are ignored.
+func ParseHeaderLines(text string) map[string]string {
+	headers := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		name, value, found := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			continue
+		}
+		headers[http.CanonicalHeaderKey(name)] = strings.TrimSpace(value)
+	}
+	return headers
+}
+
+// ParseCookieLines parses "name=value" cookies, one per line or separated by ';'.
+func ParseCookieLines(text string) []http.Cookie {
+	var cookies []http.Cookie
+	for _, line := range strings.Split(text, "\n") {
+		for _, pair := range strings.Split(line, ";") {
+			name, value, found := strings.Cut(pair, "=")
+			name = strings.TrimSpace(name)
+			if !found || name == "" {
+				continue
+			}
+			cookies = append(cookies, http.Cookie{Name: name, Value: strings.TrimSpace(value)})
+		}
+	}
+	return cookies
+}
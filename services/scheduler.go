@@ -1,7 +1,10 @@
 package services
 
 import (
+	"errors"
 	"log"
+	"math/rand"
+	"sync"
 	"time"
 
 	"go-js-watcher/database" // Import your database package
@@ -10,39 +13,215 @@ import (
 	"github.com/robfig/cron/v3" // The cron scheduler library
 )
 
+// Scheduler dispatches due URL checks to a bounded pool of workers.
+// A URL is due once its NextCheckAt has passed (or was never set), and it is
+// never queued or checked twice at the same time.
+type Scheduler struct {
+	diffViewBaseURL string
+	jitter          time.Duration
+
+	queue   chan uint
+	mu      sync.Mutex
+	pending map[uint]bool // URL IDs that are queued or currently being checked
+
+	extractMu   sync.Mutex
+	extracting  map[uint]bool // Group IDs that are currently being re-extracted
+	dispatching bool          // Whether dispatchDueExtractions is still working through due groups
+}
+
+// defaultScheduler is the scheduler started by StartScheduler. Handlers use it
+// through TriggerCheck so manual checks share the same pool and guards.
+var defaultScheduler *Scheduler
+
 // StartScheduler initializes and starts the periodic URL checking.
-// The `diffViewBaseURL` parameter is needed to construct the full link for Telegram notifications.
-func StartScheduler(diffViewBaseURL, botToken, chatID string) {
+// The `diffViewBaseURL` parameter is needed to construct the full link in notifications.
+// `workers` bounds how many checks run concurrently and `jitter` is the maximum random
+// delay added to each URL's next due time so checks spread out instead of bursting.
+func StartScheduler(diffViewBaseURL string, workers int, jitter time.Duration) {
+	if workers <= 0 {
+		workers = 1
+	}
+	s := &Scheduler{
+		diffViewBaseURL: diffViewBaseURL,
+		jitter:          jitter,
+		queue:           make(chan uint, workers*4),
+		pending:         make(map[uint]bool),
+		extracting:      make(map[uint]bool),
+	}
+	defaultScheduler = s
+
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+
 	c := cron.New()
+	c.AddFunc("@every 10s", s.dispatchDue)
+	c.AddFunc("@every 1m", s.dispatchDueExtractions)
+	c.Start()
 
-	c.AddFunc("@every 1m", func() {
-		log.Println("Scheduler: Running periodic check for URLs due...")
-		now := time.Now().UTC()
+	log.Printf("Periodic URL checking scheduler started with %d workers (jitter up to %v).", workers, jitter)
+}
 
-		var urlsToProcess []models.WatchedUrl
-		result := database.DB.Where("is_active = ?", true).Find(&urlsToProcess)
-		if result.Error != nil {
-			log.Printf("Scheduler: Error fetching URLs: %v", result.Error)
-			return
-		}
+// TriggerCheck queues an immediate check of the given URL. It is a no-op if the
+// URL is already queued or being checked.
+func TriggerCheck(urlID uint) {
+	if defaultScheduler == nil {
+		log.Printf("Scheduler: not started, cannot trigger check for URL ID %d", urlID)
+		return
+	}
+	defaultScheduler.enqueue(urlID)
+}
+
+// dispatchDue finds every active URL whose next check time has passed and queues it.
+func (s *Scheduler) dispatchDue() {
+	now := time.Now().UTC()
+
+	var dueIDs []uint
+	result := database.DB.Model(&models.WatchedUrl{}).
+		Where("is_active = ? AND (next_check_at IS NULL OR next_check_at <= ?)", true, now).
+		Order("next_check_at ASC").
+		Pluck("id", &dueIDs)
+	if result.Error != nil {
+		log.Printf("Scheduler: Error fetching due URLs: %v", result.Error)
+		return
+	}
 
-		for _, urlEntry := range urlsToProcess {
-			if urlEntry.LastChecked == nil {
-				log.Printf("Scheduler: URL '%s' never checked, scheduling first check.", urlEntry.URL)
-				// Pass credentials here
-				go CheckURLForChanges(urlEntry.ID, diffViewBaseURL, botToken, chatID)
-				continue
-			}
-
-			timeSinceLastCheck := now.Sub(*urlEntry.LastChecked)
-			if timeSinceLastCheck.Minutes() >= 1 {
-				log.Printf("Scheduler: URL '%s' due for check (last checked %v ago), scheduling.", urlEntry.URL, timeSinceLastCheck.Round(time.Second))
-				// Pass credentials here
-				go CheckURLForChanges(urlEntry.ID, diffViewBaseURL, botToken, chatID)
-			}
+	queued := 0
+	for _, id := range dueIDs {
+		if s.enqueue(id) {
+			queued++
 		}
-	})
+	}
+	if queued > 0 {
+		log.Printf("Scheduler: queued %d of %d due URLs.", queued, len(dueIDs))
+	}
+}
 
-	c.Start()
-	log.Println("Periodic URL checking scheduler started.")
+// enqueue adds a URL to the work queue unless it is already pending or the
+// queue is full. URLs that are skipped because the queue is full stay due and
+// are picked up again on the next dispatch.
+func (s *Scheduler) enqueue(urlID uint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending[urlID] {
+		return false
+	}
+	select {
+	case s.queue <- urlID:
+		s.pending[urlID] = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Scheduler) worker() {
+	for urlID := range s.queue {
+		CheckURLForChanges(urlID, s.diffViewBaseURL)
+		s.scheduleNext(urlID)
+
+		s.mu.Lock()
+		delete(s.pending, urlID)
+		s.mu.Unlock()
+	}
+}
+
+// scheduleNext stores the next due time for a URL based on its IntervalSeconds plus jitter.
+func (s *Scheduler) scheduleNext(urlID uint) {
+	var urlEntry models.WatchedUrl
+	if result := database.DB.Select("id", "interval_seconds").First(&urlEntry, urlID); result.Error != nil {
+		// The URL may have been removed while it was being checked.
+		return
+	}
+
+	interval := time.Duration(urlEntry.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 300 * time.Second
+	}
+	next := time.Now().UTC().Add(interval)
+	if s.jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(s.jitter))))
+	}
+
+	if result := database.DB.Model(&models.WatchedUrl{}).Where("id = ?", urlID).Update("next_check_at", next); result.Error != nil {
+		log.Printf("Scheduler: Error scheduling next check for URL ID %d: %v", urlID, result.Error)
+	}
+}
+
+// ReextractNow re-extracts a group right away, outside its schedule, and
+// returns the inventory change it found, if any.
+func ReextractNow(groupID uint) (*models.InventoryChange, error) {
+	if defaultScheduler == nil {
+		return nil, errors.New("the scheduler is not running")
+	}
+	return defaultScheduler.reextract(groupID)
+}
+
+// dispatchDueExtractions re-extracts every group whose next extraction time has
+// passed, one at a time. Extractions are slow and few, so they don't use the
+// check workers; a run that takes longer than a minute just delays the next one.
+func (s *Scheduler) dispatchDueExtractions() {
+	s.extractMu.Lock()
+	if s.dispatching {
+		s.extractMu.Unlock()
+		return
+	}
+	s.dispatching = true
+	s.extractMu.Unlock()
+	defer func() {
+		s.extractMu.Lock()
+		s.dispatching = false
+		s.extractMu.Unlock()
+	}()
+
+	var dueIDs []uint
+	result := database.DB.Model(&models.URLGroup{}).
+		Where("reextract_hours > 0 AND (next_extract_at IS NULL OR next_extract_at <= ?)", time.Now().UTC()).
+		Order("next_extract_at ASC").
+		Pluck("id", &dueIDs)
+	if result.Error != nil {
+		log.Printf("Scheduler: Error fetching groups due for re-extraction: %v", result.Error)
+		return
+	}
+	for _, id := range dueIDs {
+		s.reextract(id) // Failures are logged and recorded in the group's ExtractStatus
+	}
+}
+
+// reextract runs ReextractGroup unless the group is already being re-extracted,
+// then schedules its next extraction.
+func (s *Scheduler) reextract(groupID uint) (*models.InventoryChange, error) {
+	s.extractMu.Lock()
+	if s.extracting[groupID] {
+		s.extractMu.Unlock()
+		return nil, ErrExtractionRunning
+	}
+	s.extracting[groupID] = true
+	s.extractMu.Unlock()
+	defer func() {
+		s.extractMu.Lock()
+		delete(s.extracting, groupID)
+		s.extractMu.Unlock()
+	}()
+
+	change, err := ReextractGroup(database.DB, groupID, s.diffViewBaseURL)
+	s.scheduleNextExtraction(groupID)
+	return change, err
+}
+
+// scheduleNextExtraction stores the next due time for a group's re-extraction.
+func (s *Scheduler) scheduleNextExtraction(groupID uint) {
+	var group models.URLGroup
+	if result := database.DB.Select("id", "reextract_hours").First(&group, groupID); result.Error != nil {
+		return
+	}
+	var next *time.Time
+	if group.ReextractHours > 0 {
+		at := time.Now().UTC().Add(time.Duration(group.ReextractHours) * time.Hour)
+		next = &at
+	}
+	if result := database.DB.Model(&models.URLGroup{}).Where("id = ?", groupID).Update("next_extract_at", next); result.Error != nil {
+		log.Printf("Scheduler: Error scheduling next extraction for group ID %d: %v", groupID, result.Error)
+	}
 }
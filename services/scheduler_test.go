@@ -0,0 +1,84 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+)
+
+// useTestDB points the package-level database at a fresh test database.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	previous := database.DB
+	database.DB = newTestDB(t)
+	t.Cleanup(func() { database.DB = previous })
+}
+
+func TestDispatchDueQueuesOnlyDueURLs(t *testing.T) {
+	useTestDB(t)
+	past := time.Now().UTC().Add(-time.Minute)
+	future := time.Now().UTC().Add(time.Hour)
+	urls := []models.WatchedUrl{
+		{URL: "https://example.com/never-checked.js", IsActive: true},
+		{URL: "https://example.com/due.js", IsActive: true, NextCheckAt: &past},
+		{URL: "https://example.com/later.js", IsActive: true, NextCheckAt: &future},
+		{URL: "https://example.com/paused.js", IsActive: true, NextCheckAt: &past},
+	}
+	for i := range urls {
+		if err := database.DB.Create(&urls[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// is_active defaults to true, so pause explicitly.
+	database.DB.Model(&urls[3]).Update("is_active", false)
+
+	s := &Scheduler{queue: make(chan uint, 10), pending: make(map[uint]bool)}
+	s.dispatchDue()
+	s.dispatchDue() // Already pending: not queued again
+
+	var queued []uint
+	for len(s.queue) > 0 {
+		queued = append(queued, <-s.queue)
+	}
+	if len(queued) != 2 || queued[0] != urls[0].ID || queued[1] != urls[1].ID {
+		t.Errorf("queued %v, want [%d %d]", queued, urls[0].ID, urls[1].ID)
+	}
+}
+
+func TestEnqueueRespectsQueueBound(t *testing.T) {
+	s := &Scheduler{queue: make(chan uint, 1), pending: make(map[uint]bool)}
+	if !s.enqueue(1) {
+		t.Fatal("first URL not queued")
+	}
+	if s.enqueue(2) {
+		t.Error("URL queued past the queue bound")
+	}
+	if s.pending[2] {
+		t.Error("a URL that wasn't queued is marked pending")
+	}
+}
+
+func TestScheduleNextUsesInterval(t *testing.T) {
+	useTestDB(t)
+	urlEntry := models.WatchedUrl{URL: "https://example.com/app.js", IntervalSeconds: 3600}
+	if err := database.DB.Create(&urlEntry).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Scheduler{jitter: time.Minute}
+	before := time.Now().UTC()
+	s.scheduleNext(urlEntry.ID)
+
+	var saved models.WatchedUrl
+	database.DB.First(&saved, urlEntry.ID)
+	if saved.NextCheckAt == nil {
+		t.Fatal("next check time not set")
+	}
+	earliest := before.Add(time.Hour)
+	latest := time.Now().UTC().Add(time.Hour + time.Minute)
+	if saved.NextCheckAt.Before(earliest) || saved.NextCheckAt.After(latest) {
+		t.Errorf("next check at %v, want between %v and %v", saved.NextCheckAt, earliest, latest)
+	}
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// encryptedPrefix marks values produced by EncryptSecret.
+const encryptedPrefix = "enc:v1:"
+
+var encryptionKey []byte
+
+// SetEncryptionKey derives the key used to encrypt stored secrets (custom
+// headers, cookies, credentials) from the application's secret key.
+func SetEncryptionKey(secret []byte) {
+	sum := sha256.Sum256(append([]byte("go-js-watcher/secrets:"), secret...))
+	encryptionKey = sum[:]
+}
+
+// EncryptSecret encrypts a value with AES-GCM. Empty values stay empty.
+func EncryptSecret(plain string) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret reverses EncryptSecret. Values that were never encrypted are
+// returned as they are.
+func DecryptSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt value, was SECRET_KEY changed?")
+	}
+	return string(plain), nil
+}
+
+func newGCM() (cipher.AEAD, error) {
+	if encryptionKey == nil {
+		return nil, errors.New("encryption key not set")
+	}
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go-js-watcher/models"
+
+	"gorm.io/gorm"
+)
+
+// ContentHash returns the hex-encoded SHA-256 of the given content.
+func ContentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// credentialHeaders are response headers that can carry session cookies or
+// tokens, which must not be shown to everyone who can view a snapshot.
+var credentialHeaders = []string{"Set-Cookie", "Set-Cookie2", "Authorization", "Proxy-Authorization", "X-Auth-Token", "X-Api-Key", "X-Csrf-Token"}
+
+// RedactedHeaderValue replaces the values of credential-bearing headers.
+const RedactedHeaderValue = "[redacted]"
+
+// RedactHeaders returns a copy of headers with the values of credential-bearing
+// headers replaced, keeping the header names.
+func RedactHeaders(headers http.Header) http.Header {
+	redacted := headers.Clone()
+	for _, name := range credentialHeaders {
+		if values := redacted.Values(name); len(values) > 0 {
+			redacted[http.CanonicalHeaderKey(name)] = []string{RedactedHeaderValue}
+		}
+	}
+	return redacted
+}
+
+// saveSnapshot stores a version of a URL's content, reusing the existing
+// snapshot if the same content has been seen for this URL before.
+func saveSnapshot(db *gorm.DB, urlID uint, content string, headers http.Header, fetchedAt time.Time) (*models.Snapshot, error) {
+	hash := ContentHash(content)
+
+	var snapshot models.Snapshot
+	result := db.Where("url_id = ? AND content_hash = ?", urlID, hash).First(&snapshot)
+	if result.Error == nil {
+		return &snapshot, nil
+	}
+	if result.Error != gorm.ErrRecordNotFound {
+		return nil, result.Error
+	}
+
+	headersJSON := ""
+	if headers != nil {
+		if encoded, err := json.Marshal(RedactHeaders(headers)); err == nil {
+			headersJSON = string(encoded)
+		}
+	}
+
+	snapshot = models.Snapshot{
+		URLID:       urlID,
+		ContentHash: hash,
+		Size:        len(content),
+		Content:     content,
+		Headers:     headersJSON,
+		FetchedAt:   fetchedAt,
+	}
+	if result := db.Create(&snapshot); result.Error != nil {
+		return nil, result.Error
+	}
+	return &snapshot, nil
+}
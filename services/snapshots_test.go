@@ -0,0 +1,42 @@
+package services
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRedactHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers http.Header
+		want    http.Header
+	}{
+		{
+			name:    "no credentials",
+			headers: http.Header{"Content-Type": {"application/javascript"}, "Etag": {`"abc"`}},
+			want:    http.Header{"Content-Type": {"application/javascript"}, "Etag": {`"abc"`}},
+		},
+		{
+			name:    "session cookies",
+			headers: http.Header{"Set-Cookie": {"session=secret; HttpOnly", "csrf=token"}, "Content-Type": {"text/css"}},
+			want:    http.Header{"Set-Cookie": {RedactedHeaderValue}, "Content-Type": {"text/css"}},
+		},
+		{
+			name:    "tokens",
+			headers: http.Header{"Authorization": {"Bearer abc"}, "X-Auth-Token": {"t"}, "X-Api-Key": {"k"}},
+			want:    http.Header{"Authorization": {RedactedHeaderValue}, "X-Auth-Token": {RedactedHeaderValue}, "X-Api-Key": {RedactedHeaderValue}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := tt.headers.Clone()
+			if got := RedactHeaders(tt.headers); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RedactHeaders() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.headers, original) {
+				t.Errorf("RedactHeaders() modified its argument: %v", tt.headers)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go-js-watcher/models"
+
+	"gorm.io/gorm"
+)
+
+// Source file change statuses.
+const (
+	SourceFileAdded    = "added"
+	SourceFileRemoved  = "removed"
+	SourceFileModified = "modified"
+)
+
+const (
+	// maxSourceMapSize caps how much of a source map is read.
+	maxSourceMapSize = 50 << 20
+	// maxSourceFiles caps how many original files are kept per URL.
+	maxSourceFiles = 5000
+)
+
+// sourceMappingURLPattern matches the //# sourceMappingURL= comment of a
+// script (or the older //@ form), and the /*# ... */ form used by CSS.
+var sourceMappingURLPattern = regexp.MustCompile(`(?m)(?://|/\*)[#@][ \t]*sourceMappingURL[ \t]*=[ \t]*([^\s*]+)[ \t]*(?:\*/)?[ \t]*$`)
+
+// sourceMap is the part of a source map (version 3) needed to reconstruct the
+// original files. Index maps list their parts in Sections.
+type sourceMap struct {
+	SourceRoot     string    `json:"sourceRoot"`
+	Sources        []string  `json:"sources"`
+	SourcesContent []*string `json:"sourcesContent"`
+	Sections       []struct {
+		Map *sourceMap `json:"map"`
+	} `json:"sections"`
+}
+
+// FindSourceMapURL returns the source map of a script or stylesheet: the
+// SourceMap response header (or the older X-SourceMap), else the last
+// sourceMappingURL comment. Relative URLs are resolved against contentURL;
+// data: URLs are returned as they are. It returns "" if there is none.
+func FindSourceMapURL(contentURL string, headers http.Header, content string) string {
+	ref := headers.Get("SourceMap")
+	if ref == "" {
+		ref = headers.Get("X-SourceMap")
+	}
+	if ref == "" {
+		matches := sourceMappingURLPattern.FindAllStringSubmatch(content, -1)
+		if len(matches) == 0 {
+			return ""
+		}
+		ref = matches[len(matches)-1][1]
+	}
+	ref = strings.TrimSpace(ref)
+	if strings.HasPrefix(ref, "data:") {
+		return ref
+	}
+	base, err := url.Parse(contentURL)
+	if err != nil {
+		return ""
+	}
+	resolved, err := base.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	resolved.Fragment = ""
+	return resolved.String()
+}
+
+// loadSources fetches the source map of new content and reconstructs the
+// original files from it, by path. It records the map and the outcome on the
+// entry, which the caller saves, and returns nil if the content has no usable
+// source map. Request settings are only sent along to the URL's own host.
+func loadSources(urlEntry *models.WatchedUrl, headers http.Header, content string, configs []models.RequestConfig) map[string]string {
+	mapURL := FindSourceMapURL(urlEntry.URL, headers, content)
+	urlEntry.SourceMapURL = mapURL
+	if mapURL == "" {
+		urlEntry.SourceMapStatus = ""
+		return nil
+	}
+	if strings.HasPrefix(mapURL, "data:") {
+		urlEntry.SourceMapURL = "(inline)"
+	}
+
+	data, err := fetchSourceMap(*urlEntry, mapURL, configs)
+	if err != nil {
+		urlEntry.SourceMapStatus = fmt.Sprintf("Failed to load source map: %v", err)
+		log.Printf("Error loading source map of %s: %v", urlEntry.URL, err)
+		return nil
+	}
+	files, err := ParseSourceMap(data)
+	if err != nil {
+		urlEntry.SourceMapStatus = fmt.Sprintf("Invalid source map: %v", err)
+		log.Printf("Invalid source map for %s: %v", urlEntry.URL, err)
+		return nil
+	}
+	if len(files) == 0 {
+		urlEntry.SourceMapStatus = "The source map does not embed its sources"
+		return nil
+	}
+	urlEntry.SourceMapStatus = fmt.Sprintf("%d source files", len(files))
+	return files
+}
+
+// fetchSourceMap returns the contents of a source map URL, decoding data: URLs.
+func fetchSourceMap(urlEntry models.WatchedUrl, mapURL string, configs []models.RequestConfig) ([]byte, error) {
+	if strings.HasPrefix(mapURL, "data:") {
+		return decodeDataURL(mapURL)
+	}
+
+	req, err := http.NewRequest("GET", mapURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if sameHost(urlEntry.URL, mapURL) {
+		if err := ApplyRequestConfigs(req, configs); err != nil {
+			return nil, err
+		}
+	} else {
+		req.Header.Set("User-Agent", DefaultUserAgent)
+	}
+
+	resp, err := FetchClient(urlEntry.AllowPrivateTarget).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", mapURL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceMapSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSourceMapSize {
+		return nil, fmt.Errorf("larger than %d MB", maxSourceMapSize>>20)
+	}
+	return data, nil
+}
+
+// decodeDataURL decodes an inline source map such as
+// data:application/json;charset=utf-8;base64,eyJ2ZXJzaW9uIjozfQ==
+func decodeDataURL(dataURL string) ([]byte, error) {
+	comma := strings.Index(dataURL, ",")
+	if comma < 0 {
+		return nil, fmt.Errorf("malformed data URL")
+	}
+	meta, payload := dataURL[len("data:"):comma], dataURL[comma+1:]
+	if strings.HasSuffix(meta, ";base64") {
+		return base64.StdEncoding.DecodeString(payload)
+	}
+	decoded, err := url.PathUnescape(payload)
+	return []byte(decoded), err
+}
+
+func sameHost(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	return errA == nil && errB == nil && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// ParseSourceMap returns the original files embedded in a source map
+// (sourcesContent), by normalized path. Sources without embedded content
+// can't be reconstructed and are left out.
+func ParseSourceMap(data []byte) (map[string]string, error) {
+	var m sourceMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	addSourceMapFiles(files, &m)
+	return files, nil
+}
+
+func addSourceMapFiles(files map[string]string, m *sourceMap) {
+	for _, section := range m.Sections {
+		if section.Map != nil {
+			addSourceMapFiles(files, section.Map)
+		}
+	}
+	for i, source := range m.Sources {
+		if len(files) >= maxSourceFiles {
+			return
+		}
+		if i >= len(m.SourcesContent) || m.SourcesContent[i] == nil {
+			continue
+		}
+		p := SourcePath(m.SourceRoot, source)
+		if _, seen := files[p]; !seen && p != "" {
+			files[p] = *m.SourcesContent[i]
+		}
+	}
+}
+
+// SourcePath turns a source map source into a readable path: the bundler's
+// scheme ("webpack://app/./src/a.ts") is dropped and the path cleaned, giving
+// "app/src/a.ts".
+func SourcePath(sourceRoot, source string) string {
+	p := source
+	if sourceRoot != "" && !strings.Contains(source, "://") && !strings.HasPrefix(source, "/") {
+		p = strings.TrimSuffix(sourceRoot, "/") + "/" + source
+	}
+	if i := strings.Index(p, "://"); i >= 0 {
+		p = p[i+len("://"):]
+	}
+	query := ""
+	if i := strings.Index(p, "?"); i >= 0 {
+		p, query = p[:i], p[i:] // Keep loader queries such as "?vue&type=script"
+	}
+	if p == "" {
+		return ""
+	}
+	return strings.TrimLeft(path.Clean(p), "/") + query
+}
+
+// recordSourceChanges compares the original files of new content with the
+// URL's stored ones, saves a SourceFileChange for each difference to the
+// change event, and stores the new files for the next comparison. Ignore
+// rules apply to the original files as they do to the content. Nothing is
+// compared the first time a URL has source files.
+func recordSourceChanges(db *gorm.DB, urlID, changeEventID uint, files map[string]string, rules []models.IgnoreRule) ([]models.SourceFileChange, error) {
+	var previous []models.SourceFile
+	if result := db.Where("url_id = ?", urlID).Find(&previous); result.Error != nil {
+		return nil, result.Error
+	}
+
+	var changes []models.SourceFileChange
+	if len(previous) > 0 && changeEventID != 0 {
+		changes = diffSourceFiles(previous, files, rules)
+		for i := range changes {
+			changes[i].ChangeEventID = changeEventID
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if len(changes) > 0 {
+			if result := tx.CreateInBatches(changes, 100); result.Error != nil {
+				return result.Error
+			}
+		}
+		return storeSourceFiles(tx, urlID, files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// storeSourceFiles replaces the stored original files of a URL.
+func storeSourceFiles(db *gorm.DB, urlID uint, files map[string]string) error {
+	if result := db.Where("url_id = ?", urlID).Delete(&models.SourceFile{}); result.Error != nil {
+		return result.Error
+	}
+	rows := make([]models.SourceFile, 0, len(files))
+	for p, content := range files {
+		rows = append(rows, models.SourceFile{URLID: urlID, Path: p, Content: content})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return db.CreateInBatches(rows, 100).Error
+}
+
+// diffSourceFiles lists the original files that were added, removed or
+// modified, sorted by path, with a rendered diff of each.
+func diffSourceFiles(previous []models.SourceFile, current map[string]string, rules []models.IgnoreRule) []models.SourceFileChange {
+	old := make(map[string]string, len(previous))
+	for _, file := range previous {
+		old[file.Path] = file.Content
+	}
+
+	var changes []models.SourceFileChange
+	for p, content := range current {
+		before, existed := old[p]
+		maskedBefore, maskedAfter := ApplyIgnoreRules(before, rules), ApplyIgnoreRules(content, rules)
+		switch {
+		case !existed:
+			changes = append(changes, models.SourceFileChange{Path: p, Status: SourceFileAdded, DiffText: RenderDiff("", maskedAfter)})
+		case maskedBefore != maskedAfter:
+			changes = append(changes, models.SourceFileChange{Path: p, Status: SourceFileModified, DiffText: RenderDiff(maskedBefore, maskedAfter)})
+		}
+	}
+	for p, content := range old {
+		if _, exists := current[p]; !exists {
+			changes = append(changes, models.SourceFileChange{Path: p, Status: SourceFileRemoved, DiffText: RenderDiff(ApplyIgnoreRules(content, rules), "")})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// sourceChangesDiffHTML joins the diffs of changed original files, each under
+// a heading with its path, for notifications.
+func sourceChangesDiffHTML(changes []models.SourceFileChange) string {
+	var b strings.Builder
+	for _, change := range changes {
+		fmt.Fprintf(&b, "<strong>%s (%s)</strong><br>%s<br><br>", html.EscapeString(change.Path), change.Status, change.DiffText)
+	}
+	return b.String()
+}
+
+// sourceChangeSummaries describes changed original files as "path (status)".
+func sourceChangeSummaries(changes []models.SourceFileChange) []string {
+	summaries := make([]string, len(changes))
+	for i, change := range changes {
+		summaries[i] = fmt.Sprintf("%s (%s)", change.Path, change.Status)
+	}
+	return summaries
+}
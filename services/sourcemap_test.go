@@ -0,0 +1,283 @@
+package services
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go-js-watcher/models"
+)
+
+func TestFindSourceMapURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers http.Header
+		content string
+		want    string
+	}{
+		{"none", nil, "x=1", ""},
+		{"comment", nil, "x=1\n//# sourceMappingURL=app.js.map", "https://example.com/js/app.js.map"},
+		{"legacy comment", nil, "x=1\n//@ sourceMappingURL=app.js.map", "https://example.com/js/app.js.map"},
+		{"css comment", nil, "a{}\n/*# sourceMappingURL=app.css.map */", "https://example.com/js/app.css.map"},
+		{"last comment wins", nil, "//# sourceMappingURL=old.map\nx=1\n//# sourceMappingURL=/maps/new.map", "https://example.com/maps/new.map"},
+		{"fragment dropped", nil, "//# sourceMappingURL=app.js.map#x", "https://example.com/js/app.js.map"},
+		{"header", http.Header{"Sourcemap": {"/h.map"}}, "//# sourceMappingURL=c.map", "https://example.com/h.map"},
+		{"legacy header", http.Header{"X-Sourcemap": {"https://maps.example.net/x.map"}}, "", "https://maps.example.net/x.map"},
+		{"data url", nil, "//# sourceMappingURL=data:application/json;base64,e30=", "data:application/json;base64,e30="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := tt.headers
+			if headers == nil {
+				headers = http.Header{}
+			}
+			if got := FindSourceMapURL("https://example.com/js/app.js", headers, tt.content); got != tt.want {
+				t.Errorf("FindSourceMapURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourcePath(t *testing.T) {
+	tests := []struct {
+		root, source, want string
+	}{
+		{"", "webpack://app/./src/a.ts", "app/src/a.ts"},
+		{"", "webpack:///./node_modules/lib/index.js", "node_modules/lib/index.js"},
+		{"", "../src/./b.js", "../src/b.js"},
+		{"", "/abs/c.js", "abs/c.js"},
+		{"src/", "d.js", "src/d.js"},
+		{"src", "/e.js", "e.js"},
+		{"src", "webpack://app/f.js", "app/f.js"},
+		{"", "webpack://app/./src/App.vue?vue&type=script", "app/src/App.vue?vue&type=script"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			if got := SourcePath(tt.root, tt.source); got != tt.want {
+				t.Errorf("SourcePath(%q, %q) = %q, want %q", tt.root, tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSourceMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "embedded sources",
+			data: `{"version":3,"sourceRoot":"","sources":["webpack://app/./src/a.ts","webpack://app/./src/b.ts"],"sourcesContent":["const a = 1","const b = 2"],"mappings":""}`,
+			want: map[string]string{"app/src/a.ts": "const a = 1", "app/src/b.ts": "const b = 2"},
+		},
+		{
+			name: "missing and null sourcesContent are left out",
+			data: `{"version":3,"sources":["a.js","b.js","c.js"],"sourcesContent":["a",null]}`,
+			want: map[string]string{"a.js": "a"},
+		},
+		{
+			name: "no sourcesContent",
+			data: `{"version":3,"sources":["a.js"],"mappings":"AAAA"}`,
+			want: map[string]string{},
+		},
+		{
+			name: "sourceRoot",
+			data: `{"version":3,"sourceRoot":"/src/","sources":["a.js"],"sourcesContent":["a"]}`,
+			want: map[string]string{"src/a.js": "a"},
+		},
+		{
+			name: "first of duplicate paths wins",
+			data: `{"version":3,"sources":["./a.js","a.js"],"sourcesContent":["first","second"]}`,
+			want: map[string]string{"a.js": "first"},
+		},
+		{
+			name: "index map sections",
+			data: `{"version":3,"sections":[{"offset":{"line":0,"column":0},"map":{"version":3,"sources":["one.js"],"sourcesContent":["1"]}},{"offset":{"line":9,"column":0},"map":{"version":3,"sources":["two.js"],"sourcesContent":["2"]}}]}`,
+			want: map[string]string{"one.js": "1", "two.js": "2"},
+		},
+		{
+			name:    "invalid json",
+			data:    `{"version":3,`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSourceMap([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSourceMap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSourceMap() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeDataURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{"data:application/json;charset=utf-8;base64,eyJ2ZXJzaW9uIjozfQ==", `{"version":3}`, false},
+		{"data:application/json,%7B%22version%22%3A3%7D", `{"version":3}`, false},
+		{"data:application/json;base64,!!!", "", true},
+		{"data:application/json", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, err := decodeDataURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeDataURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("decodeDataURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSources(t *testing.T) {
+	const sourceMap = `{"version":3,"sources":["webpack://app/./src/a.ts"],"sourcesContent":["export const a = 1"]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app.js.map":
+			w.Write([]byte(sourceMap))
+		case "/bare.js.map":
+			w.Write([]byte(`{"version":3,"sources":["a.js"]}`))
+		case "/broken.js.map":
+			w.Write([]byte(`not json`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	inline := "data:application/json;base64," + base64.StdEncoding.EncodeToString([]byte(sourceMap))
+
+	tests := []struct {
+		name       string
+		content    string
+		want       map[string]string
+		wantMapURL string
+		wantStatus string
+	}{
+		{
+			name:       "fetched",
+			content:    "x=1\n//# sourceMappingURL=app.js.map",
+			want:       map[string]string{"app/src/a.ts": "export const a = 1"},
+			wantMapURL: server.URL + "/app.js.map",
+			wantStatus: "1 source files",
+		},
+		{
+			name:       "inline",
+			content:    "x=1\n//# sourceMappingURL=" + inline,
+			want:       map[string]string{"app/src/a.ts": "export const a = 1"},
+			wantMapURL: "(inline)",
+			wantStatus: "1 source files",
+		},
+		{
+			name:    "no source map",
+			content: "x=1",
+		},
+		{
+			name:       "sources not embedded",
+			content:    "//# sourceMappingURL=bare.js.map",
+			wantMapURL: server.URL + "/bare.js.map",
+			wantStatus: "The source map does not embed its sources",
+		},
+		{
+			name:       "invalid",
+			content:    "//# sourceMappingURL=broken.js.map",
+			wantMapURL: server.URL + "/broken.js.map",
+			wantStatus: "Invalid source map: invalid character 'o' in literal null (expecting 'u')",
+		},
+		{
+			name:       "not found",
+			content:    "//# sourceMappingURL=missing.js.map",
+			wantMapURL: server.URL + "/missing.js.map",
+			wantStatus: "Failed to load source map: " + server.URL + "/missing.js.map returned 404 Not Found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := models.WatchedUrl{URL: server.URL + "/app.js", AllowPrivateTarget: true}
+			got := loadSources(&entry, http.Header{}, tt.content, nil)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadSources() = %q, want %q", got, tt.want)
+			}
+			if entry.SourceMapURL != tt.wantMapURL || entry.SourceMapStatus != tt.wantStatus {
+				t.Errorf("source map = %q, %q, want %q, %q", entry.SourceMapURL, entry.SourceMapStatus, tt.wantMapURL, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestDiffSourceFiles(t *testing.T) {
+	previous := []models.SourceFile{
+		{Path: "src/kept.ts", Content: "same"},
+		{Path: "src/changed.ts", Content: "v1"},
+		{Path: "src/stamped.ts", Content: "built at 1"},
+		{Path: "src/gone.ts", Content: "bye"},
+	}
+	current := map[string]string{
+		"src/kept.ts":    "same",
+		"src/changed.ts": "v2",
+		"src/stamped.ts": "built at 2",
+		"src/new.ts":     "hi",
+	}
+	rules := []models.IgnoreRule{{Kind: IgnoreRuleRegex, Pattern: `built at \d+`, Replacement: "built at"}}
+
+	var got []string
+	for _, change := range diffSourceFiles(previous, current, rules) {
+		got = append(got, change.Path+" "+change.Status)
+		if change.DiffText == "" {
+			t.Errorf("%s has no diff", change.Path)
+		}
+	}
+	want := []string{"src/changed.ts modified", "src/gone.ts removed", "src/new.ts added"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffSourceFiles() = %q, want %q", got, want)
+	}
+}
+
+func TestRecordSourceChanges(t *testing.T) {
+	db := newTestDB(t)
+
+	// The first source files are a baseline.
+	changes, err := recordSourceChanges(db, 1, 7, map[string]string{"a.ts": "1"}, nil)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("first recordSourceChanges() = %v, %v, want no changes", changes, err)
+	}
+
+	// Without a change event the files are only refreshed.
+	if changes, err = recordSourceChanges(db, 1, 0, map[string]string{"a.ts": "2"}, nil); err != nil || len(changes) != 0 {
+		t.Fatalf("refreshing recordSourceChanges() = %v, %v, want no changes", changes, err)
+	}
+
+	changes, err = recordSourceChanges(db, 1, 8, map[string]string{"a.ts": "3", "b.ts": "new"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, change := range changes {
+		if change.ChangeEventID != 8 {
+			t.Errorf("%s has change event %d, want 8", change.Path, change.ChangeEventID)
+		}
+		got = append(got, change.Path+" "+change.Status)
+	}
+	if want := []string{"a.ts modified", "b.ts added"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("recordSourceChanges() = %q, want %q", got, want)
+	}
+
+	var stored []models.SourceFile
+	db.Where("url_id = ?", 1).Order("path").Find(&stored)
+	if len(stored) != 2 || stored[0].Content != "3" {
+		t.Errorf("stored files = %+v, want the latest", stored)
+	}
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrTargetBlocked is returned (wrapped) when a fetch target is not allowed by
+// the target policy.
+var ErrTargetBlocked = errors.New("blocked by the fetch target policy")
+
+// DefaultBlockedNetworks are the ranges fetches may not reach unless a URL has
+// an admin override: loopback, private, link-local (including cloud metadata
+// endpoints such as 169.254.169.254), carrier-grade NAT, benchmarking,
+// multicast, reserved and unspecified addresses, and NAT64 addresses, which
+// reach IPv4 hosts through a translator. IPv6 addresses that carry an IPv4
+// address (6to4, NAT64, Teredo) are also checked by that IPv4 address.
+var DefaultBlockedNetworks = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+var (
+	// AllowedSchemes are the URL schemes that may be watched or extracted from.
+	AllowedSchemes = []string{"http", "https"}
+	// BlockedNetworks are the address ranges fetches may not connect to.
+	BlockedNetworks = mustParseNetworks(DefaultBlockedNetworks)
+)
+
+// ParseNetworks parses a list of CIDR ranges. A bare IP address blocks just
+// that address.
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network %q", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %v", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func mustParseNetworks(cidrs []string) []*net.IPNet {
+	networks, err := ParseNetworks(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+// checkIP returns an ErrTargetBlocked error if ip is in a blocked range, or
+// is an IPv6 address that carries an IPv4 address in a blocked range.
+func checkIP(ip net.IP) error {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4 // Catches IPv4-mapped IPv6 addresses like ::ffff:127.0.0.1
+	}
+	embedded := embeddedIPv4(ip)
+	for _, network := range BlockedNetworks {
+		if network.Contains(ip) {
+			return fmt.Errorf("%w: %s is in %s", ErrTargetBlocked, ip, network)
+		}
+		if embedded != nil && network.Contains(embedded) {
+			return fmt.Errorf("%w: %s carries %s, which is in %s", ErrTargetBlocked, ip, embedded, network)
+		}
+	}
+	return nil
+}
+
+var (
+	sixToFourPrefix = mustParseNetworks([]string{"2002::/16"})[0]
+	nat64Prefix     = mustParseNetworks([]string{"64:ff9b::/96"})[0]
+	teredoPrefix    = mustParseNetworks([]string{"2001::/32"})[0]
+	ipv4Compatible  = mustParseNetworks([]string{"::/96"})[0]
+)
+
+// embeddedIPv4 returns the IPv4 address an IPv6 address reaches through a
+// transition mechanism: 6to4 (2002:AABB:CCDD::), NAT64 (64:ff9b::AABB:CCDD),
+// Teredo (the client address, inverted, in the last 32 bits of 2001::/32) and
+// the deprecated IPv4-compatible form (::AABB:CCDD). It returns nil for other
+// addresses.
+func embeddedIPv4(ip net.IP) net.IP {
+	ip16 := ip.To16()
+	if ip16 == nil || ip.To4() != nil {
+		return nil
+	}
+	switch {
+	case sixToFourPrefix.Contains(ip16):
+		return net.IPv4(ip16[2], ip16[3], ip16[4], ip16[5]).To4()
+	case nat64Prefix.Contains(ip16), ipv4Compatible.Contains(ip16):
+		return net.IPv4(ip16[12], ip16[13], ip16[14], ip16[15]).To4()
+	case teredoPrefix.Contains(ip16):
+		return net.IPv4(^ip16[12], ^ip16[13], ^ip16[14], ^ip16[15]).To4()
+	}
+	return nil
+}
+
+func checkScheme(scheme string) error {
+	for _, allowed := range AllowedSchemes {
+		if strings.EqualFold(scheme, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: the %q scheme is not allowed", ErrTargetBlocked, scheme)
+}
+
+// CheckTargetURL checks a URL against the target policy before it is saved
+// or extracted from, so users get immediate feedback. Its host is resolved
+// and every address checked; a host that doesn't resolve yet is let through,
+// since the address is checked again on every connection anyway. With
+// allowPrivate only the scheme is checked.
+func CheckTargetURL(rawURL string, allowPrivate bool) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%q is not a valid URL", rawURL)
+	}
+	if err := checkScheme(parsed.Scheme); err != nil {
+		return err
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("%q is not an absolute URL", rawURL)
+	}
+	if allowPrivate {
+		return nil
+	}
+
+	host := parsed.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return checkIP(ip)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if err := checkIP(addr.IP); err != nil {
+			return fmt.Errorf("%s resolves to a blocked address (%w)", host, err)
+		}
+	}
+	return nil
+}
+
+var (
+	fetchClientsOnce sync.Once
+	guardedClient    *http.Client
+	unguardedClient  *http.Client
+)
+
+// FetchClient returns the HTTP client for fetching watched URLs and pages to
+// extract from. The address is checked on the socket itself, right before each
+// connection, so neither a redirect nor a DNS answer that changes after
+// CheckTargetURL can reach a blocked range. allowPrivate is the per-URL admin
+// override and skips the address check.
+func FetchClient(allowPrivate bool) *http.Client {
+	fetchClientsOnce.Do(func() {
+		guardedClient = newFetchClient(20*time.Second, false)
+		unguardedClient = newFetchClient(20*time.Second, true)
+	})
+	if allowPrivate {
+		return unguardedClient
+	}
+	return guardedClient
+}
+
+func newFetchClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("%w: unexpected address %q", ErrTargetBlocked, address)
+			}
+			return checkIP(ip)
+		}
+	}
+
+	// No proxy: a proxy would make the connection, and its own address is all
+	// the dialer could check.
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+				stripCrossHostHeaders(req.Header)
+			}
+			return checkScheme(req.URL.Scheme)
+		},
+	}
+}
+
+// crossHostHeaders are the only request headers kept when a redirect leaves
+// the original host.
+var crossHostHeaders = map[string]bool{"User-Agent": true, "Accept": true, "Accept-Language": true, "Referer": true}
+
+// stripCrossHostHeaders removes every other header on a redirect to another
+// host. Go itself only drops Authorization and Cookie, so the custom headers
+// from request settings (API keys and other secrets) would otherwise be sent
+// to wherever the target redirects.
+func stripCrossHostHeaders(header http.Header) {
+	for name := range header {
+		if !crossHostHeaders[http.CanonicalHeaderKey(name)] {
+			header.Del(name)
+		}
+	}
+}
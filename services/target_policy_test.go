@@ -0,0 +1,198 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseNetworks(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidrs   []string
+		want    []string
+		wantErr bool
+	}{
+		{"cidr", []string{"10.0.0.0/8"}, []string{"10.0.0.0/8"}, false},
+		{"cidr with host bits", []string{"192.168.1.5/16"}, []string{"192.168.0.0/16"}, false},
+		{"bare ipv4", []string{"203.0.113.7"}, []string{"203.0.113.7/32"}, false},
+		{"bare ipv6", []string{"2001:db8::1"}, []string{"2001:db8::1/128"}, false},
+		{"ipv6 cidr", []string{"fc00::/7"}, []string{"fc00::/7"}, false},
+		{"invalid ip", []string{"example.com"}, nil, true},
+		{"invalid cidr", []string{"10.0.0.0/33"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, err := ParseNetworks(tt.cidrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNetworks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(networks) != len(tt.want) {
+				t.Fatalf("ParseNetworks() = %v, want %v", networks, tt.want)
+			}
+			for i, network := range networks {
+				if network.String() != tt.want[i] {
+					t.Errorf("network %d = %s, want %s", i, network, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"198.18.0.1", true},
+		{"198.19.255.255", true},
+		{"224.0.0.251", true},
+		{"239.255.255.250", true},
+		{"240.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::", true},
+		{"::1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"64:ff9b::7f00:1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:169.254.169.254", true},
+		{"2002:7f00:1::", true},
+		{"2002:a9fe:a9fe::1", true},
+		{"2002:c0a8:101:1::1", true},
+		{"2001:0:4136:e378:8000:63bf:80ff:fffe", true}, // Teredo client 127.0.0.1
+		{"::7f00:1", true},
+		{"::a00:1", true},
+		{"93.184.216.34", false},
+		{"198.20.0.1", false},
+		{"223.255.255.255", false},
+		{"::ffff:93.184.216.34", false},
+		{"2606:4700::1111", false},
+		{"64:ff9b:1::1", false},
+		{"2002:5db8:d822::1", false},
+		{"2001:0:4136:e378:8000:63bf:a247:27dd", false}, // Teredo client 93.184.216.34
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			err := checkIP(net.ParseIP(tt.ip))
+			if blocked := errors.Is(err, ErrTargetBlocked); blocked != tt.blocked {
+				t.Errorf("checkIP(%s) = %v, want blocked %v", tt.ip, err, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestCheckIPEmbeddedWithCustomNetworks(t *testing.T) {
+	defer func(saved []*net.IPNet) { BlockedNetworks = saved }(BlockedNetworks)
+	BlockedNetworks = mustParseNetworks([]string{"10.0.0.0/8"})
+
+	// NAT64 isn't blocked as a range here, but what it reaches still is.
+	for ip, blocked := range map[string]bool{"64:ff9b::a00:1": true, "64:ff9b::5db8:d822": false} {
+		if err := checkIP(net.ParseIP(ip)); errors.Is(err, ErrTargetBlocked) != blocked {
+			t.Errorf("checkIP(%s) = %v, want blocked %v", ip, err, blocked)
+		}
+	}
+}
+
+func TestCheckTargetURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		url          string
+		allowPrivate bool
+		wantErr      bool
+	}{
+		{"public ip", "https://93.184.216.34/app.js", false, false},
+		{"loopback", "http://127.0.0.1:8080/app.js", false, true},
+		{"ipv4-mapped loopback", "http://[::ffff:127.0.0.1]/app.js", false, true},
+		{"metadata", "http://169.254.169.254/latest/meta-data/", false, true},
+		{"override", "http://127.0.0.1/app.js", true, false},
+		{"scheme", "file:///etc/passwd", false, true},
+		{"scheme with override", "ftp://example.com/app.js", true, true},
+		{"relative", "/app.js", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckTargetURL(tt.url, tt.allowPrivate); (err != nil) != tt.wantErr {
+				t.Errorf("CheckTargetURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExternalToolsAllowed(t *testing.T) {
+	defer func(networks []*net.IPNet) { BlockedNetworks = networks }(BlockedNetworks)
+
+	tests := []struct {
+		name         string
+		blocked      []*net.IPNet
+		allowPrivate bool
+		want         bool
+	}{
+		{"policy active", mustParseNetworks(DefaultBlockedNetworks), false, false},
+		{"policy active with override", mustParseNetworks(DefaultBlockedNetworks), true, true},
+		{"policy off", nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			BlockedNetworks = tt.blocked
+			if got := ExternalToolsAllowed(tt.allowPrivate); got != tt.want {
+				t.Errorf("ExternalToolsAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchClientRedirectHeaders(t *testing.T) {
+	var got http.Header
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/same" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+			return
+		}
+		got = r.Header.Clone()
+	}))
+	defer target.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL+"/final", http.StatusFound)
+	}))
+	defer origin.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		wantKept bool
+	}{
+		{"same host", target.URL + "/same", true},
+		{"other host", origin.URL, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+			req.Header.Set("User-Agent", "watcher-test")
+			req.Header.Set("X-Api-Key", "secret")
+			resp, err := FetchClient(true).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if got.Get("User-Agent") != "watcher-test" {
+				t.Errorf("User-Agent = %q, want it kept", got.Get("User-Agent"))
+			}
+			if kept := got.Get("X-Api-Key") != ""; kept != tt.wantKept {
+				t.Errorf("X-Api-Key kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
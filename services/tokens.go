@@ -0,0 +1,49 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Prefixes mark generated tokens so they are easy to recognise, e.g. in secret scanners.
+const (
+	apiTokenPrefix  = "jsw_"
+	feedTokenPrefix = "jswf_"
+)
+
+// randomToken returns prefix followed by 32 random bytes in hex.
+func randomToken(prefix string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(buf), nil
+}
+
+// GenerateAPIToken returns a new random API token and the hash to store for it.
+func GenerateAPIToken() (token, hash string, err error) {
+	token, err = randomToken(apiTokenPrefix)
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashAPIToken(token), nil
+}
+
+// HashAPIToken returns the value stored in APIToken.TokenHash for a token.
+func HashAPIToken(token string) string {
+	return ContentHash(token)
+}
+
+// GenerateFeedToken returns a new random feed token, its hash for
+// Feed.TokenHash and its encrypted form for Feed.Token.
+func GenerateFeedToken() (token, hash, encrypted string, err error) {
+	token, err = randomToken(feedTokenPrefix)
+	if err != nil {
+		return "", "", "", err
+	}
+	encrypted, err = EncryptSecret(token)
+	if err != nil {
+		return "", "", "", err
+	}
+	return token, ContentHash(token), encrypted, nil
+}
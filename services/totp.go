@@ -0,0 +1,243 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"image/png"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-js-watcher/models"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	// TOTPIssuer is the name authenticator apps show next to the account.
+	TOTPIssuer = "JS Watcher"
+
+	totpPeriod = 30
+	totpSkew   = 1 // Accept codes from one period before and after the current one
+
+	// RecoveryCodeCount is how many single-use recovery codes a user gets.
+	RecoveryCodeCount = 10
+
+	// MaxSecondFactorFailures is how many wrong codes at the second login step
+	// abandon the login. Further logins stop at that step until no code has
+	// been entered for LoginLockout.
+	MaxSecondFactorFailures = 5
+)
+
+var totpOpts = totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+
+// StartTOTPEnrollment gives the user a new TOTP secret. The secret is stored
+// encrypted but not active until ConfirmTOTPEnrollment sees a valid code for it.
+func StartTOTPEnrollment(db *gorm.DB, user *models.User) error {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: TOTPIssuer, AccountName: user.Username})
+	if err != nil {
+		return err
+	}
+	encrypted, err := EncryptSecret(key.Secret())
+	if err != nil {
+		return err
+	}
+	user.TOTPSecret = encrypted
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	return db.Model(user).Select("totp_secret", "totp_enabled", "totp_last_step").Updates(user).Error
+}
+
+// TOTPProvisioning returns the secret and the otpauth:// URL for the user's
+// TOTP key, plus the URL as a QR code PNG in a data: URI for the enrollment page.
+func TOTPProvisioning(user *models.User) (secret, keyURL, qrDataURI string, err error) {
+	secret, err = DecryptSecret(user.TOTPSecret)
+	if err != nil {
+		return "", "", "", err
+	}
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", TOTPIssuer)
+	query.Set("period", "30")
+	query.Set("digits", "6")
+	query.Set("algorithm", "SHA1")
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + TOTPIssuer + ":" + user.Username, RawQuery: query.Encode()}
+
+	key, err := otp.NewKeyFromURL(u.String())
+	if err != nil {
+		return "", "", "", err
+	}
+	img, err := key.Image(220, 220)
+	if err != nil {
+		return "", "", "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", "", "", err
+	}
+	return secret, key.URL(), "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// ConfirmTOTPEnrollment turns on two-factor authentication once the user
+// proves their authenticator app has the secret, and returns the user's new
+// recovery codes.
+func ConfirmTOTPEnrollment(db *gorm.DB, user *models.User, code string) ([]string, error) {
+	if user.TOTPSecret == "" {
+		return nil, errors.New("two-factor enrollment was not started")
+	}
+	ok, err := VerifyTOTP(db, user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("the code is not valid, check the time on your device and try again")
+	}
+	if result := db.Model(user).Update("totp_enabled", true); result.Error != nil {
+		return nil, result.Error
+	}
+	return GenerateRecoveryCodes(db, user.ID)
+}
+
+// DisableTOTP removes the user's TOTP secret and recovery codes.
+func DisableTOTP(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}); result.Error != nil {
+			return result.Error
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_secret": "", "totp_enabled": false, "totp_last_step": 0, "second_factor_failures": 0, "second_factor_failed_at": nil}).Error
+	})
+}
+
+// VerifyTOTP checks a six-digit code against the user's secret. Every time
+// step is accepted at most once, so an observed code can't be replayed.
+func VerifyTOTP(db *gorm.DB, user *models.User, code string) (bool, error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != 6 || user.TOTPSecret == "" {
+		return false, nil
+	}
+	secret, err := DecryptSecret(user.TOTPSecret)
+	if err != nil {
+		return false, err
+	}
+
+	current := time.Now().UTC().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= user.TOTPLastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0).UTC(), totpOpts)
+		if err != nil {
+			return false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+		// The condition makes concurrent logins with the same code race safely.
+		result := db.Model(&models.User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+		if result.Error != nil {
+			return false, result.Error
+		}
+		user.TOTPLastStep = step
+		return result.RowsAffected == 1, nil
+	}
+	return false, nil
+}
+
+// ReserveSecondFactorAttempt counts a second-step login attempt against the
+// user before the code is checked, so parallel guesses can't get past the
+// limit, and reports whether the attempt may go ahead. The count is kept in
+// the database rather than the session, which the client could replay, and
+// restarts once the last counted attempt is older than LoginLockout. Attempts
+// made while locked out don't move that time, so the lockout ends LoginLockout
+// after the attempt that started it. A correct code clears the count with
+// ClearSecondFactorFailures.
+func ReserveSecondFactorAttempt(db *gorm.DB, userID uint) (bool, error) {
+	now := time.Now().UTC()
+	// Both expressions see the row as it was before the update.
+	expired := "second_factor_failed_at IS NULL OR second_factor_failed_at < ?"
+	cutoff := now.Add(-LoginLockout)
+	result := db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"second_factor_failures": gorm.Expr("CASE WHEN "+expired+" THEN 1 ELSE second_factor_failures + 1 END", cutoff),
+		"second_factor_failed_at": gorm.Expr("CASE WHEN "+expired+" OR second_factor_failures < ? THEN ? ELSE second_factor_failed_at END",
+			cutoff, MaxSecondFactorFailures, now),
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	failures, err := SecondFactorFailures(db, userID)
+	return failures <= MaxSecondFactorFailures, err
+}
+
+// SecondFactorFailures returns the user's recent second-step attempts that
+// were not followed by a correct code.
+func SecondFactorFailures(db *gorm.DB, userID uint) (int, error) {
+	var user models.User
+	if result := db.Select("id", "second_factor_failures").First(&user, userID); result.Error != nil {
+		return 0, result.Error
+	}
+	return user.SecondFactorFailures, nil
+}
+
+// ClearSecondFactorFailures resets the count after a correct code.
+func ClearSecondFactorFailures(db *gorm.DB, userID uint) error {
+	return db.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"second_factor_failures": 0, "second_factor_failed_at": nil}).Error
+}
+
+// GenerateRecoveryCodes replaces the user's recovery codes and returns the new
+// codes. Only their hashes are stored, so they can only be shown this once.
+func GenerateRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	rows := make([]models.RecoveryCode, RecoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := hex.EncodeToString(buf)
+		codes[i] = raw[:5] + "-" + raw[5:]
+		rows[i] = models.RecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(codes[i])}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}); result.Error != nil {
+			return result.Error
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode consumes one of the user's unused recovery codes.
+func UseRecoveryCode(db *gorm.DB, userID uint, code string) (bool, error) {
+	now := time.Now().UTC()
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).
+		Update("used_at", now)
+	return result.RowsAffected == 1, result.Error
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has left.
+func CountRecoveryCodes(db *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	result := db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count)
+	return count, result.Error
+}
+
+// hashRecoveryCode normalizes a code the way users tend to mistype it (case,
+// spaces, missing dash) before hashing.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+	return ContentHash(code)
+}
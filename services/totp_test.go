@@ -0,0 +1,153 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go-js-watcher/models"
+
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// newTOTPUser creates a user with two-factor authentication turned on.
+func newTOTPUser(t *testing.T, db *gorm.DB, lastStep int64) *models.User {
+	t.Helper()
+	SetEncryptionKey([]byte("test"))
+	encrypted, err := EncryptSecret(testTOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: "alice", PasswordHash: "x", TOTPSecret: encrypted, TOTPEnabled: true, TOTPLastStep: lastStep}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func totpCode(t *testing.T, step int64) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(testTOTPSecret, time.Unix(step*totpPeriod, 0).UTC(), totpOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	// Keep the test's time step and VerifyTOTP's the same.
+	if left := totpPeriod - time.Now().Unix()%totpPeriod; left < 2 {
+		time.Sleep(time.Duration(left) * time.Second)
+	}
+	current := time.Now().UTC().Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		lastStep int64
+		code     string
+		want     bool
+	}{
+		{"current step", 0, totpCode(t, current), true},
+		{"previous step within skew", 0, totpCode(t, current-1), true},
+		{"next step within skew", 0, totpCode(t, current+1), true},
+		{"outside skew", 0, totpCode(t, current-2), false},
+		{"step already used", current, totpCode(t, current), false},
+		{"older than last used step", current, totpCode(t, current-1), false},
+		{"newer than last used step", current - 1, totpCode(t, current), true},
+		{"spaces are ignored", 0, totpCode(t, current)[:3] + " " + totpCode(t, current)[3:], true},
+		{"wrong length", 0, "12345", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			user := newTOTPUser(t, db, tt.lastStep)
+
+			got, err := VerifyTOTP(db, user, tt.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("VerifyTOTP() = %v, want %v", got, tt.want)
+			}
+			if !got {
+				return
+			}
+
+			// A code that was accepted can't be replayed, even by a user
+			// loaded before it was used.
+			stale := *user
+			stale.TOTPLastStep = tt.lastStep
+			for _, u := range []*models.User{user, &stale} {
+				if again, err := VerifyTOTP(db, u, tt.code); err != nil || again {
+					t.Errorf("replayed VerifyTOTP() = %v, %v, want false", again, err)
+				}
+			}
+		})
+	}
+}
+
+func TestReserveSecondFactorAttempt(t *testing.T) {
+	db := newTestDB(t)
+	user := newTOTPUser(t, db, 0)
+
+	for i := 1; i <= MaxSecondFactorFailures+1; i++ {
+		allowed, err := ReserveSecondFactorAttempt(db, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i <= MaxSecondFactorFailures; allowed != want {
+			t.Errorf("attempt %d: allowed = %v, want %v", i, allowed, want)
+		}
+	}
+
+	// Failures older than the lockout no longer count.
+	old := time.Now().UTC().Add(-LoginLockout - time.Minute)
+	db.Model(user).Update("second_factor_failed_at", old)
+	if allowed, err := ReserveSecondFactorAttempt(db, user.ID); err != nil || !allowed {
+		t.Errorf("after the lockout: allowed = %v, %v, want true", allowed, err)
+	}
+	if failures, _ := SecondFactorFailures(db, user.ID); failures != 1 {
+		t.Errorf("after the lockout: failures = %d, want 1", failures)
+	}
+
+	if err := ClearSecondFactorFailures(db, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if failures, _ := SecondFactorFailures(db, user.ID); failures != 0 {
+		t.Errorf("after clearing: failures = %d, want 0", failures)
+	}
+}
+
+func TestSecondFactorLockoutIsNotExtended(t *testing.T) {
+	db := newTestDB(t)
+	user := newTOTPUser(t, db, 0)
+
+	for i := 0; i < MaxSecondFactorFailures; i++ {
+		if _, err := ReserveSecondFactorAttempt(db, user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The attempt that used up the limit was made almost a lockout ago.
+	lockedAt := time.Now().UTC().Add(-LoginLockout + time.Minute)
+	db.Model(user).Update("second_factor_failed_at", lockedAt)
+
+	// Guesses during the lockout are refused and don't extend it.
+	for i := 0; i < 3; i++ {
+		if allowed, err := ReserveSecondFactorAttempt(db, user.ID); err != nil || allowed {
+			t.Fatalf("guess during the lockout: allowed = %v, %v, want false", allowed, err)
+		}
+	}
+	var saved models.User
+	db.First(&saved, user.ID)
+	if saved.SecondFactorFailedAt == nil || !saved.SecondFactorFailedAt.Equal(lockedAt) {
+		t.Fatalf("lockout start moved from %v to %v", lockedAt, saved.SecondFactorFailedAt)
+	}
+
+	// Once the lockout after that attempt is over, codes are accepted again.
+	db.Model(user).Update("second_factor_failed_at", lockedAt.Add(-2*time.Minute))
+	if allowed, err := ReserveSecondFactorAttempt(db, user.ID); err != nil || !allowed {
+		t.Errorf("after the lockout: allowed = %v, %v, want true", allowed, err)
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"go-js-watcher/models"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// MinPasswordLength is the shortest password accepted for new or changed passwords.
+const MinPasswordLength = 8
+
+// dummyPasswordHash is compared against when a login names an unknown user, so
+// that unknown and known usernames take the same time to reject.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+// HashPassword returns the bcrypt hash stored in User.PasswordHash.
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	// bcrypt only uses the first 72 bytes and rejects longer input.
+	if len(password) > 72 {
+		return "", fmt.Errorf("password must be at most 72 bytes")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Authenticate returns the user with the given username if the password matches.
+func Authenticate(db *gorm.DB, username, password string) (*models.User, error) {
+	var user models.User
+	result := db.Where("username = ?", username).First(&user)
+	if result.Error == gorm.ErrRecordNotFound {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, nil
+	} else if result.Error != nil {
+		return nil, result.Error
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+// CheckPassword reports whether password matches the user's stored hash.
+func CheckPassword(user *models.User, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
+
+// BootstrapAdmin creates the first admin account from APP_USERNAME/APP_PASSWORD
+// when no users exist yet. Once any user exists the env vars are ignored.
+// API tokens created before user accounts existed are given to the new admin.
+func BootstrapAdmin(db *gorm.DB, username, password string) error {
+	var count int64
+	if result := db.Model(&models.User{}).Count(&count); result.Error != nil {
+		return result.Error
+	}
+	if count > 0 {
+		return nil
+	}
+
+	// The env password may predate the length rule, so hash it directly.
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	admin := models.User{Username: username, PasswordHash: string(hash), Role: models.RoleAdmin}
+	if result := db.Create(&admin); result.Error != nil {
+		return result.Error
+	}
+	if result := db.Model(&models.APIToken{}).Where("user_id = 0 OR user_id IS NULL").Update("user_id", admin.ID); result.Error != nil {
+		return result.Error
+	}
+
+	log.Printf("Created admin user %q from APP_USERNAME/APP_PASSWORD. Manage users from the Users page.", username)
+	return nil
+}
@@ -1,12 +1,11 @@
 package services
 
 import (
+	"errors"
 	"fmt"
-	"html"
 	"io"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
@@ -15,56 +14,47 @@ import (
 
 	"github.com/sergi/go-diff/diffmatchpatch" // Character-level diffing
 	"gorm.io/gorm"
-
-	// Telegram Bot API package
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-// sendTelegramNotification sends a simple notification to Telegram.
-func sendTelegramNotification(botToken, chatID, url, diffLink string, isDowntimeAlert bool) {
-	if botToken == "" || chatID == "" {
-		log.Println("Telegram credentials not provided to notification function. Skipping notification.")
-		return
-	}
-
-	bot, err := tgbotapi.NewBotAPI(botToken)
-	if err != nil {
-		log.Printf("Failed to create Telegram bot API: %v", err)
-		return
-	}
-
-	var messageText string
-	if isDowntimeAlert {
-		messageText = fmt.Sprintf("<b>Downtime Alert:</b> URL %s appears to be down.", html.EscapeString(url))
-	} else {
-		messageText = fmt.Sprintf("<b>Change detected in:</b> %s\n\n", html.EscapeString(url))
-		if diffLink != "" {
-			messageText += fmt.Sprintf("View details on the dashboard:\n\n%s", html.EscapeString(diffLink))
-		} else {
-			messageText += "View details on the dashboard."
-		}
-	}
+// RenderDiff computes a semantic character-level diff between two versions and
+// renders it as HTML.
+func RenderDiff(oldContent, newContent string) string {
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMain(oldContent, newContent, true)
+	dmp.DiffCleanupSemantic(diffs)
+	htmlDiff := dmp.DiffPrettyHtml(diffs)
+	return strings.ReplaceAll(htmlDiff, "&para;<br>", "<br>")
+}
 
-	// Telegram chat ID must be an int64. Parse it.
-	parsedChatID, err := strconv.ParseInt(chatID, 10, 64)
-	if err != nil {
-		log.Printf("Invalid Telegram Chat ID '%s': %v", chatID, err)
-		return
-	}
-
-	msg := tgbotapi.NewMessage(parsedChatID, messageText)
-	msg.ParseMode = tgbotapi.ModeHTML
-	msg.DisableWebPagePreview = false
-
-	_, err = bot.Send(msg)
-	if err != nil {
-		log.Printf("Failed to send Telegram notification for %s: %v", url, err)
-	} else {
-		log.Printf("Telegram notification sent for %s", url)
+// saveURLEntry writes back the result of a check. Only the columns a check
+// sets are written, so edits made while the check ran are kept. The row must
+// still be live and have the address that was checked: a URL archived, purged
+// or renamed in the meantime doesn't get a result that isn't its own.
+func saveURLEntry(db *gorm.DB, urlEntry *models.WatchedUrl) *gorm.DB {
+	result := db.Model(&models.WatchedUrl{}).Where("id = ? AND url = ?", urlEntry.ID, urlEntry.URL).
+		Updates(map[string]interface{}{
+			"status":                urlEntry.Status,
+			"last_content":          urlEntry.LastContent,
+			"last_checked":          urlEntry.LastChecked,
+			"e_tag":                 urlEntry.ETag,
+			"last_modified":         urlEntry.LastModified,
+			"is_down":               urlEntry.IsDown,
+			"down_since":            urlEntry.DownSince,
+			"consecutive_failures":  urlEntry.ConsecutiveFailures,
+			"downtime_escalated":    urlEntry.DowntimeEscalated,
+			"chunks":                urlEntry.Chunks,
+			"chunks_found_at":       urlEntry.ChunksFoundAt,
+			"source_map_url":        urlEntry.SourceMapURL,
+			"source_map_status":     urlEntry.SourceMapStatus,
+			"endpoints_analyzed_at": urlEntry.EndpointsAnalyzedAt,
+		})
+	if result.Error == nil && result.RowsAffected == 0 {
+		log.Printf("URL ID %d was archived, removed or changed address during its check; dropping the result.", urlEntry.ID)
 	}
+	return result
 }
 
-func CheckURLForChanges(urlID uint, diffViewBaseURL, botToken, chatID string) string {
+func CheckURLForChanges(urlID uint, diffViewBaseURL string) string {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Recovered in CheckURLForChanges: %v", r)
@@ -85,94 +75,201 @@ func CheckURLForChanges(urlID uint, diffViewBaseURL, botToken, chatID string) st
 
 	const maxRetries = 3
 	const baseBackoff = 2 * time.Second
-	var lastErr error
 
-	client := &http.Client{
-		Timeout: 20 * time.Second,
+	client := FetchClient(urlEntry.AllowPrivateTarget)
+
+	requestConfigs, err := LoadRequestConfigs(db, urlEntry)
+	if err != nil {
+		log.Printf("Error loading request settings for %s: %v", urlEntry.URL, err)
 	}
 
 	var resp *http.Response
-	var err error
 
 	for i := 0; i < maxRetries; i++ {
-		lastErr = err
-		req, err := http.NewRequest("GET", urlEntry.URL, nil)
-		if err != nil {
-			urlEntry.Status = fmt.Sprintf("Failed to create request: %v", err)
-			db.Save(&urlEntry)
-			log.Printf("Error creating request for %s: %v", urlEntry.URL, err)
+		req, reqErr := http.NewRequest("GET", urlEntry.URL, nil)
+		if reqErr != nil {
+			urlEntry.Status = fmt.Sprintf("Failed to create request: %v", reqErr)
+			saveURLEntry(db, &urlEntry)
+			log.Printf("Error creating request for %s: %v", urlEntry.URL, reqErr)
 			return urlEntry.Status
 		}
-		req.Header.Set("User-Agent", "JS-Watcher-Bot/1.0 (Go)")
+		if configErr := ApplyRequestConfigs(req, requestConfigs); configErr != nil {
+			urlEntry.Status = fmt.Sprintf("Invalid request settings: %v", configErr)
+			saveURLEntry(db, &urlEntry)
+			log.Printf("Error applying request settings for %s: %v", urlEntry.URL, configErr)
+			return urlEntry.Status
+		}
+		// Let the server answer 304 Not Modified when we already hold the current version.
+		if urlEntry.LastContent != "" {
+			if urlEntry.ETag != "" {
+				req.Header.Set("If-None-Match", urlEntry.ETag)
+			}
+			if urlEntry.LastModified != "" {
+				req.Header.Set("If-Modified-Since", urlEntry.LastModified)
+			}
+		}
 
 		resp, err = client.Do(req)
-		if err == nil && resp.StatusCode == http.StatusOK {
+		if err == nil && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotModified) {
 			break // Success
 		}
+		if errors.Is(err, ErrTargetBlocked) {
+			break // Retrying won't change the policy
+		}
 
+		failure := fmt.Sprint(err)
 		if resp != nil {
+			failure = resp.Status
 			resp.Body.Close() // Close body on non-200 responses
 		}
 
-		lastErr = err
+		if i == maxRetries-1 {
+			break
+		}
 		backoff := baseBackoff * time.Duration(1<<i) // Exponential backoff
-		log.Printf("Attempt %d/%d for %s failed: %v. Retrying in %v...", i+1, maxRetries, urlEntry.URL, err, backoff)
+		log.Printf("Attempt %d/%d for %s failed: %s. Retrying in %v...", i+1, maxRetries, urlEntry.URL, failure, backoff)
 		time.Sleep(backoff)
 	}
 
+	if errors.Is(err, ErrTargetBlocked) {
+		urlEntry.Status = fmt.Sprintf("Not fetched: %v", err)
+		recordFailure(&urlEntry, urlEntry.Status)
+		saveURLEntry(db, &urlEntry)
+		log.Printf("Refused to fetch %s: %v", urlEntry.URL, err)
+		return urlEntry.Status
+	}
 	if err != nil {
-		urlEntry.Status = fmt.Sprintf("Failed after %d retries: %v", maxRetries, lastErr)
-		db.Save(&urlEntry)
-		log.Printf("Error fetching %s after multiple retries: %v", urlEntry.URL, lastErr)
-		sendTelegramNotification(botToken, chatID, urlEntry.URL, "", true)
+		urlEntry.Status = fmt.Sprintf("Failed after %d retries: %v", maxRetries, err)
+		recordFailure(&urlEntry, urlEntry.Status)
+		saveURLEntry(db, &urlEntry)
+		log.Printf("Error fetching %s after multiple retries: %v", urlEntry.URL, err)
 		return urlEntry.Status
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	notModified := resp.StatusCode == http.StatusNotModified && urlEntry.LastContent != ""
+	if resp.StatusCode != http.StatusOK && !notModified {
 		urlEntry.Status = fmt.Sprintf("HTTP Error %d: %s", resp.StatusCode, resp.Status)
-		db.Save(&urlEntry)
+		if isDownStatus(resp.StatusCode) {
+			recordFailure(&urlEntry, urlEntry.Status)
+		}
+		saveURLEntry(db, &urlEntry)
 		log.Printf("HTTP Error for %s: %d %s", urlEntry.URL, resp.StatusCode, resp.Status)
 		return urlEntry.Status
 	}
+	recordRecovery(&urlEntry)
+
+	if notModified {
+		now := time.Now().UTC()
+		urlEntry.LastChecked = &now
+		urlEntry.Status = "No changes"
+		if result := saveURLEntry(db, &urlEntry); result.Error != nil {
+			log.Printf("Error updating URL status for %s: %v", urlEntry.URL, result.Error)
+		}
+		log.Printf("Checked %s: not modified", urlEntry.URL)
+		return fmt.Sprintf("Checked %s: %s", urlEntry.URL, urlEntry.Status)
+	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		urlEntry.Status = fmt.Sprintf("Failed to read response body: %v", err)
-		db.Save(&urlEntry)
+		saveURLEntry(db, &urlEntry)
 		log.Printf("Error reading body for %s: %v", urlEntry.URL, err)
 		return urlEntry.Status
 	}
 	currentContent := string(bodyBytes)
 
 	now := time.Now().UTC()
+	previousChecked := urlEntry.LastChecked
 	urlEntry.LastChecked = &now // Always update LastChecked
+	urlEntry.ETag = resp.Header.Get("ETag")
+	urlEntry.LastModified = resp.Header.Get("Last-Modified")
+
+	ignoreRules, err := LoadIgnoreRules(db, urlEntry)
+	if err != nil {
+		log.Printf("Error loading ignore rules for %s: %v", urlEntry.URL, err)
+	}
 
 	if urlEntry.LastContent == "" {
+		if _, err := saveSnapshot(db, urlEntry.ID, currentContent, resp.Header, now); err != nil {
+			log.Printf("Error saving initial snapshot for %s: %v", urlEntry.URL, err)
+		}
 		urlEntry.LastContent = currentContent
 		urlEntry.Status = "Monitoring"
-		db.Save(&urlEntry)
+		discoverChunks(db, &urlEntry, currentContent)
+		if _, _, err := recordEndpoints(db, &urlEntry, ApplyIgnoreRules(currentContent, ignoreRules), now); err != nil {
+			log.Printf("Error saving endpoints for %s: %v", urlEntry.URL, err)
+		}
+		if files := loadSources(&urlEntry, resp.Header, currentContent, requestConfigs); files != nil {
+			if _, err := recordSourceChanges(db, urlEntry.ID, 0, files, nil); err != nil {
+				log.Printf("Error saving source files for %s: %v", urlEntry.URL, err)
+			}
+		}
+		saveURLEntry(db, &urlEntry)
 		log.Printf("Started watching %s. Initial content stored.", urlEntry.URL)
 		return fmt.Sprintf("Started watching %s. Initial content stored.", urlEntry.URL)
 	}
 
-	if currentContent != urlEntry.LastContent {
-		dmp := diffmatchpatch.New()
-		diffs := dmp.DiffMain(urlEntry.LastContent, currentContent, true)
-		dmp.DiffCleanupSemantic(diffs)
-		htmlDiff := dmp.DiffPrettyHtml(diffs)
-		htmlDiff = strings.ReplaceAll(htmlDiff, "&para;<br>", "<br>")
+	// Volatile parts (timestamps, nonces, cache busters...) are masked on both
+	// sides so that only meaningful differences count as a change.
+	maskedLast := ApplyIgnoreRules(urlEntry.LastContent, ignoreRules)
+	maskedCurrent := ApplyIgnoreRules(currentContent, ignoreRules)
+	contentChanged := currentContent != urlEntry.LastContent
+
+	if maskedCurrent != maskedLast {
+		// The previous version normally already has a snapshot; this only creates
+		// one for URLs that were being watched before snapshots existed.
+		previousFetchedAt := now
+		if previousChecked != nil {
+			previousFetchedAt = *previousChecked
+		}
+		previousSnapshot, err := saveSnapshot(db, urlEntry.ID, urlEntry.LastContent, nil, previousFetchedAt)
+		if err != nil {
+			log.Printf("Error saving previous snapshot for %s: %v", urlEntry.URL, err)
+		}
+		currentSnapshot, err := saveSnapshot(db, urlEntry.ID, currentContent, resp.Header, now)
+		if err != nil {
+			log.Printf("Error saving snapshot for %s: %v", urlEntry.URL, err)
+		}
+
+		oldForDiff, newForDiff := maskedLast, maskedCurrent
+		if urlEntry.Beautify {
+			contentType := resp.Header.Get("Content-Type")
+			oldForDiff = Beautify(oldForDiff, contentType, urlEntry.URL)
+			newForDiff = Beautify(newForDiff, contentType, urlEntry.URL)
+		}
+		htmlDiff := RenderDiff(oldForDiff, newForDiff)
+
+		// URLs watched before endpoints were extracted get their baseline from
+		// the previous content, so that this change is already reported.
+		if urlEntry.EndpointsAnalyzedAt == nil {
+			if _, _, err := recordEndpoints(db, &urlEntry, maskedLast, previousFetchedAt); err != nil {
+				log.Printf("Error saving endpoints for %s: %v", urlEntry.URL, err)
+			}
+		}
+		addedEndpoints, removedEndpoints, err := recordEndpoints(db, &urlEntry, maskedCurrent, now)
+		if err != nil {
+			log.Printf("Error saving endpoints for %s: %v", urlEntry.URL, err)
+		}
 
 		newChange := models.ChangeEvent{
-			URLID:      urlEntry.ID,
-			DiffText:   htmlDiff,
-			DetectedAt: now,
+			URLID:            urlEntry.ID,
+			DiffText:         htmlDiff,
+			DetectedAt:       now,
+			EndpointsAdded:   strings.Join(addedEndpoints, "\n"),
+			EndpointsRemoved: strings.Join(removedEndpoints, "\n"),
+		}
+		if previousSnapshot != nil {
+			newChange.PreviousSnapshotID = &previousSnapshot.ID
+		}
+		if currentSnapshot != nil {
+			newChange.SnapshotID = &currentSnapshot.ID
 		}
 
 		if result := db.Create(&newChange); result.Error != nil {
 			log.Printf("Error saving change event for %s: %v", urlEntry.URL, result.Error)
 			urlEntry.Status = fmt.Sprintf("Change detected, but failed to save diff: %v", result.Error)
-			db.Save(&urlEntry)
+			saveURLEntry(db, &urlEntry)
 			return urlEntry.Status
 		}
 
@@ -180,15 +277,48 @@ func CheckURLForChanges(urlID uint, diffViewBaseURL, botToken, chatID string) st
 		if diffViewBaseURL != "" {
 			diffLink = fmt.Sprintf("%s/diff/%d", diffViewBaseURL, newChange.ID)
 		}
-		sendTelegramNotification(botToken, chatID, urlEntry.URL, diffLink, false)
+		notification := Notification{Event: EventChange, URL: urlEntry.URL, Link: diffLink, DiffHTML: htmlDiff, Time: now,
+			AddedEndpoints: addedEndpoints, RemovedEndpoints: removedEndpoints}
+
+		// With a source map, the change is also broken down by original file.
+		if files := loadSources(&urlEntry, resp.Header, currentContent, requestConfigs); files != nil {
+			sourceChanges, err := recordSourceChanges(db, urlEntry.ID, newChange.ID, files, ignoreRules)
+			if err != nil {
+				log.Printf("Error saving source file changes for %s: %v", urlEntry.URL, err)
+			} else if len(sourceChanges) > 0 {
+				notification.SourceFiles = sourceChangeSummaries(sourceChanges)
+				notification.DiffHTML = sourceChangesDiffHTML(sourceChanges)
+			}
+		}
+		notify(notification)
 
 		urlEntry.LastContent = currentContent
 		urlEntry.Status = fmt.Sprintf("Change detected at %s", now.Format("2006-01-02 15:04 UTC"))
+	} else if contentChanged {
+		// Only ignored parts changed; keep the latest content as the new baseline,
+		// with its snapshot and headers like any other version.
+		if _, err := saveSnapshot(db, urlEntry.ID, currentContent, resp.Header, now); err != nil {
+			log.Printf("Error saving snapshot for %s: %v", urlEntry.URL, err)
+		}
+		urlEntry.LastContent = currentContent
+		urlEntry.Status = "No changes (ignored differences only)"
+		// The original files move on with it, or the next change would be
+		// compared against stale ones.
+		if files := loadSources(&urlEntry, resp.Header, currentContent, requestConfigs); files != nil {
+			if _, err := recordSourceChanges(db, urlEntry.ID, 0, files, ignoreRules); err != nil {
+				log.Printf("Error saving source files for %s: %v", urlEntry.URL, err)
+			}
+		}
 	} else {
 		urlEntry.Status = "No changes"
 	}
+	if contentChanged {
+		// Chunk hashes change with every deploy, even when the bundle's own
+		// changes are all ignored.
+		discoverChunks(db, &urlEntry, currentContent)
+	}
 
-	if result := db.Save(&urlEntry); result.Error != nil {
+	if result := saveURLEntry(db, &urlEntry); result.Error != nil {
 		log.Printf("Error updating URL status for %s: %v", urlEntry.URL, result.Error)
 		return fmt.Sprintf("Checked %s: %s (DB update error: %v)", urlEntry.URL, urlEntry.Status, result.Error)
 	}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-js-watcher/database"
+	"go-js-watcher/models"
+)
+
+func TestSaveURLEntryKeepsConcurrentEdits(t *testing.T) {
+	db := newTestDB(t)
+	urlEntry := models.WatchedUrl{URL: "https://example.com/app.js", IntervalSeconds: 300, IsActive: true}
+	if err := db.Create(&urlEntry).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// A check loads the row, then the URL is edited while the check runs.
+	checked := urlEntry
+	db.Model(&models.WatchedUrl{}).Where("id = ?", urlEntry.ID).
+		Updates(map[string]interface{}{"interval_seconds": 60, "beautify": true})
+
+	now := time.Now().UTC()
+	checked.Status = "OK"
+	checked.LastContent = "content"
+	checked.LastChecked = &now
+	if result := saveURLEntry(db, &checked); result.Error != nil || result.RowsAffected != 1 {
+		t.Fatalf("saveURLEntry = %v, %d rows", result.Error, result.RowsAffected)
+	}
+
+	var saved models.WatchedUrl
+	db.First(&saved, urlEntry.ID)
+	if saved.IntervalSeconds != 60 || !saved.Beautify {
+		t.Errorf("the check overwrote the edit: interval %d, beautify %v", saved.IntervalSeconds, saved.Beautify)
+	}
+	if saved.Status != "OK" || saved.LastContent != "content" || saved.LastChecked == nil {
+		t.Errorf("check result not saved: %+v", saved)
+	}
+}
+
+func TestSaveURLEntryDropsResultOfMovedURL(t *testing.T) {
+	db := newTestDB(t)
+	urlEntry := models.WatchedUrl{URL: "https://example.com/old.js", ETag: `"old"`}
+	if err := db.Create(&urlEntry).Error; err != nil {
+		t.Fatal(err)
+	}
+	checked := urlEntry
+	db.Model(&models.WatchedUrl{}).Where("id = ?", urlEntry.ID).
+		Updates(map[string]interface{}{"url": "https://example.com/new.js", "e_tag": ""})
+
+	checked.ETag = `"stale"`
+	checked.LastContent = "old content"
+	if result := saveURLEntry(db, &checked); result.Error != nil || result.RowsAffected != 0 {
+		t.Fatalf("saveURLEntry = %v, %d rows, want the result dropped", result.Error, result.RowsAffected)
+	}
+	var saved models.WatchedUrl
+	db.First(&saved, urlEntry.ID)
+	if saved.URL != "https://example.com/new.js" || saved.ETag != "" || saved.LastContent != "" {
+		t.Errorf("row after the stale save = %q %q %q", saved.URL, saved.ETag, saved.LastContent)
+	}
+}
+
+func TestSaveURLEntrySkipsArchivedURL(t *testing.T) {
+	db := newTestDB(t)
+	urlEntry := models.WatchedUrl{URL: "https://example.com/app.js"}
+	if err := db.Create(&urlEntry).Error; err != nil {
+		t.Fatal(err)
+	}
+	checked := urlEntry
+	if err := ArchiveURL(db, &urlEntry); err != nil {
+		t.Fatal(err)
+	}
+	checked.Status = "OK"
+	saveURLEntry(db, &checked)
+
+	var count int64
+	db.Model(&models.WatchedUrl{}).Count(&count)
+	if count != 0 {
+		t.Error("saving a check result brought an archived URL back")
+	}
+}
+
+func TestCheckSavesSnapshotOfIgnoredChange(t *testing.T) {
+	defer func(saved []Notifier) { notifiers = saved }(notifiers)
+	notifiers = []Notifier{&recordingNotifier{}}
+	useTestDB(t)
+
+	content := "var build=1;"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Build", "2")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	urlEntry := models.WatchedUrl{URL: server.URL + "/app.js", AllowPrivateTarget: true, LastContent: content}
+	if err := database.DB.Create(&urlEntry).Error; err != nil {
+		t.Fatal(err)
+	}
+	database.DB.Create(&models.IgnoreRule{URLID: &urlEntry.ID, Kind: "regex", Pattern: `build=\d+`})
+
+	content = "var build=2;"
+	CheckURLForChanges(urlEntry.ID, "")
+
+	var snapshot models.Snapshot
+	if err := database.DB.Where("url_id = ? AND content_hash = ?", urlEntry.ID, ContentHash(content)).First(&snapshot).Error; err != nil {
+		t.Fatalf("no snapshot of the new baseline: %v", err)
+	}
+	var headers http.Header
+	if err := json.Unmarshal([]byte(snapshot.Headers), &headers); err != nil {
+		t.Fatalf("snapshot headers %q: %v", snapshot.Headers, err)
+	}
+	if headers.Get("X-Build") != "2" {
+		t.Errorf("snapshot headers = %v, want the response's", headers)
+	}
+	if cookie := headers.Get("Set-Cookie"); cookie == "session=secret" {
+		t.Error("the cookie was stored without redaction")
+	}
+	var changes int64
+	database.DB.Model(&models.ChangeEvent{}).Count(&changes)
+	if changes != 0 {
+		t.Errorf("%d changes recorded for an ignored difference", changes)
+	}
+}
+
+func TestCheckSendsValidators(t *testing.T) {
+	defer func(saved []Notifier) { notifiers = saved }(notifiers)
+	recorder := &recordingNotifier{}
+	notifiers = []Notifier{recorder}
+	useTestDB(t)
+
+	const lastModified = "Mon, 05 Oct 2026 10:00:00 GMT"
+	var requests []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Clone())
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Write([]byte("var version = 1;"))
+	}))
+	defer server.Close()
+
+	urlEntry := models.WatchedUrl{URL: server.URL + "/app.js", AllowPrivateTarget: true}
+	if err := database.DB.Create(&urlEntry).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	CheckURLForChanges(urlEntry.ID, "")
+	var saved models.WatchedUrl
+	database.DB.First(&saved, urlEntry.ID)
+	if saved.ETag != `"v1"` || saved.LastModified != lastModified {
+		t.Fatalf("validators after the first check = %q, %q", saved.ETag, saved.LastModified)
+	}
+	firstChecked := *saved.LastChecked
+
+	CheckURLForChanges(urlEntry.ID, "")
+	if len(requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(requests))
+	}
+	if requests[0].Get("If-None-Match") != "" {
+		t.Error("the first check sent validators it didn't have")
+	}
+	if requests[1].Get("If-None-Match") != `"v1"` || requests[1].Get("If-Modified-Since") != lastModified {
+		t.Errorf("second check sent If-None-Match %q, If-Modified-Since %q", requests[1].Get("If-None-Match"), requests[1].Get("If-Modified-Since"))
+	}
+
+	database.DB.First(&saved, urlEntry.ID)
+	if saved.Status != "No changes" || saved.LastContent != "var version = 1;" || saved.ETag != `"v1"` {
+		t.Errorf("after 304: status %q, content %q, etag %q", saved.Status, saved.LastContent, saved.ETag)
+	}
+	if !saved.LastChecked.After(firstChecked) {
+		t.Error("a 304 didn't update the last check time")
+	}
+	if len(recorder.events) != 0 {
+		t.Errorf("notified %q for an unchanged URL", recorder.events)
+	}
+}
+
+func TestCheckIgnoresNotModifiedWithoutContent(t *testing.T) {
+	useTestDB(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+
+	// A 304 is only meaningful when there is a stored version to keep.
+	urlEntry := models.WatchedUrl{URL: server.URL + "/app.js", AllowPrivateTarget: true, ETag: `"v1"`}
+	if err := database.DB.Create(&urlEntry).Error; err != nil {
+		t.Fatal(err)
+	}
+	CheckURLForChanges(urlEntry.ID, "")
+
+	var saved models.WatchedUrl
+	database.DB.First(&saved, urlEntry.ID)
+	if saved.Status == "No changes" || saved.LastChecked != nil {
+		t.Errorf("a 304 without stored content was treated as unchanged: status %q", saved.Status)
+	}
+}